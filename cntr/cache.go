@@ -0,0 +1,685 @@
+package cntr
+
+import (
+	"container/heap"
+	"container/list"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type EvictionPolicy int
+
+const (
+	// EvictLRU evicts the least recently used entry
+	EvictLRU EvictionPolicy = iota
+	// EvictLFU evicts the least frequently used entry, the least recently
+	// used one among the entries with the same frequency
+	EvictLFU
+)
+
+const defaultCacheShards = 16
+
+var (
+	ErrCacheNoLoader = errors.New("cache_no_loader")
+	// ErrCacheLoaderGoexit is shared with the waiters if the loader called
+	// runtime.Goexit
+	ErrCacheLoaderGoexit = errors.New("cache_loader_goexit")
+)
+
+// CacheStats is a snapshot of the statistics of a cache
+type CacheStats struct {
+	Hits        uint64
+	Misses      uint64
+	Loads       uint64 // successful loads
+	LoadErrors  uint64
+	Evictions   uint64 // entries dropped for the cost limit
+	Expirations uint64 // entries dropped for the ttl
+	Len         int
+	Cost        int64
+}
+
+// HitRate returns hits / (hits + misses), 0 if there is no access
+func (s CacheStats) HitRate() float64 {
+	total := s.Hits + s.Misses
+	if total == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(total)
+}
+
+type cacheEntry[K comparable, V any] struct {
+	key      K
+	value    V
+	cost     int64
+	expireAt time.Time // zero for never
+
+	elem  *list.Element // lru
+	freq  uint64        // lfu
+	tick  uint64        // lfu, the last access
+	index int           // lfu, index in the heap
+}
+
+func (e *cacheEntry[K, V]) expired(now time.Time) bool {
+	return !e.expireAt.IsZero() && !now.Before(e.expireAt)
+}
+
+// cacheHeap is a min-heap of (freq, tick)
+type cacheHeap[K comparable, V any] []*cacheEntry[K, V]
+
+func (h cacheHeap[K, V]) Len() int { return len(h) }
+
+func (h cacheHeap[K, V]) Less(i, j int) bool {
+	if h[i].freq != h[j].freq {
+		return h[i].freq < h[j].freq
+	}
+	return h[i].tick < h[j].tick
+}
+
+func (h cacheHeap[K, V]) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *cacheHeap[K, V]) Push(x interface{}) {
+	e := x.(*cacheEntry[K, V])
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *cacheHeap[K, V]) Pop() interface{} {
+	old := *h
+	n := len(old)
+	e := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return e
+}
+
+type cacheShard[K comparable, V any] struct {
+	mx     sync.Mutex
+	policy EvictionPolicy
+	m      map[K]*cacheEntry[K, V]
+	lru    *list.List // the front is the most recently used
+	lfu    cacheHeap[K, V]
+	tick   uint64
+	cost   int64
+	total  *int64 // the total cost of the cache, updated atomically
+}
+
+func newCacheShard[K comparable, V any](policy EvictionPolicy, total *int64) *cacheShard[K, V] {
+	return &cacheShard[K, V]{
+		policy: policy,
+		m:      map[K]*cacheEntry[K, V]{},
+		lru:    list.New(),
+		total:  total,
+	}
+}
+
+func (s *cacheShard[K, V]) unsafeTouch(e *cacheEntry[K, V]) {
+	if s.policy == EvictLFU {
+		s.tick++
+		e.freq++
+		e.tick = s.tick
+		heap.Fix(&s.lfu, e.index)
+	} else {
+		s.lru.MoveToFront(e.elem)
+	}
+}
+
+func (s *cacheShard[K, V]) unsafeAdd(e *cacheEntry[K, V]) {
+	if s.policy == EvictLFU {
+		s.tick++
+		e.freq++
+		e.tick = s.tick
+		heap.Push(&s.lfu, e)
+	} else {
+		e.elem = s.lru.PushFront(e)
+	}
+	s.m[e.key] = e
+	s.cost += e.cost
+	atomic.AddInt64(s.total, e.cost)
+}
+
+func (s *cacheShard[K, V]) unsafeRemove(e *cacheEntry[K, V]) {
+	if s.policy == EvictLFU {
+		heap.Remove(&s.lfu, e.index)
+	} else {
+		s.lru.Remove(e.elem)
+	}
+	delete(s.m, e.key)
+	s.cost -= e.cost
+	atomic.AddInt64(s.total, -e.cost)
+}
+
+func (s *cacheShard[K, V]) unsafeVictim() *cacheEntry[K, V] {
+	if s.policy == EvictLFU {
+		if len(s.lfu) == 0 {
+			return nil
+		}
+		return s.lfu[0]
+	}
+	if b := s.lru.Back(); b != nil {
+		return b.Value.(*cacheEntry[K, V])
+	}
+	return nil
+}
+
+type cacheCall[V any] struct {
+	done chan struct{} // closed once finished
+	val  V
+	err  error
+}
+
+/**
+ * Cache is a concurrent key-value cache, split into shards to reduce the
+ * contention of the locks
+ *
+ * Each entry has a cost (1 by default), the entries are evicted by the
+ * policy (LRU by default) once the total cost exceeds the limit. The
+ * victims are taken from the shard of the new entry first, then from the
+ * other shards. The expired entries are dropped lazily on access, or by
+ * Purge.
+ *
+ * The loaders are deduplicated: concurrent loads of the same key are
+ * executed only once, and the callers share the result.
+ *
+ * NOTE: the setters should be called before the cache is used.
+ */
+type Cache[K comparable, V any] struct {
+	shards    []*cacheShard[K, V]
+	policy    EvictionPolicy
+	maxCost   int64 // 0 for unlimited
+	cost      int64 // the total cost, updated atomically
+	ttl       time.Duration
+	costFunc  func(value V) int64
+	hasher    func(key K) uint64
+	loader    func(key K) (V, error)
+	onEvicted func(key K, value V)
+
+	fmx   sync.Mutex // guards calls
+	calls map[K]*cacheCall[V]
+
+	hits        uint64
+	misses      uint64
+	loads       uint64
+	loadErrors  uint64
+	evictions   uint64
+	expirations uint64
+}
+
+// NewCache creates an unlimited LRU cache
+func NewCache[K comparable, V any]() *Cache[K, V] {
+	c := &Cache[K, V]{
+		calls: map[K]*cacheCall[V]{},
+	}
+	c.SetShards(defaultCacheShards)
+	return c
+}
+
+// SetShards sets the number of shards, at least 1, all entries are dropped
+func (c *Cache[K, V]) SetShards(n int) *Cache[K, V] {
+	if n < 1 {
+		n = 1
+	}
+	c.shards = make([]*cacheShard[K, V], n)
+	atomic.StoreInt64(&c.cost, 0)
+	for i := range c.shards {
+		c.shards[i] = newCacheShard[K, V](c.policy, &c.cost)
+	}
+	return c
+}
+
+// SetPolicy sets the eviction policy, all entries are dropped
+func (c *Cache[K, V]) SetPolicy(policy EvictionPolicy) *Cache[K, V] {
+	c.policy = policy
+	return c.SetShards(len(c.shards))
+}
+
+// SetMaxCost sets the limit of the total cost, 0 for unlimited
+func (c *Cache[K, V]) SetMaxCost(maxCost int64) *Cache[K, V] {
+	if maxCost < 0 {
+		maxCost = 0
+	}
+	c.maxCost = maxCost
+	return c
+}
+
+// SetCostFunc sets the function to calculate the cost of a value
+// e.g. the size in bytes. The cost of each entry is 1 by default.
+func (c *Cache[K, V]) SetCostFunc(f func(value V) int64) *Cache[K, V] {
+	c.costFunc = f
+	return c
+}
+
+// SetTTL sets the default ttl of the entries, 0 for never expiring
+func (c *Cache[K, V]) SetTTL(ttl time.Duration) *Cache[K, V] {
+	c.ttl = ttl
+	return c
+}
+
+// SetHasher sets the hash function to pick the shard of a key
+// The strings, numbers and bools are hashed without it, and the pointers
+// and the channels by the identity. It is required for the other keys
+// (e.g. the structs) unless there is only one shard, or else they panic.
+func (c *Cache[K, V]) SetHasher(f func(key K) uint64) *Cache[K, V] {
+	c.hasher = f
+	return c
+}
+
+// SetLoader sets the default loader of Load
+func (c *Cache[K, V]) SetLoader(f func(key K) (V, error)) *Cache[K, V] {
+	c.loader = f
+	return c
+}
+
+// SetEvictedFunc sets a callback for the entries dropped by the cost limit
+// or the ttl. It is not called for Delete and Clear.
+func (c *Cache[K, V]) SetEvictedFunc(f func(key K, value V)) *Cache[K, V] {
+	c.onEvicted = f
+	return c
+}
+
+func (c *Cache[K, V]) shard(key K) *cacheShard[K, V] {
+	return c.shards[c.shardIndex(key)]
+}
+
+func (c *Cache[K, V]) shardIndex(key K) int {
+	if len(c.shards) == 1 {
+		return 0
+	}
+	var h uint64
+	if c.hasher != nil {
+		h = c.hasher(key)
+	} else if hh, ok := hashCacheKey(key); ok {
+		h = hh
+	} else {
+		panic(fmt.Sprintf("Cache: SetHasher or SetShards(1) is required for the keys of %T", key))
+	}
+	return int(h % uint64(len(c.shards)))
+}
+
+func (c *Cache[K, V]) overCost(extra int64) bool {
+	return c.maxCost > 0 && atomic.LoadInt64(&c.cost)+extra > c.maxCost
+}
+
+// evictOthers evicts the entries of the shards other than skip, until the
+// total cost is within the limit or nothing is left
+func (c *Cache[K, V]) evictOthers(skip int, now time.Time) (evicted, expired []*cacheEntry[K, V]) {
+	n := len(c.shards)
+	for empty := 0; empty < n-1 && c.overCost(0); {
+		empty = 0
+		for i := 1; i < n && c.overCost(0); i++ {
+			s := c.shards[(skip+i)%n]
+			s.mx.Lock()
+			v := s.unsafeVictim()
+			if v == nil {
+				s.mx.Unlock()
+				empty++
+				continue
+			}
+			s.unsafeRemove(v)
+			s.mx.Unlock()
+			if v.expired(now) {
+				expired = append(expired, v)
+			} else {
+				evicted = append(evicted, v)
+			}
+		}
+	}
+	return
+}
+
+func (c *Cache[K, V]) notifyEvicted(entries []*cacheEntry[K, V]) {
+	if c.onEvicted == nil {
+		return
+	}
+	for _, e := range entries {
+		c.onEvicted(e.key, e.value)
+	}
+}
+
+// Get returns the value of the key, and updates its usage
+func (c *Cache[K, V]) Get(key K) (value V, ok bool) {
+	s := c.shard(key)
+	s.mx.Lock()
+	e, found := s.m[key]
+	if found && e.expired(time.Now()) {
+		s.unsafeRemove(e)
+		s.mx.Unlock()
+		atomic.AddUint64(&c.expirations, 1)
+		atomic.AddUint64(&c.misses, 1)
+		c.notifyEvicted([]*cacheEntry[K, V]{e})
+		return
+	}
+	if !found {
+		s.mx.Unlock()
+		atomic.AddUint64(&c.misses, 1)
+		return
+	}
+	s.unsafeTouch(e)
+	value = e.value
+	s.mx.Unlock()
+	atomic.AddUint64(&c.hits, 1)
+	return value, true
+}
+
+// Peek returns the value of the key, without updating the usage
+// and the statistics
+func (c *Cache[K, V]) Peek(key K) (value V, ok bool) {
+	s := c.shard(key)
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	if e, found := s.m[key]; found && !e.expired(time.Now()) {
+		return e.value, true
+	}
+	return
+}
+
+// Has returns true if the key is in the cache and not expired
+func (c *Cache[K, V]) Has(key K) bool {
+	_, ok := c.Peek(key)
+	return ok
+}
+
+// Set adds or replaces the value with the default ttl
+// It returns false if the cost of the value exceeds the limit, the value
+// is not stored in this case, and the old value is dropped.
+func (c *Cache[K, V]) Set(key K, value V) bool {
+	return c.SetWithTTL(key, value, c.ttl)
+}
+
+// SetWithTTL adds or replaces the value, ttl <= 0 for never expiring
+func (c *Cache[K, V]) SetWithTTL(key K, value V, ttl time.Duration) bool {
+	cost := int64(1)
+	if c.costFunc != nil {
+		cost = c.costFunc(value)
+	}
+	e := &cacheEntry[K, V]{key: key, value: value, cost: cost}
+	now := time.Now()
+	if ttl > 0 {
+		e.expireAt = now.Add(ttl)
+	}
+
+	idx := c.shardIndex(key)
+	s := c.shards[idx]
+	s.mx.Lock()
+	if old, found := s.m[key]; found {
+		// keep the frequency for lfu
+		e.freq = old.freq
+		s.unsafeRemove(old)
+	}
+	if c.maxCost > 0 && cost > c.maxCost {
+		s.mx.Unlock()
+		return false
+	}
+	var evicted, expired []*cacheEntry[K, V]
+	for c.overCost(cost) {
+		v := s.unsafeVictim()
+		if v == nil {
+			break
+		}
+		s.unsafeRemove(v)
+		if v.expired(now) {
+			expired = append(expired, v)
+		} else {
+			evicted = append(evicted, v)
+		}
+	}
+	s.unsafeAdd(e)
+	s.mx.Unlock()
+	if c.overCost(0) {
+		// the shard locks are never nested
+		ev, ex := c.evictOthers(idx, now)
+		evicted = append(evicted, ev...)
+		expired = append(expired, ex...)
+	}
+
+	atomic.AddUint64(&c.evictions, uint64(len(evicted)))
+	atomic.AddUint64(&c.expirations, uint64(len(expired)))
+	c.notifyEvicted(expired)
+	c.notifyEvicted(evicted)
+	return true
+}
+
+// Delete removes the key, returns true if it existed
+func (c *Cache[K, V]) Delete(key K) bool {
+	s := c.shard(key)
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	if e, found := s.m[key]; found {
+		s.unsafeRemove(e)
+		return true
+	}
+	return false
+}
+
+// Load returns the value of the key, or loads it by the default loader
+func (c *Cache[K, V]) Load(key K) (V, error) {
+	if c.loader == nil {
+		var zero V
+		return zero, ErrCacheNoLoader
+	}
+	return c.GetOrLoad(key, c.loader)
+}
+
+// GetOrLoad returns the value of the key, or loads it by f and stores it
+// Concurrent loads of the same key wait for the first one, and share its
+// result. The errors are not cached.
+func (c *Cache[K, V]) GetOrLoad(key K, f func(key K) (V, error)) (V, error) {
+	if v, ok := c.Get(key); ok {
+		return v, nil
+	}
+	c.fmx.Lock()
+	if call, ok := c.calls[key]; ok {
+		c.fmx.Unlock()
+		<-call.done
+		return call.val, call.err
+	}
+	call := &cacheCall[V]{done: make(chan struct{})}
+	c.calls[key] = call
+	c.fmx.Unlock()
+
+	c.load(key, call, f)
+	return call.val, call.err
+}
+
+func (c *Cache[K, V]) load(key K, call *cacheCall[V], f func(key K) (V, error)) {
+	normalReturn, recovered := false, false
+	var r interface{}
+	defer func() {
+		if !normalReturn && !recovered {
+			// nothing to recover from runtime.Goexit
+			call.err = ErrCacheLoaderGoexit
+		}
+		if call.err != nil {
+			atomic.AddUint64(&c.loadErrors, 1)
+		} else {
+			atomic.AddUint64(&c.loads, 1)
+			c.Set(key, call.val)
+		}
+		c.fmx.Lock()
+		delete(c.calls, key)
+		c.fmx.Unlock()
+		close(call.done)
+		if recovered {
+			panic(r)
+		}
+	}()
+	func() {
+		defer func() {
+			if !normalReturn {
+				// recover returns nil for runtime.Goexit, which goes on
+				r = recover()
+				call.err = fmt.Errorf("cache_loader_panicked: %v", r)
+			}
+		}()
+		call.val, call.err = f(key)
+		normalReturn = true
+	}()
+	if !normalReturn {
+		recovered = true
+	}
+}
+
+// Purge drops all the expired entries, returns the number of them
+func (c *Cache[K, V]) Purge() int {
+	now := time.Now()
+	total := 0
+	for _, s := range c.shards {
+		var expired []*cacheEntry[K, V]
+		s.mx.Lock()
+		for _, e := range s.m {
+			if e.expired(now) {
+				expired = append(expired, e)
+			}
+		}
+		for _, e := range expired {
+			s.unsafeRemove(e)
+		}
+		s.mx.Unlock()
+		atomic.AddUint64(&c.expirations, uint64(len(expired)))
+		c.notifyEvicted(expired)
+		total += len(expired)
+	}
+	return total
+}
+
+// Len returns the number of entries, including the expired ones not
+// purged yet
+func (c *Cache[K, V]) Len() (n int) {
+	for _, s := range c.shards {
+		s.mx.Lock()
+		n += len(s.m)
+		s.mx.Unlock()
+	}
+	return
+}
+
+// Cost returns the total cost of the entries
+func (c *Cache[K, V]) Cost() int64 {
+	return atomic.LoadInt64(&c.cost)
+}
+
+// Keys returns the keys not expired, in no particular order
+func (c *Cache[K, V]) Keys() []K {
+	now := time.Now()
+	var keys []K
+	for _, s := range c.shards {
+		s.mx.Lock()
+		for k, e := range s.m {
+			if !e.expired(now) {
+				keys = append(keys, k)
+			}
+		}
+		s.mx.Unlock()
+	}
+	return keys
+}
+
+// Clear drops all the entries, the statistics are kept
+func (c *Cache[K, V]) Clear() {
+	for _, s := range c.shards {
+		s.mx.Lock()
+		s.m = map[K]*cacheEntry[K, V]{}
+		s.lru.Init()
+		s.lfu = nil
+		atomic.AddInt64(s.total, -s.cost)
+		s.cost = 0
+		s.mx.Unlock()
+	}
+}
+
+func (c *Cache[K, V]) Stats() CacheStats {
+	st := CacheStats{
+		Hits:        atomic.LoadUint64(&c.hits),
+		Misses:      atomic.LoadUint64(&c.misses),
+		Loads:       atomic.LoadUint64(&c.loads),
+		LoadErrors:  atomic.LoadUint64(&c.loadErrors),
+		Evictions:   atomic.LoadUint64(&c.evictions),
+		Expirations: atomic.LoadUint64(&c.expirations),
+	}
+	for _, s := range c.shards {
+		s.mx.Lock()
+		st.Len += len(s.m)
+		st.Cost += s.cost
+		s.mx.Unlock()
+	}
+	return st
+}
+
+// ResetStats resets the counters of the statistics
+func (c *Cache[K, V]) ResetStats() {
+	for _, p := range []*uint64{&c.hits, &c.misses, &c.loads, &c.loadErrors, &c.evictions, &c.expirations} {
+		atomic.StoreUint64(p, 0)
+	}
+}
+
+// mix64 is the finalizer of splitmix64
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// fnv64a hashes a string without allocation
+func fnv64a(s string) uint64 {
+	h := uint64(14695981039346656037)
+	for i := 0; i < len(s); i++ {
+		h ^= uint64(s[i])
+		h *= 1099511628211
+	}
+	return h
+}
+
+// hashCacheKey hashes the strings, numbers, bools (including the named
+// types of them) by the value, and the pointers and the channels by the
+// identity. It returns false for the other types, e.g. the structs, whose
+// values may hold pointers.
+func hashCacheKey(key interface{}) (uint64, bool) {
+	switch k := key.(type) {
+	case nil:
+		return 0, true
+	case string:
+		return fnv64a(k), true
+	case int:
+		return mix64(uint64(k)), true
+	case int64:
+		return mix64(uint64(k)), true
+	case uint64:
+		return mix64(k), true
+	}
+	v := reflect.ValueOf(key)
+	switch v.Kind() {
+	case reflect.String:
+		return fnv64a(v.String()), true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return mix64(uint64(v.Int())), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return mix64(v.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		f := v.Float()
+		if f == 0 {
+			// -0 == 0
+			f = 0
+		}
+		return mix64(math.Float64bits(f)), true
+	case reflect.Bool:
+		if v.Bool() {
+			return 1, true
+		}
+		return 0, true
+	case reflect.Pointer, reflect.Chan, reflect.UnsafePointer:
+		// never by the value pointed to, which may change
+		return mix64(uint64(v.Pointer())), true
+	}
+	return 0, false
+}
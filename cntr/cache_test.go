@@ -0,0 +1,290 @@
+package cntr
+
+import (
+	"errors"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/argcv/stork/assert"
+)
+
+func TestCache_LRU(t *testing.T) {
+	var evicted []string
+	c := NewCache[string, int]().SetShards(1).SetMaxCost(3).
+		SetEvictedFunc(func(key string, value int) {
+			evicted = append(evicted, key)
+		})
+	c.Set("a", 1)
+	c.Set("b", 2)
+	c.Set("c", 3)
+	// a is the most recently used now
+	v, ok := c.Get("a")
+	assert.ExpectTrue(t, ok)
+	assert.ExpectEQ(t, 1, v)
+
+	c.Set("d", 4)
+	assert.ExpectEQ(t, []string{"b"}, evicted)
+	assert.ExpectFalse(t, c.Has("b"))
+	assert.ExpectEQ(t, 3, c.Len())
+
+	// update does not evict
+	c.Set("c", 30)
+	assert.ExpectEQ(t, 1, len(evicted))
+	v, _ = c.Get("c")
+	assert.ExpectEQ(t, 30, v)
+
+	assert.ExpectTrue(t, c.Delete("c"))
+	assert.ExpectFalse(t, c.Delete("c"))
+
+	st := c.Stats()
+	assert.ExpectEQ(t, uint64(2), st.Hits)
+	assert.ExpectEQ(t, uint64(1), st.Evictions)
+	assert.ExpectEQ(t, 2, st.Len)
+}
+
+func TestCache_LFU(t *testing.T) {
+	c := NewCache[string, int]().SetShards(1).SetPolicy(EvictLFU).SetMaxCost(3)
+	c.Set("a", 1)
+	c.Set("b", 2)
+	c.Set("c", 3)
+	for i := 0; i < 3; i++ {
+		c.Get("a")
+		c.Get("c")
+	}
+	c.Get("b")
+	c.Get("a")
+
+	// b is the least frequently used
+	c.Set("d", 4)
+	assert.ExpectFalse(t, c.Has("b"))
+	// d is the least frequently used now
+	c.Set("e", 5)
+	assert.ExpectFalse(t, c.Has("d"))
+	assert.ExpectTrue(t, c.Has("a"))
+	assert.ExpectTrue(t, c.Has("c"))
+	assert.ExpectTrue(t, c.Has("e"))
+}
+
+func TestCache_Cost(t *testing.T) {
+	c := NewCache[string, string]().SetShards(1).SetMaxCost(10).
+		SetCostFunc(func(value string) int64 {
+			return int64(len(value))
+		})
+	assert.ExpectTrue(t, c.Set("a", "1234"))
+	assert.ExpectTrue(t, c.Set("b", "1234"))
+	assert.ExpectEQ(t, int64(8), c.Cost())
+	assert.ExpectTrue(t, c.Set("c", "123"))
+	assert.ExpectFalse(t, c.Has("a"))
+	assert.ExpectEQ(t, int64(7), c.Cost())
+
+	// too large to store
+	assert.ExpectFalse(t, c.Set("b", "12345678901"))
+	assert.ExpectFalse(t, c.Has("b"))
+	assert.ExpectEQ(t, int64(3), c.Cost())
+}
+
+func TestCache_TTL(t *testing.T) {
+	var expired int32
+	c := NewCache[int, int]().SetTTL(20 * time.Millisecond).
+		SetEvictedFunc(func(key int, value int) {
+			atomic.AddInt32(&expired, 1)
+		})
+	c.Set(1, 1)
+	c.Set(2, 2)
+	c.Set(3, 3)
+	c.SetWithTTL(4, 4, 0)
+	_, ok := c.Get(1)
+	assert.ExpectTrue(t, ok)
+
+	time.Sleep(30 * time.Millisecond)
+	_, ok = c.Get(1)
+	assert.ExpectFalse(t, ok)
+	assert.ExpectEQ(t, []int{4}, c.Keys())
+	assert.ExpectEQ(t, 3, c.Len())
+	assert.ExpectEQ(t, 2, c.Purge())
+	assert.ExpectEQ(t, 1, c.Len())
+	assert.ExpectEQ(t, int32(3), atomic.LoadInt32(&expired))
+	assert.ExpectEQ(t, uint64(3), c.Stats().Expirations)
+}
+
+func TestCache_Load(t *testing.T) {
+	c := NewCache[string, int]()
+	_, err := c.Load("x")
+	assert.ExpectEQ(t, ErrCacheNoLoader, err)
+
+	var calls int32
+	release := make(chan struct{})
+	c.SetLoader(func(key string) (int, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		if key == "bad" {
+			return 0, errors.New("bad key")
+		}
+		return len(key), nil
+	})
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, err := c.Load("hello")
+			assert.ExpectNoErr(t, err)
+			assert.ExpectEQ(t, 5, v)
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	assert.ExpectEQ(t, int32(1), atomic.LoadInt32(&calls))
+
+	// cached now
+	v, err := c.Load("hello")
+	assert.ExpectNoErr(t, err)
+	assert.ExpectEQ(t, 5, v)
+	assert.ExpectEQ(t, int32(1), atomic.LoadInt32(&calls))
+
+	// errors are not cached
+	_, err = c.Load("bad")
+	assert.ExpectErr(t, err)
+	_, err = c.Load("bad")
+	assert.ExpectErr(t, err)
+	assert.ExpectEQ(t, int32(3), atomic.LoadInt32(&calls))
+
+	st := c.Stats()
+	assert.ExpectEQ(t, uint64(1), st.Loads)
+	assert.ExpectEQ(t, uint64(2), st.LoadErrors)
+	assert.ExpectGE(t, st.HitRate(), 0.0)
+}
+
+func TestCache_CostSharded(t *testing.T) {
+	// a value costing more than maxCost / shards is accepted
+	c := NewCache[string, string]().SetMaxCost(100).
+		SetCostFunc(func(value string) int64 {
+			return int64(len(value))
+		})
+	assert.ExpectTrue(t, c.Set("a", string(make([]byte, 50))))
+	assert.ExpectTrue(t, c.Set("b", string(make([]byte, 100))))
+	assert.ExpectFalse(t, c.Has("a"))
+	assert.ExpectEQ(t, int64(100), c.Cost())
+	assert.ExpectFalse(t, c.Set("c", string(make([]byte, 101))))
+	assert.ExpectEQ(t, int64(100), c.Cost())
+
+	// the total never exceeds the limit with more shards than the cost
+	d := NewCache[int, int]().SetMaxCost(10)
+	for i := 0; i < 100; i++ {
+		assert.ExpectTrue(t, d.Set(i, i))
+		assert.ExpectGE(t, int64(10), d.Cost())
+	}
+	assert.ExpectEQ(t, 10, d.Len())
+	assert.ExpectEQ(t, uint64(90), d.Stats().Evictions)
+	d.Clear()
+	assert.ExpectEQ(t, int64(0), d.Cost())
+}
+
+func TestCache_Keys(t *testing.T) {
+	type node struct {
+		name string
+	}
+	// the pointers are hashed by the identity
+	c := NewCache[*node, int]()
+	keys := make([]*node, 100)
+	for i := range keys {
+		keys[i] = &node{name: "n"}
+		c.Set(keys[i], i)
+	}
+	for i, k := range keys {
+		k.name = "changed"
+		v, ok := c.Get(k)
+		assert.ExpectTrue(t, ok)
+		assert.ExpectEQ(t, i, v)
+		c.Set(k, -i)
+	}
+	assert.ExpectEQ(t, 100, c.Len())
+	for _, k := range keys {
+		assert.ExpectTrue(t, c.Delete(k))
+	}
+	assert.ExpectEQ(t, 0, c.Len())
+
+	// the named types of the primitives are hashed by the value
+	type id string
+	d := NewCache[id, int]()
+	d.Set("a", 1)
+	v, ok := d.Get(id("a"))
+	assert.ExpectTrue(t, ok)
+	assert.ExpectEQ(t, 1, v)
+
+	// the structs require a hasher, or a single shard
+	type pair struct {
+		a, b int
+	}
+	e := NewCache[pair, int]()
+	func() {
+		defer func() {
+			assert.ExpectTrue(t, recover() != nil)
+		}()
+		e.Set(pair{1, 2}, 3)
+	}()
+	e.SetHasher(func(key pair) uint64 {
+		return uint64(key.a*31 + key.b)
+	})
+	e.Set(pair{1, 2}, 3)
+	v, ok = e.Get(pair{1, 2})
+	assert.ExpectTrue(t, ok)
+	assert.ExpectEQ(t, 3, v)
+	f := NewCache[pair, int]().SetShards(1)
+	f.Set(pair{1, 2}, 3)
+	assert.ExpectTrue(t, f.Has(pair{1, 2}))
+}
+
+func TestCache_Concurrent(t *testing.T) {
+	c := NewCache[int, int]().SetMaxCost(64)
+	wg := sync.WaitGroup{}
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				k := (g*31 + i) % 200
+				if _, ok := c.Get(k); !ok {
+					c.Set(k, k)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	assert.ExpectGE(t, int64(64), c.Cost())
+	st := c.Stats()
+	assert.ExpectEQ(t, uint64(8000), st.Hits+st.Misses)
+}
+
+func TestCache_LoadGoexit(t *testing.T) {
+	c := NewCache[string, int]()
+	release := make(chan struct{})
+	c.SetLoader(func(key string) (int, error) {
+		<-release
+		runtime.Goexit()
+		return 0, nil
+	})
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		c.Load("x")
+	}()
+	time.Sleep(5 * time.Millisecond)
+
+	done := make(chan error)
+	go func() {
+		_, err := c.Load("x")
+		done <- err
+	}()
+	time.Sleep(5 * time.Millisecond)
+	close(release)
+	<-exited
+	assert.ExpectEQ(t, ErrCacheLoaderGoexit, <-done)
+	assert.ExpectEQ(t, uint64(1), c.Stats().LoadErrors)
+	assert.ExpectEQ(t, 0, c.Len())
+}
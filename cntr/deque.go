@@ -0,0 +1,147 @@
+package cntr
+
+import (
+	"encoding/json"
+)
+
+const dequeMinCapacity = 8
+
+// Deque is a double-ended queue backed by a growable circular buffer
+// It is NOT thread safe.
+type Deque[T any] struct {
+	buf  []T
+	head int // index of the front item
+	size int
+}
+
+func NewDeque[T any](items ...T) *Deque[T] {
+	d := &Deque[T]{}
+	for _, item := range items {
+		d.PushBack(item)
+	}
+	return d
+}
+
+func (d *Deque[T]) grow() {
+	if d.size < len(d.buf) {
+		return
+	}
+	n := len(d.buf) * 2
+	if n < dequeMinCapacity {
+		n = dequeMinCapacity
+	}
+	buf := make([]T, n)
+	d.copyTo(buf)
+	d.buf = buf
+	d.head = 0
+}
+
+// copyTo copies the items in order to dst, len(dst) >= d.size
+func (d *Deque[T]) copyTo(dst []T) {
+	if d.size == 0 {
+		return
+	}
+	if d.head+d.size <= len(d.buf) {
+		copy(dst, d.buf[d.head:d.head+d.size])
+	} else {
+		n := copy(dst, d.buf[d.head:])
+		copy(dst[n:], d.buf[:d.size-n])
+	}
+}
+
+func (d *Deque[T]) index(i int) int {
+	return (d.head + i) % len(d.buf)
+}
+
+func (d *Deque[T]) PushBack(item T) {
+	d.grow()
+	d.buf[d.index(d.size)] = item
+	d.size++
+}
+
+func (d *Deque[T]) PushFront(item T) {
+	d.grow()
+	d.head = (d.head - 1 + len(d.buf)) % len(d.buf)
+	d.buf[d.head] = item
+	d.size++
+}
+
+func (d *Deque[T]) PopFront() (item T, ok bool) {
+	if d.size == 0 {
+		return
+	}
+	var zero T
+	item = d.buf[d.head]
+	d.buf[d.head] = zero
+	d.head = d.index(1)
+	d.size--
+	return item, true
+}
+
+func (d *Deque[T]) PopBack() (item T, ok bool) {
+	if d.size == 0 {
+		return
+	}
+	var zero T
+	i := d.index(d.size - 1)
+	item = d.buf[i]
+	d.buf[i] = zero
+	d.size--
+	return item, true
+}
+
+func (d *Deque[T]) Front() (item T, ok bool) {
+	if d.size == 0 {
+		return
+	}
+	return d.buf[d.head], true
+}
+
+func (d *Deque[T]) Back() (item T, ok bool) {
+	if d.size == 0 {
+		return
+	}
+	return d.buf[d.index(d.size-1)], true
+}
+
+// At returns the i-th item from the front, it panics if i is out of range
+func (d *Deque[T]) At(i int) T {
+	if i < 0 || i >= d.size {
+		panic("Deque: index out of range")
+	}
+	return d.buf[d.index(i)]
+}
+
+func (d *Deque[T]) Len() int {
+	return d.size
+}
+
+func (d *Deque[T]) Clear() {
+	d.buf = nil
+	d.head = 0
+	d.size = 0
+}
+
+// Items returns the items from the front to the back
+func (d *Deque[T]) Items() []T {
+	items := make([]T, d.size)
+	d.copyTo(items)
+	return items
+}
+
+// MarshalJSON encodes the deque as a json array from the front to the back
+func (d *Deque[T]) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.Items())
+}
+
+func (d *Deque[T]) UnmarshalJSON(data []byte) error {
+	var items []T
+	if err := json.Unmarshal(data, &items); err != nil {
+		return err
+	}
+	d.Clear()
+	for _, item := range items {
+		d.PushBack(item)
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package cntr
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/argcv/stork/assert"
+)
+
+func TestDeque(t *testing.T) {
+	d := NewDeque[int]()
+	_, ok := d.PopFront()
+	assert.ExpectFalse(t, ok)
+	_, ok = d.Back()
+	assert.ExpectFalse(t, ok)
+
+	// grow across the boundary of the buffer
+	for i := 0; i < 10; i++ {
+		d.PushBack(i)
+		d.PushFront(-i - 1)
+	}
+	assert.ExpectEQ(t, 20, d.Len())
+	assert.ExpectEQ(t, -10, d.At(0))
+	assert.ExpectEQ(t, 9, d.At(19))
+
+	v, ok := d.Front()
+	assert.ExpectTrue(t, ok)
+	assert.ExpectEQ(t, -10, v)
+	v, _ = d.Back()
+	assert.ExpectEQ(t, 9, v)
+
+	v, _ = d.PopFront()
+	assert.ExpectEQ(t, -10, v)
+	v, _ = d.PopBack()
+	assert.ExpectEQ(t, 9, v)
+	assert.ExpectEQ(t, 18, d.Len())
+
+	items := d.Items()
+	assert.ExpectEQ(t, -9, items[0])
+	assert.ExpectEQ(t, 8, items[17])
+
+	d.Clear()
+	assert.ExpectEQ(t, 0, d.Len())
+	d.PushFront(1)
+	assert.ExpectEQ(t, []int{1}, d.Items())
+}
+
+func TestDeque_JSON(t *testing.T) {
+	d := NewDeque(2, 3)
+	d.PushFront(1)
+	b, err := json.Marshal(d)
+	assert.ExpectNoErr(t, err)
+	assert.ExpectEQ(t, `[1,2,3]`, string(b))
+
+	d2 := NewDeque[string]()
+	assert.ExpectNoErr(t, json.Unmarshal([]byte(`["a","b"]`), d2))
+	assert.ExpectEQ(t, []string{"a", "b"}, d2.Items())
+}
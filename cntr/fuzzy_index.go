@@ -0,0 +1,308 @@
+package cntr
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// EditCosts are the costs of the edit operations, for WeightedEditDistance
+// Transpose is the cost of swapping two adjacent characters, 0 to disable
+// it, so a swap costs two substitutions.
+type EditCosts struct {
+	Insert     float64
+	Delete     float64
+	Substitute float64
+	Transpose  float64
+}
+
+// DefaultEditCosts is the classic Levenshtein distance
+var DefaultEditCosts = EditCosts{Insert: 1, Delete: 1, Substitute: 1}
+
+// normalized returns the costs with the invalid ones replaced
+func (c EditCosts) normalized() EditCosts {
+	if c.Insert <= 0 {
+		c.Insert = 1
+	}
+	if c.Delete <= 0 {
+		c.Delete = 1
+	}
+	if c.Substitute <= 0 {
+		c.Substitute = 1
+	}
+	if c.Transpose < 0 {
+		c.Transpose = 0
+	}
+	return c
+}
+
+// unitCost returns the least cost of one Levenshtein operation, so the
+// weighted distance is never less than unitCost * EditDistance
+func (c EditCosts) unitCost() float64 {
+	u := math.Min(c.Insert, math.Min(c.Delete, c.Substitute))
+	if c.Transpose > 0 {
+		// a swap is two substitutions in Levenshtein
+		u = math.Min(u, c.Transpose/2)
+	}
+	return u
+}
+
+func (c EditCosts) isDefault() bool {
+	return c == DefaultEditCosts
+}
+
+// NormalizeTerm normalizes a term for the fuzzy matching: the accents
+// are removed, the cases are folded, the compatible characters are
+// unified (e.g. `ﬁ` to `fi`), and the spaces are collapsed.
+func NormalizeTerm(s string) string {
+	t := transform.Chain(norm.NFKD, runes.Remove(runes.In(unicode.Mn)), cases.Fold(), norm.NFC)
+	if r, _, err := transform.String(t, s); err == nil {
+		s = r
+	}
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// EditDistance returns the Levenshtein distance between two strings,
+// in runes. Compare with GetEditDistanceT, it is not bounded.
+func EditDistance(s1, s2 string) int {
+	return levenshtein([]rune(s1), []rune(s2))
+}
+
+func levenshtein(a, b []rune) int {
+	if len(a) < len(b) {
+		a, b = b, a
+	}
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			d := prev[j-1]
+			if a[i-1] != b[j-1] {
+				d++
+			}
+			if prev[j]+1 < d {
+				d = prev[j] + 1
+			}
+			if cur[j-1]+1 < d {
+				d = cur[j-1] + 1
+			}
+			cur[j] = d
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+// WeightedEditDistance returns the cost to transform s1 into s2
+// The transpositions are the optimal string alignment ones, i.e. no
+// substring is edited more than once.
+func WeightedEditDistance(s1, s2 string, costs EditCosts) float64 {
+	return weightedEditDistance([]rune(s1), []rune(s2), costs.normalized())
+}
+
+func weightedEditDistance(a, b []rune, c EditCosts) float64 {
+	// 3 rows for the transpositions
+	rows := [3][]float64{}
+	for i := range rows {
+		rows[i] = make([]float64, len(b)+1)
+	}
+	for j := 1; j <= len(b); j++ {
+		rows[0][j] = rows[0][j-1] + c.Insert
+	}
+	for i := 1; i <= len(a); i++ {
+		cur, prev, prev2 := rows[i%3], rows[(i-1)%3], rows[(i+1)%3]
+		cur[0] = prev[0] + c.Delete
+		for j := 1; j <= len(b); j++ {
+			d := prev[j-1]
+			if a[i-1] != b[j-1] {
+				d += c.Substitute
+			}
+			d = math.Min(d, prev[j]+c.Delete)
+			d = math.Min(d, cur[j-1]+c.Insert)
+			if c.Transpose > 0 && i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] && a[i-1] != b[j-1] {
+				d = math.Min(d, prev2[j-2]+c.Transpose)
+			}
+			cur[j] = d
+		}
+	}
+	return rows[len(a)%3][len(b)]
+}
+
+// FuzzyMatch is a result of FuzzyIndex.Search
+type FuzzyMatch struct {
+	Term     string  // the term as added
+	Distance float64 // the (weighted) distance to the query
+}
+
+type bkNode struct {
+	key      []rune   // the normalized term
+	terms    []string // the terms with the same key
+	children map[int]*bkNode
+}
+
+/**
+ * FuzzyIndex is a BK-tree of terms, to find all the terms within a
+ * distance of a query, e.g. for "did you mean" suggestions
+ *
+ * The terms and the queries are normalized by NormalizeTerm by default.
+ * The tree is built on the Levenshtein distance, the weighted costs are
+ * applied to filter the candidates, so any positive costs are supported.
+ *
+ * It is safe for concurrent use, but the setters should be called before
+ * the terms are added.
+ */
+type FuzzyIndex struct {
+	mx        sync.RWMutex
+	root      *bkNode
+	size      int // number of distinct keys
+	normalize func(string) string
+	costs     EditCosts
+}
+
+func NewFuzzyIndex(terms ...string) *FuzzyIndex {
+	idx := &FuzzyIndex{
+		normalize: NormalizeTerm,
+		costs:     DefaultEditCosts,
+	}
+	idx.Add(terms...)
+	return idx
+}
+
+// SetNormalizer sets the normalizer of the terms, nil to use them as is
+func (idx *FuzzyIndex) SetNormalizer(f func(string) string) *FuzzyIndex {
+	idx.mx.Lock()
+	defer idx.mx.Unlock()
+	if f == nil {
+		f = func(s string) string { return s }
+	}
+	idx.normalize = f
+	return idx
+}
+
+// SetCosts sets the costs of the edit operations, the non-positive
+// insert, delete and substitute costs are treated as 1
+func (idx *FuzzyIndex) SetCosts(costs EditCosts) *FuzzyIndex {
+	idx.mx.Lock()
+	defer idx.mx.Unlock()
+	idx.costs = costs.normalized()
+	return idx
+}
+
+// Add adds the terms, the duplicated ones are ignored
+func (idx *FuzzyIndex) Add(terms ...string) {
+	idx.mx.Lock()
+	defer idx.mx.Unlock()
+	for _, term := range terms {
+		idx.unsafeAdd(term)
+	}
+}
+
+func (idx *FuzzyIndex) unsafeAdd(term string) {
+	key := []rune(idx.normalize(term))
+	if idx.root == nil {
+		idx.root = &bkNode{key: key, terms: []string{term}}
+		idx.size++
+		return
+	}
+	node := idx.root
+	for {
+		d := levenshtein(node.key, key)
+		if d == 0 {
+			for _, t := range node.terms {
+				if t == term {
+					return
+				}
+			}
+			node.terms = append(node.terms, term)
+			return
+		}
+		child, ok := node.children[d]
+		if !ok {
+			if node.children == nil {
+				node.children = map[int]*bkNode{}
+			}
+			node.children[d] = &bkNode{key: key, terms: []string{term}}
+			idx.size++
+			return
+		}
+		node = child
+	}
+}
+
+// Len returns the number of distinct normalized terms
+func (idx *FuzzyIndex) Len() int {
+	idx.mx.RLock()
+	defer idx.mx.RUnlock()
+	return idx.size
+}
+
+// Search returns all the terms within maxDistance of the query, ordered
+// by the distance, then the term
+func (idx *FuzzyIndex) Search(query string, maxDistance float64) []FuzzyMatch {
+	idx.mx.RLock()
+	defer idx.mx.RUnlock()
+	if idx.root == nil || maxDistance < 0 {
+		return nil
+	}
+	q := []rune(idx.normalize(query))
+	weighted := !idx.costs.isDefault()
+	// the radius in Levenshtein distance
+	radius := int(math.Floor(maxDistance/idx.costs.unitCost() + 1e-9))
+
+	var matches []FuzzyMatch
+	stack := []*bkNode{idx.root}
+	for len(stack) > 0 {
+		node := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		d := levenshtein(node.key, q)
+		if d <= radius {
+			dist := float64(d)
+			if weighted {
+				dist = weightedEditDistance(q, node.key, idx.costs)
+			}
+			if dist <= maxDistance+1e-9 {
+				for _, t := range node.terms {
+					matches = append(matches, FuzzyMatch{Term: t, Distance: dist})
+				}
+			}
+		}
+		// the triangle inequality
+		for cd, child := range node.children {
+			if cd >= d-radius && cd <= d+radius {
+				stack = append(stack, child)
+			}
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Distance != matches[j].Distance {
+			return matches[i].Distance < matches[j].Distance
+		}
+		return matches[i].Term < matches[j].Term
+	})
+	return matches
+}
+
+// Closest returns the closest term within maxDistance of the query
+func (idx *FuzzyIndex) Closest(query string, maxDistance float64) (FuzzyMatch, bool) {
+	matches := idx.Search(query, maxDistance)
+	if len(matches) == 0 {
+		return FuzzyMatch{}, false
+	}
+	return matches[0], true
+}
+
+// Contains returns true if a term with the same normalized form is added
+func (idx *FuzzyIndex) Contains(term string) bool {
+	return len(idx.Search(term, 0)) > 0
+}
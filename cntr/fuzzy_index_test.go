@@ -0,0 +1,108 @@
+package cntr
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/argcv/stork/assert"
+)
+
+func TestEditDistance(t *testing.T) {
+	assert.ExpectEQ(t, 0, EditDistance("", ""))
+	assert.ExpectEQ(t, 3, EditDistance("", "abc"))
+	assert.ExpectEQ(t, 3, EditDistance("kitten", "sitting"))
+	assert.ExpectEQ(t, 2, EditDistance("ab", "ba"))
+	assert.ExpectEQ(t, 1, EditDistance("日本語", "日本"))
+
+	assert.ExpectEQ(t, 3.0, WeightedEditDistance("kitten", "sitting", DefaultEditCosts))
+	// a swap
+	costs := EditCosts{Insert: 1, Delete: 1, Substitute: 1, Transpose: 1}
+	assert.ExpectEQ(t, 1.0, WeightedEditDistance("ab", "ba", costs))
+	// optimal string alignment, no substring is edited twice
+	assert.ExpectEQ(t, 3.0, WeightedEditDistance("ca", "abc", costs))
+	// expensive substitutions are replaced by delete + insert
+	costs = EditCosts{Insert: 1, Delete: 1, Substitute: 5}
+	assert.ExpectEQ(t, 2.0, WeightedEditDistance("a", "b", costs))
+	costs = EditCosts{Insert: 2, Delete: 0.5, Substitute: 1}
+	assert.ExpectEQ(t, 1.0, WeightedEditDistance("abc", "a", costs))
+	assert.ExpectEQ(t, 4.0, WeightedEditDistance("a", "abc", costs))
+}
+
+func TestNormalizeTerm(t *testing.T) {
+	assert.ExpectEQ(t, "creme brulee", NormalizeTerm("  Crème   Brûlée "))
+	assert.ExpectEQ(t, "strasse", NormalizeTerm("STRASSE"))
+	assert.ExpectEQ(t, "file", NormalizeTerm("ﬁle"))
+}
+
+func TestFuzzyIndex(t *testing.T) {
+	idx := NewFuzzyIndex("book", "books", "cake", "boo", "boon", "cook", "cape", "cart", "Book")
+	assert.ExpectEQ(t, 8, idx.Len())
+
+	matches := idx.Search("bo0k", 1)
+	var terms []string
+	for _, m := range matches {
+		terms = append(terms, m.Term)
+	}
+	assert.ExpectEQ(t, []string{"Book", "book"}, terms)
+
+	m, ok := idx.Closest("BOOKES", 2)
+	assert.ExpectTrue(t, ok)
+	assert.ExpectEQ(t, "books", m.Term)
+	assert.ExpectEQ(t, 1.0, m.Distance)
+	_, ok = idx.Closest("zzzzzz", 2)
+	assert.ExpectFalse(t, ok)
+
+	assert.ExpectTrue(t, idx.Contains("CAKE"))
+	assert.ExpectFalse(t, idx.Contains("cakes"))
+
+	// case sensitive without the normalizer
+	raw := NewFuzzyIndex().SetNormalizer(nil)
+	raw.Add("Book", "book")
+	assert.ExpectEQ(t, 2, raw.Len())
+	assert.ExpectEQ(t, 1, len(raw.Search("book", 0)))
+}
+
+func TestFuzzyIndex_Weighted(t *testing.T) {
+	idx := NewFuzzyIndex().SetCosts(EditCosts{Insert: 1, Delete: 1, Substitute: 2, Transpose: 0.5})
+	idx.Add("form", "from", "farm", "forms")
+	matches := idx.Search("from", 1)
+	assert.ExpectEQ(t, []FuzzyMatch{{"from", 0}, {"form", 0.5}}, matches)
+
+	matches = idx.Search("from", 2)
+	assert.ExpectEQ(t, 4, len(matches))
+	assert.ExpectEQ(t, "forms", matches[2].Term)
+	assert.ExpectEQ(t, "farm", matches[3].Term)
+}
+
+func TestFuzzyIndex_BruteForce(t *testing.T) {
+	r := rand.New(rand.NewSource(42))
+	word := func() string {
+		b := make([]byte, 3+r.Intn(5))
+		for i := range b {
+			b[i] = "abcde"[r.Intn(5)]
+		}
+		return string(b)
+	}
+	var dict []string
+	for i := 0; i < 500; i++ {
+		dict = append(dict, word())
+	}
+	idx := NewFuzzyIndex(dict...)
+	for i := 0; i < 50; i++ {
+		q := word()
+		for k := 0; k <= 3; k++ {
+			want := NewSet[string]()
+			for _, w := range dict {
+				if EditDistance(q, w) <= k {
+					want.Add(w)
+				}
+			}
+			got := NewSet[string]()
+			for _, m := range idx.Search(q, float64(k)) {
+				got.Add(m.Term)
+			}
+			assert.ExpectTrue(t, want.Equal(got), fmt.Sprintf("%s within %d", q, k))
+		}
+	}
+}
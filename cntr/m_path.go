@@ -0,0 +1,552 @@
+package cntr
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrInvalidPath  = errors.New("invalid_path")
+	ErrPathMismatch = errors.New("path_type_mismatch")
+	ErrLossyConvert = errors.New("lossy_conversion")
+)
+
+type pathToken struct {
+	key   string
+	index bool // written as [i]
+}
+
+/**
+ * parsePath parses a path in one of the forms:
+ *
+ * - dotted: `a.b[2].c`, the `.`, `[` and `\` in keys are escaped by `\`
+ * - json pointer (RFC 6901): `/a/b/2/c`, `~1` for `/` and `~0` for `~`
+ *
+ * An empty path refers to the whole map.
+ */
+func parsePath(path string) ([]pathToken, error) {
+	if strings.HasPrefix(path, "/") {
+		return parsePointerPath(path)
+	}
+	return parseDottedPath(path)
+}
+
+func parsePointerPath(path string) ([]pathToken, error) {
+	segs := strings.Split(path[1:], "/")
+	toks := make([]pathToken, 0, len(segs))
+	for _, seg := range segs {
+		if strings.Contains(strings.ReplaceAll(strings.ReplaceAll(seg, "~0", ""), "~1", ""), "~") {
+			return nil, fmt.Errorf("%w: bad escape in %q", ErrInvalidPath, path)
+		}
+		seg = strings.ReplaceAll(strings.ReplaceAll(seg, "~1", "/"), "~0", "~")
+		toks = append(toks, pathToken{key: seg})
+	}
+	return toks, nil
+}
+
+func parseDottedPath(path string) ([]pathToken, error) {
+	var toks []pathToken
+	buf := strings.Builder{}
+	hasKey := false     // a key is in the buffer
+	needKey := false    // a key is required after a dot
+	afterIndex := false // just after a `]`
+	bad := func(reason string) error {
+		return fmt.Errorf("%w: %s in %q", ErrInvalidPath, reason, path)
+	}
+	flush := func() {
+		toks = append(toks, pathToken{key: buf.String()})
+		buf.Reset()
+		hasKey = false
+	}
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		if afterIndex && c != '.' && c != '[' {
+			return nil, bad("unexpected character after index")
+		}
+		switch c {
+		case '\\':
+			if i+1 >= len(path) {
+				return nil, bad("dangling escape")
+			}
+			i++
+			buf.WriteByte(path[i])
+			hasKey, needKey = true, false
+		case '.':
+			if hasKey {
+				flush()
+			} else if !afterIndex {
+				return nil, bad("empty key")
+			}
+			needKey, afterIndex = true, false
+		case '[':
+			if hasKey {
+				flush()
+			} else if needKey {
+				return nil, bad("empty key")
+			}
+			end := strings.IndexByte(path[i:], ']')
+			if end < 0 {
+				return nil, bad("unclosed bracket")
+			}
+			idx := path[i+1 : i+end]
+			if _, err := strconv.Atoi(idx); err != nil && idx != "-" {
+				return nil, bad("non-numeric index")
+			}
+			toks = append(toks, pathToken{key: idx, index: true})
+			i += end
+			needKey, afterIndex = false, true
+		default:
+			buf.WriteByte(c)
+			hasKey, needKey = true, false
+		}
+	}
+	if hasKey {
+		flush()
+	} else if needKey {
+		return nil, bad("empty key")
+	}
+	return toks, nil
+}
+
+func asMap(v interface{}) (map[string]interface{}, bool) {
+	switch m := v.(type) {
+	case map[string]interface{}:
+		return m, m != nil
+	case M:
+		return m, m != nil
+	}
+	return nil, false
+}
+
+// sliceIndex parses the index of a slice of length n, `-` refers to n
+func sliceIndex(key string, n int) (int, bool) {
+	if key == "-" {
+		return n, true
+	}
+	i, err := strconv.Atoi(key)
+	if err != nil || i < 0 {
+		return 0, false
+	}
+	return i, true
+}
+
+func lookupPath(cur interface{}, toks []pathToken) (interface{}, bool, error) {
+	for _, tok := range toks {
+		if m, ok := asMap(cur); ok {
+			if tok.index {
+				return nil, false, fmt.Errorf("%w: index [%s] on a map", ErrPathMismatch, tok.key)
+			}
+			if cur, ok = m[tok.key]; !ok {
+				return nil, false, nil
+			}
+			continue
+		}
+		rv := reflect.ValueOf(cur)
+		if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+			return nil, false, fmt.Errorf("%w: %q on a %T", ErrPathMismatch, tok.key, cur)
+		}
+		i, ok := sliceIndex(tok.key, rv.Len())
+		if !ok {
+			return nil, false, fmt.Errorf("%w: key %q on a list", ErrPathMismatch, tok.key)
+		}
+		if i >= rv.Len() {
+			return nil, false, nil
+		}
+		cur = rv.Index(i).Interface()
+	}
+	return cur, true, nil
+}
+
+// setPath sets the value, and returns the new container of the level,
+// which differs from cur if a map is created or a slice is appended
+func setPath(cur interface{}, toks []pathToken, value interface{}) (interface{}, error) {
+	if len(toks) == 0 {
+		return value, nil
+	}
+	tok := toks[0]
+	if cur == nil {
+		if tok.index {
+			return nil, fmt.Errorf("%w: could not create a list for [%s]", ErrPathMismatch, tok.key)
+		}
+		cur = map[string]interface{}{}
+	}
+	if m, ok := asMap(cur); ok {
+		if tok.index {
+			return nil, fmt.Errorf("%w: index [%s] on a map", ErrPathMismatch, tok.key)
+		}
+		child, err := setPath(m[tok.key], toks[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		m[tok.key] = child
+		return cur, nil
+	}
+	l, ok := cur.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%w: could not set %q in a %T", ErrPathMismatch, tok.key, cur)
+	}
+	i, ok := sliceIndex(tok.key, len(l))
+	if !ok || i > len(l) {
+		return nil, fmt.Errorf("%w: index %q out of range [0, %d]", ErrPathMismatch, tok.key, len(l))
+	}
+	var child interface{}
+	if i < len(l) {
+		child = l[i]
+	}
+	child, err := setPath(child, toks[1:], value)
+	if err != nil {
+		return nil, err
+	}
+	if i == len(l) {
+		return append(l, child), nil
+	}
+	l[i] = child
+	return l, nil
+}
+
+// deletePath removes the value, and returns the new container of the level
+func deletePath(cur interface{}, toks []pathToken) (interface{}, bool, error) {
+	tok := toks[0]
+	if m, ok := asMap(cur); ok {
+		if tok.index {
+			return nil, false, fmt.Errorf("%w: index [%s] on a map", ErrPathMismatch, tok.key)
+		}
+		child, found := m[tok.key]
+		if !found {
+			return cur, false, nil
+		}
+		if len(toks) == 1 {
+			delete(m, tok.key)
+			return cur, true, nil
+		}
+		child, deleted, err := deletePath(child, toks[1:])
+		if err == nil && deleted {
+			m[tok.key] = child
+		}
+		return cur, deleted, err
+	}
+	l, ok := cur.([]interface{})
+	if !ok {
+		if cur == nil {
+			return cur, false, nil
+		}
+		return nil, false, fmt.Errorf("%w: could not delete %q in a %T", ErrPathMismatch, tok.key, cur)
+	}
+	i, ok := sliceIndex(tok.key, len(l))
+	if !ok {
+		return nil, false, fmt.Errorf("%w: key %q on a list", ErrPathMismatch, tok.key)
+	}
+	if i >= len(l) {
+		return cur, false, nil
+	}
+	if len(toks) == 1 {
+		return append(l[:i:i], l[i+1:]...), true, nil
+	}
+	child, deleted, err := deletePath(l[i], toks[1:])
+	if err == nil && deleted {
+		l[i] = child
+	}
+	return l, deleted, err
+}
+
+// Lookup returns the value of a path, ok is false if it is not found
+// The path is dotted (`a.b[2].c`) or a json pointer (`/a/b/2/c`),
+// an error is returned if the path is invalid, or goes through a value
+// which is not a map or a list.
+//
+//goland:noinspection GoMixedReceiverTypes
+func (a M) Lookup(path string) (interface{}, bool, error) {
+	toks, err := parsePath(path)
+	if err != nil {
+		return nil, false, err
+	}
+	return lookupPath(a, toks)
+}
+
+// Get returns the value of a path, see Lookup
+//
+//goland:noinspection GoMixedReceiverTypes
+func (a M) Get(path string) (interface{}, bool) {
+	v, ok, err := a.Lookup(path)
+	return v, ok && err == nil
+}
+
+// Set sets the value of a path, the missing maps are created
+// The lists are never created, an index equal to the length (or `-`)
+// appends to a list. The existing values are not overwritten by the
+// intermediate maps, an error is returned instead.
+//
+//goland:noinspection GoMixedReceiverTypes
+func (a M) Set(path string, value interface{}) error {
+	toks, err := parsePath(path)
+	if err != nil {
+		return err
+	}
+	if len(toks) == 0 {
+		return fmt.Errorf("%w: could not set the root", ErrInvalidPath)
+	}
+	_, err = setPath(a, toks, value)
+	return err
+}
+
+// Delete removes the value of a path, returns true if it existed
+// The items after a removed item in a list are shifted.
+//
+//goland:noinspection GoMixedReceiverTypes
+func (a M) Delete(path string) (bool, error) {
+	toks, err := parsePath(path)
+	if err != nil {
+		return false, err
+	}
+	if len(toks) == 0 {
+		return false, fmt.Errorf("%w: could not delete the root", ErrInvalidPath)
+	}
+	_, deleted, err := deletePath(a, toks)
+	return deleted, err
+}
+
+func (a M) lookupTyped(path string, f func(v interface{}) error) (bool, error) {
+	v, ok, err := a.Lookup(path)
+	if err != nil || !ok {
+		return false, err
+	}
+	if err = f(v); err != nil {
+		return true, fmt.Errorf("%s: %w", path, err)
+	}
+	return true, nil
+}
+
+// LookupString returns the string of a path
+//
+//goland:noinspection GoMixedReceiverTypes
+func (a M) LookupString(path string) (s string, ok bool, err error) {
+	ok, err = a.lookupTyped(path, func(v interface{}) error {
+		var isStr bool
+		if s, isStr = v.(string); !isStr {
+			return fmt.Errorf("%w: string expected, got %T", ErrPathMismatch, v)
+		}
+		return nil
+	})
+	return
+}
+
+// LookupInt returns the int of a path, the numbers are converted if
+// no precision is lost
+//
+//goland:noinspection GoMixedReceiverTypes
+func (a M) LookupInt(path string) (n int, ok bool, err error) {
+	ok, err = a.lookupTyped(path, func(v interface{}) (e error) {
+		n, e = toInt(v)
+		return
+	})
+	return
+}
+
+// LookupInt64 returns the int64 of a path, the numbers are converted if
+// no precision is lost
+//
+//goland:noinspection GoMixedReceiverTypes
+func (a M) LookupInt64(path string) (n int64, ok bool, err error) {
+	ok, err = a.lookupTyped(path, func(v interface{}) (e error) {
+		n, e = toInt64(v)
+		return
+	})
+	return
+}
+
+// LookupFloat64 returns the float64 of a path, the numbers are converted
+// if no precision is lost
+//
+//goland:noinspection GoMixedReceiverTypes
+func (a M) LookupFloat64(path string) (f float64, ok bool, err error) {
+	ok, err = a.lookupTyped(path, func(v interface{}) (e error) {
+		f, e = toFloat64(v)
+		return
+	})
+	return
+}
+
+// LookupBool returns the bool of a path
+//
+//goland:noinspection GoMixedReceiverTypes
+func (a M) LookupBool(path string) (b bool, ok bool, err error) {
+	ok, err = a.lookupTyped(path, func(v interface{}) error {
+		var isBool bool
+		if b, isBool = v.(bool); !isBool {
+			return fmt.Errorf("%w: bool expected, got %T", ErrPathMismatch, v)
+		}
+		return nil
+	})
+	return
+}
+
+// LookupM returns the map of a path
+//
+//goland:noinspection GoMixedReceiverTypes
+func (a M) LookupM(path string) (m M, ok bool, err error) {
+	ok, err = a.lookupTyped(path, func(v interface{}) error {
+		if mm, isMap := asMap(v); isMap {
+			m = mm
+			return nil
+		}
+		return fmt.Errorf("%w: map expected, got %T", ErrPathMismatch, v)
+	})
+	return
+}
+
+// LookupSlice returns the list of a path
+//
+//goland:noinspection GoMixedReceiverTypes
+func (a M) LookupSlice(path string) (l []interface{}, ok bool, err error) {
+	ok, err = a.lookupTyped(path, func(v interface{}) error {
+		var isList bool
+		if l, isList = v.([]interface{}); !isList {
+			return fmt.Errorf("%w: list expected, got %T", ErrPathMismatch, v)
+		}
+		return nil
+	})
+	return
+}
+
+// DeepMerge merges other into a, the maps are merged recursively, and the
+// other values (including the lists) in other replace the ones in a.
+// The values from other are copied, so the two maps share nothing.
+//
+//goland:noinspection GoMixedReceiverTypes
+func (a M) DeepMerge(other M) M {
+	for k, ov := range other {
+		if om, ok := asMap(ov); ok {
+			if am, ok := asMap(a[k]); ok {
+				M(am).DeepMerge(om)
+				continue
+			}
+		}
+		a[k] = deepCopyValue(ov)
+	}
+	return a
+}
+
+// DeepCopy returns a copy of a, the nested maps and lists are copied too
+//
+//goland:noinspection GoMixedReceiverTypes
+func (a M) DeepCopy() M {
+	if a == nil {
+		return nil
+	}
+	return deepCopyValue(a).(M)
+}
+
+func deepCopyValue(v interface{}) interface{} {
+	switch t := v.(type) {
+	case M:
+		c := make(M, len(t))
+		for k, e := range t {
+			c[k] = deepCopyValue(e)
+		}
+		return c
+	case map[string]interface{}:
+		c := make(map[string]interface{}, len(t))
+		for k, e := range t {
+			c[k] = deepCopyValue(e)
+		}
+		return c
+	case []interface{}:
+		c := make([]interface{}, len(t))
+		for i, e := range t {
+			c[i] = deepCopyValue(e)
+		}
+		return c
+	}
+	return v
+}
+
+func toInt(v interface{}) (int, error) {
+	n, err := toInt64(v)
+	if err != nil {
+		return 0, err
+	}
+	if n > math.MaxInt || n < math.MinInt {
+		return 0, fmt.Errorf("%w: %v overflows int", ErrLossyConvert, v)
+	}
+	return int(n), nil
+}
+
+func toInt64(v interface{}) (int64, error) {
+	switch n := v.(type) {
+	case int:
+		return int64(n), nil
+	case int8:
+		return int64(n), nil
+	case int16:
+		return int64(n), nil
+	case int32:
+		return int64(n), nil
+	case int64:
+		return n, nil
+	case uint, uint8, uint16, uint32, uint64, uintptr:
+		u := reflect.ValueOf(n).Uint()
+		if u > math.MaxInt64 {
+			return 0, fmt.Errorf("%w: %v overflows int64", ErrLossyConvert, v)
+		}
+		return int64(u), nil
+	case float32:
+		return floatToInt64(float64(n))
+	case float64:
+		return floatToInt64(n)
+	case json.Number:
+		if i, err := n.Int64(); err == nil {
+			return i, nil
+		}
+		f, err := n.Float64()
+		if err != nil {
+			return 0, fmt.Errorf("%w: %v", ErrLossyConvert, err)
+		}
+		return floatToInt64(f)
+	}
+	return 0, fmt.Errorf("%w: number expected, got %T", ErrPathMismatch, v)
+}
+
+func floatToInt64(f float64) (int64, error) {
+	// float64(math.MaxInt64) is 2^63, which overflows
+	if f != math.Trunc(f) || f < math.MinInt64 || f >= math.MaxInt64 {
+		return 0, fmt.Errorf("%w: %v is not an int64", ErrLossyConvert, f)
+	}
+	return int64(f), nil
+}
+
+// maxExactFloat is 2^53, the integers in [-2^53, 2^53] are exact in float64
+const maxExactFloat = 1 << 53
+
+func toFloat64(v interface{}) (float64, error) {
+	switch n := v.(type) {
+	case float64:
+		return n, nil
+	case float32:
+		return float64(n), nil
+	case json.Number:
+		f, err := n.Float64()
+		if err != nil {
+			return 0, fmt.Errorf("%w: %v", ErrLossyConvert, err)
+		}
+		return f, nil
+	case uint, uint8, uint16, uint32, uint64, uintptr:
+		u := reflect.ValueOf(n).Uint()
+		if u > maxExactFloat {
+			return 0, fmt.Errorf("%w: %v is not exact in float64", ErrLossyConvert, v)
+		}
+		return float64(u), nil
+	}
+	i, err := toInt64(v)
+	if err != nil {
+		return 0, err
+	}
+	if i > maxExactFloat || i < -maxExactFloat {
+		return 0, fmt.Errorf("%w: %v is not exact in float64", ErrLossyConvert, v)
+	}
+	return float64(i), nil
+}
@@ -0,0 +1,206 @@
+package cntr
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/argcv/stork/assert"
+)
+
+func newTestM(t *testing.T) M {
+	m := M{}
+	err := json.Unmarshal([]byte(`{
+	"a": {
+		"b": [
+			{"c": 1},
+			{"c": 2},
+			{"c": 3, "d": [10, 20]}
+		],
+		"x.y": "dotted",
+		"s/t": "slashed"
+	},
+	"f": 1.5,
+	"i": 42,
+	"big": 1e20,
+	"ok": true,
+	"name": "stork"
+}`), &m)
+	assert.ExpectNoErr(t, err)
+	return m
+}
+
+func TestM_Lookup(t *testing.T) {
+	m := newTestM(t)
+
+	v, ok := m.Get("a.b[2].c")
+	assert.ExpectTrue(t, ok)
+	assert.ExpectEQ(t, 3.0, v)
+	v, ok = m.Get("/a/b/2/d/1")
+	assert.ExpectTrue(t, ok)
+	assert.ExpectEQ(t, 20.0, v)
+	v, ok = m.Get(`a.x\.y`)
+	assert.ExpectTrue(t, ok)
+	assert.ExpectEQ(t, "dotted", v)
+	v, ok = m.Get("/a/s~1t")
+	assert.ExpectTrue(t, ok)
+	assert.ExpectEQ(t, "slashed", v)
+	v, ok = m.Get("")
+	assert.ExpectTrue(t, ok)
+
+	_, ok = m.Get("a.b[5].c")
+	assert.ExpectFalse(t, ok)
+	_, ok = m.Get("a.nope.c")
+	assert.ExpectFalse(t, ok)
+
+	// through a non-container
+	_, ok, err := m.Lookup("name.first")
+	assert.ExpectFalse(t, ok)
+	assert.ExpectTrue(t, errors.Is(err, ErrPathMismatch))
+	_, _, err = m.Lookup("a[0]")
+	assert.ExpectTrue(t, errors.Is(err, ErrPathMismatch))
+
+	for _, p := range []string{"a..b", "a.", ".a", "a[x]", "a[1", "a[0]b", `a\`, "a.[0]", "/a/~2"} {
+		_, _, err = m.Lookup(p)
+		assert.ExpectTrue(t, errors.Is(err, ErrInvalidPath), p)
+	}
+}
+
+func TestM_LookupTyped(t *testing.T) {
+	m := newTestM(t)
+
+	n, ok, err := m.LookupInt("a.b[1].c")
+	assert.ExpectNoErr(t, err)
+	assert.ExpectTrue(t, ok)
+	assert.ExpectEQ(t, 2, n)
+
+	n64, ok, err := m.LookupInt64("i")
+	assert.ExpectNoErr(t, err)
+	assert.ExpectEQ(t, int64(42), n64)
+
+	// lossy
+	_, ok, err = m.LookupInt("f")
+	assert.ExpectTrue(t, ok)
+	assert.ExpectTrue(t, errors.Is(err, ErrLossyConvert))
+	_, _, err = m.LookupInt64("big")
+	assert.ExpectTrue(t, errors.Is(err, ErrLossyConvert))
+
+	f, _, err := m.LookupFloat64("f")
+	assert.ExpectNoErr(t, err)
+	assert.ExpectEQ(t, 1.5, f)
+
+	s, ok, err := m.LookupString("name")
+	assert.ExpectNoErr(t, err)
+	assert.ExpectTrue(t, ok)
+	assert.ExpectEQ(t, "stork", s)
+	_, _, err = m.LookupString("i")
+	assert.ExpectTrue(t, errors.Is(err, ErrPathMismatch))
+
+	b, _, err := m.LookupBool("ok")
+	assert.ExpectNoErr(t, err)
+	assert.ExpectTrue(t, b)
+
+	sub, ok, err := m.LookupM("a.b[0]")
+	assert.ExpectNoErr(t, err)
+	assert.ExpectTrue(t, ok)
+	assert.ExpectEQ(t, 1.0, sub.GetFloat64("c"))
+
+	l, _, err := m.LookupSlice("a.b")
+	assert.ExpectNoErr(t, err)
+	assert.ExpectEQ(t, 3, len(l))
+
+	// missing is not an error
+	_, ok, err = m.LookupInt("missing")
+	assert.ExpectFalse(t, ok)
+	assert.ExpectNoErr(t, err)
+
+	// the single level getters are still strict
+	assert.ExpectEQ(t, 0, m.GetInt("i"))
+	assert.ExpectEQ(t, int64(0), m.GetInt64("i"))
+	assert.ExpectEQ(t, 42.0, m.GetFloat64("i"))
+	assert.ExpectEQ(t, 0.0, M{"n": 42}.GetFloat64("n"))
+}
+
+func TestM_Set(t *testing.T) {
+	m := newTestM(t)
+
+	assert.ExpectNoErr(t, m.Set("p.q.r", 1))
+	assert.ExpectEQ(t, 1, M(m["p"].(map[string]interface{})).GetM("q").GetInt("r"))
+
+	assert.ExpectNoErr(t, m.Set("a.b[1].c", "two"))
+	v, _ := m.Get("a.b[1].c")
+	assert.ExpectEQ(t, "two", v)
+
+	// append
+	assert.ExpectNoErr(t, m.Set("a.b[3]", "x"))
+	assert.ExpectNoErr(t, m.Set("/a/b/-", "y"))
+	l, _, _ := m.LookupSlice("a.b")
+	assert.ExpectEQ(t, 5, len(l))
+	assert.ExpectEQ(t, "y", l[4])
+	assert.ExpectNoErr(t, m.Set("a.b[2].d[2]", 30))
+	v, _ = m.Get("a.b[2].d[2]")
+	assert.ExpectEQ(t, 30, v)
+
+	assert.ExpectErr(t, m.Set("a.b[9]", 1))
+	assert.ExpectErr(t, m.Set("name.first", 1))
+	assert.ExpectErr(t, m.Set("new[0]", 1))
+	assert.ExpectErr(t, m.Set("", 1))
+	assert.ExpectEQ(t, "stork", m.GetString("name"))
+}
+
+func TestM_Delete(t *testing.T) {
+	m := newTestM(t)
+
+	ok, err := m.Delete("a.b[0]")
+	assert.ExpectNoErr(t, err)
+	assert.ExpectTrue(t, ok)
+	n, _, _ := m.LookupInt("a.b[0].c")
+	assert.ExpectEQ(t, 2, n)
+
+	ok, err = m.Delete("/a/b/1/d/0")
+	assert.ExpectNoErr(t, err)
+	assert.ExpectTrue(t, ok)
+	n, _, _ = m.LookupInt("a.b[1].d[0]")
+	assert.ExpectEQ(t, 20, n)
+
+	ok, _ = m.Delete("name")
+	assert.ExpectTrue(t, ok)
+	_, ok = m.Get("name")
+	assert.ExpectFalse(t, ok)
+	ok, err = m.Delete("name")
+	assert.ExpectNoErr(t, err)
+	assert.ExpectFalse(t, ok)
+	ok, err = m.Delete("nope.deep")
+	assert.ExpectNoErr(t, err)
+	assert.ExpectFalse(t, ok)
+	_, err = m.Delete("i.deep")
+	assert.ExpectErr(t, err)
+}
+
+func TestM_DeepMerge(t *testing.T) {
+	a := M{
+		"a": map[string]interface{}{"x": 1, "y": 2},
+		"l": []interface{}{1, 2},
+		"s": "keep",
+	}
+	b := M{
+		"a": map[string]interface{}{"y": 3, "z": map[string]interface{}{"k": 1}},
+		"l": []interface{}{3},
+		"t": "new",
+	}
+	a.DeepMerge(b)
+	assert.ExpectEQ(t, 1, a.GetM("a").GetInt("x"))
+	assert.ExpectEQ(t, 3, a.GetM("a").GetInt("y"))
+	assert.ExpectEQ(t, []interface{}{3}, a["l"])
+	assert.ExpectEQ(t, "keep", a.GetString("s"))
+	assert.ExpectEQ(t, "new", a.GetString("t"))
+
+	// nothing shared
+	assert.ExpectNoErr(t, a.Set("a.z.k", 2))
+	n, _, _ := b.LookupInt("a.z.k")
+	assert.ExpectEQ(t, 1, n)
+
+	c := a.DeepCopy()
+	assert.ExpectNoErr(t, c.Set("a.x", 100))
+	assert.ExpectEQ(t, 1, a.GetM("a").GetInt("x"))
+}
@@ -0,0 +1,546 @@
+package cntr
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrUnknownField = errors.New("unknown_field")
+	ErrNotStruct    = errors.New("not_struct")
+)
+
+// FieldError is an error of a field in Decode, Path is the dotted path
+// of the field in the map
+type FieldError struct {
+	Path string
+	Err  error
+}
+
+func (e *FieldError) Error() string {
+	return e.Path + ": " + e.Err.Error()
+}
+
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+// structTags are the default tags to name a field, in priority
+var structTags = []string{"json", "bson", "mapstructure"}
+
+// BSONStructTags prefers the bson names, e.g. for the conversion from and
+// to bson.M, where `json:"id" bson:"_id"` should be named `_id`
+var BSONStructTags = []string{"bson", "json", "mapstructure"}
+
+type structField struct {
+	name      string
+	index     []int
+	omitEmpty bool
+}
+
+type structFieldsKey struct {
+	t    reflect.Type
+	tags string
+}
+
+var structFieldsCache sync.Map // structFieldsKey => []structField
+
+/**
+ * structFields returns the fields of a struct type, in the same way as
+ * encoding/json:
+ *
+ * - the name is taken from the first tag with a name in the tags (json,
+ *   bson and mapstructure by default), or the field name, `-` to skip the
+ *   field. The options of the tags without a name are kept, e.g.
+ *   `json:",omitempty" bson:"nm"` is named nm and omitted if empty
+ * - the fields of an embedded struct without a name are promoted, as well
+ *   as the fields tagged `inline` (bson) or `squash` (mapstructure)
+ * - for the duplicated names, the shallowest field wins
+ */
+func structFields(t reflect.Type, tags []string) []structField {
+	key := structFieldsKey{t, strings.Join(tags, ",")}
+	if fs, ok := structFieldsCache.Load(key); ok {
+		return fs.([]structField)
+	}
+	var fields []structField
+	collectStructFields(t, tags, nil, map[reflect.Type]bool{}, &fields)
+
+	// the shallowest field wins, stable for the same depth
+	sort.SliceStable(fields, func(i, j int) bool {
+		return len(fields[i].index) < len(fields[j].index)
+	})
+	seen := map[string]bool{}
+	result := fields[:0]
+	for _, f := range fields {
+		if !seen[f.name] {
+			seen[f.name] = true
+			result = append(result, f)
+		}
+	}
+	structFieldsCache.Store(key, result)
+	return result
+}
+
+func collectStructFields(t reflect.Type, tags []string, parent []int, visited map[reflect.Type]bool, fields *[]structField) {
+	if visited[t] {
+		return
+	}
+	visited[t] = true
+	defer delete(visited, t)
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		name, opts, skip := fieldTag(sf, tags)
+		if skip {
+			continue
+		}
+		index := make([]int, len(parent)+1)
+		copy(index, parent)
+		index[len(parent)] = i
+
+		ft := sf.Type
+		if ft.Kind() == reflect.Pointer {
+			ft = ft.Elem()
+		}
+		inline := opts["inline"] || opts["squash"] || (sf.Anonymous && name == "")
+		if inline && ft.Kind() == reflect.Struct {
+			if !sf.IsExported() && sf.Type.Kind() == reflect.Pointer {
+				// could not be allocated
+				continue
+			}
+			collectStructFields(ft, tags, index, visited, fields)
+			continue
+		}
+		if !sf.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = sf.Name
+		}
+		*fields = append(*fields, structField{
+			name:      name,
+			index:     index,
+			omitEmpty: opts["omitempty"],
+		})
+	}
+}
+
+func fieldTag(sf reflect.StructField, tags []string) (name string, opts map[string]bool, skip bool) {
+	opts = map[string]bool{}
+	for _, key := range tags {
+		tag, ok := sf.Tag.Lookup(key)
+		if !ok {
+			continue
+		}
+		if tag == "-" {
+			return "", nil, true
+		}
+		parts := strings.Split(tag, ",")
+		for _, o := range parts[1:] {
+			opts[o] = true
+		}
+		if parts[0] != "" {
+			return parts[0], opts, false
+		}
+	}
+	return "", opts, false
+}
+
+var (
+	timeType            = reflect.TypeOf(time.Time{})
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+type structDecoder struct {
+	tags   []string
+	strict bool
+	failed int // number of failures, reported or not
+	errs   []error
+}
+
+func (d *structDecoder) fail(path string, err error) {
+	d.failed++
+	if d.strict {
+		d.errs = append(d.errs, &FieldError{Path: path, Err: err})
+	}
+}
+
+// Decode decodes a into the struct pointed by out
+// The fields are matched by the tags (see FromStruct), and the case
+// insensitive names. The numbers are taken as strictly as GetInt and
+// GetFloat64: an integer field takes the integers of any size if the value
+// fits, a float field takes the floats only, e.g. a float64 from JSON is
+// mistyped for an int field. The unknown and mistyped fields are skipped.
+//
+//goland:noinspection GoMixedReceiverTypes
+func (a M) Decode(out interface{}) error {
+	return a.decode(out, structTags, false)
+}
+
+// DecodeWithTags is Decode with the tags in priority, e.g. BSONStructTags,
+// the default tags are used if it is empty
+//
+//goland:noinspection GoMixedReceiverTypes
+func (a M) DecodeWithTags(out interface{}, tags []string) error {
+	return a.decode(out, tags, false)
+}
+
+// DecodeStrict is similar to Decode, but all unknown and mistyped fields
+// are reported as *FieldError, joined in the returned error. The valid
+// fields are still decoded.
+//
+//goland:noinspection GoMixedReceiverTypes
+func (a M) DecodeStrict(out interface{}) error {
+	return a.decode(out, structTags, true)
+}
+
+// DecodeStrictWithTags is DecodeStrict with the tags in priority
+//
+//goland:noinspection GoMixedReceiverTypes
+func (a M) DecodeStrictWithTags(out interface{}, tags []string) error {
+	return a.decode(out, tags, true)
+}
+
+func (a M) decode(out interface{}, tags []string, strict bool) error {
+	if len(tags) == 0 {
+		tags = structTags
+	}
+	rv := reflect.ValueOf(out)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("%w: a pointer to struct expected, got %T", ErrNotStruct, out)
+	}
+	d := &structDecoder{tags: tags, strict: strict}
+	d.decodeStruct("", reflect.ValueOf(a), rv.Elem())
+	return errors.Join(d.errs...)
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// fieldByIndex returns the field, and allocates the embedded pointers
+func fieldByIndex(v reflect.Value, index []int) reflect.Value {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Pointer {
+			if v.IsNil() {
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v
+}
+
+func (d *structDecoder) decodeStruct(path string, src reflect.Value, dst reflect.Value) {
+	fields := structFields(dst.Type(), d.tags)
+	byName := make(map[string]*structField, len(fields))
+	byFold := make(map[string]*structField, len(fields))
+	for i := range fields {
+		f := &fields[i]
+		byName[f.name] = f
+		if _, ok := byFold[strings.ToLower(f.name)]; !ok {
+			byFold[strings.ToLower(f.name)] = f
+		}
+	}
+	keys := src.MapKeys()
+	// exact matches first, so they are never overwritten by the folded ones
+	sort.SliceStable(keys, func(i, j int) bool {
+		_, ei := byName[keys[i].String()]
+		_, ej := byName[keys[j].String()]
+		return ei && !ej
+	})
+	matched := map[*structField]bool{}
+	for _, k := range keys {
+		key := k.String()
+		f, ok := byName[key]
+		if !ok {
+			f, ok = byFold[strings.ToLower(key)]
+		}
+		if !ok || matched[f] {
+			d.fail(joinPath(path, key), ErrUnknownField)
+			continue
+		}
+		matched[f] = true
+		d.decodeValue(joinPath(path, key), src.MapIndex(k), fieldByIndex(dst, f.index))
+	}
+}
+
+// decodeValue decodes src into dst, a mistyped value leaves dst untouched
+func (d *structDecoder) decodeValue(path string, src reflect.Value, dst reflect.Value) {
+	for src.IsValid() && src.Kind() == reflect.Interface {
+		src = src.Elem()
+	}
+	if !src.IsValid() || ((src.Kind() == reflect.Pointer || src.Kind() == reflect.Map || src.Kind() == reflect.Slice) && src.IsNil()) {
+		dst.Set(reflect.Zero(dst.Type()))
+		return
+	}
+	if src.Type().AssignableTo(dst.Type()) && dst.Kind() != reflect.Map && dst.Kind() != reflect.Slice {
+		dst.Set(src)
+		return
+	}
+	if src.Kind() == reflect.Pointer {
+		d.decodeValue(path, src.Elem(), dst)
+		return
+	}
+	mismatch := func() {
+		d.fail(path, fmt.Errorf("%w: %v expected, got %v", ErrPathMismatch, dst.Type(), src.Type()))
+	}
+
+	// the text types, e.g. time.Time from a string
+	if src.Kind() == reflect.String && dst.Kind() != reflect.String && reflect.PointerTo(dst.Type()).Implements(textUnmarshalerType) {
+		v := reflect.New(dst.Type())
+		if err := v.Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(src.String())); err != nil {
+			d.fail(path, err)
+			return
+		}
+		dst.Set(v.Elem())
+		return
+	}
+
+	switch dst.Kind() {
+	case reflect.Interface:
+		if !src.Type().Implements(dst.Type()) {
+			mismatch()
+			return
+		}
+		dst.Set(src)
+	case reflect.Pointer:
+		v := reflect.New(dst.Type().Elem())
+		n := d.failed
+		d.decodeValue(path, src, v.Elem())
+		if d.failed == n {
+			dst.Set(v)
+		}
+	case reflect.Bool:
+		if src.Kind() != reflect.Bool {
+			mismatch()
+			return
+		}
+		dst.SetBool(src.Bool())
+	case reflect.String:
+		if src.Kind() != reflect.String {
+			mismatch()
+			return
+		}
+		dst.SetString(src.String())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var n int64
+		switch src.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			n = src.Int()
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			if src.Uint() > math.MaxInt64 {
+				d.fail(path, fmt.Errorf("%w: %v overflows %v", ErrLossyConvert, src.Uint(), dst.Type()))
+				return
+			}
+			n = int64(src.Uint())
+		default:
+			mismatch()
+			return
+		}
+		if dst.OverflowInt(n) {
+			d.fail(path, fmt.Errorf("%w: %v overflows %v", ErrLossyConvert, n, dst.Type()))
+			return
+		}
+		dst.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		var u uint64
+		switch src.Kind() {
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			u = src.Uint()
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			if src.Int() < 0 {
+				d.fail(path, fmt.Errorf("%w: %v is negative", ErrLossyConvert, src.Int()))
+				return
+			}
+			u = uint64(src.Int())
+		default:
+			mismatch()
+			return
+		}
+		if dst.OverflowUint(u) {
+			d.fail(path, fmt.Errorf("%w: %v overflows %v", ErrLossyConvert, u, dst.Type()))
+			return
+		}
+		dst.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		if src.Kind() != reflect.Float32 && src.Kind() != reflect.Float64 {
+			mismatch()
+			return
+		}
+		if dst.OverflowFloat(src.Float()) {
+			d.fail(path, fmt.Errorf("%w: %v overflows %v", ErrLossyConvert, src.Float(), dst.Type()))
+			return
+		}
+		dst.SetFloat(src.Float())
+	case reflect.Struct:
+		if src.Kind() != reflect.Map || src.Type().Key().Kind() != reflect.String {
+			mismatch()
+			return
+		}
+		d.decodeStruct(path, src, dst)
+	case reflect.Map:
+		if src.Kind() != reflect.Map || src.Type().Key().Kind() != reflect.String || dst.Type().Key().Kind() != reflect.String {
+			mismatch()
+			return
+		}
+		m := reflect.MakeMapWithSize(dst.Type(), src.Len())
+		n := d.failed
+		iter := src.MapRange()
+		for iter.Next() {
+			v := reflect.New(dst.Type().Elem()).Elem()
+			d.decodeValue(joinPath(path, iter.Key().String()), iter.Value(), v)
+			m.SetMapIndex(reflect.ValueOf(iter.Key().String()).Convert(dst.Type().Key()), v)
+		}
+		if d.failed == n {
+			dst.Set(m)
+		}
+	case reflect.Slice, reflect.Array:
+		if src.Kind() != reflect.Slice && src.Kind() != reflect.Array {
+			mismatch()
+			return
+		}
+		l := src.Len()
+		var s reflect.Value
+		if dst.Kind() == reflect.Slice {
+			s = reflect.MakeSlice(dst.Type(), l, l)
+		} else {
+			if l > dst.Len() {
+				d.fail(path, fmt.Errorf("%w: %d items for %v", ErrLossyConvert, l, dst.Type()))
+				return
+			}
+			s = reflect.New(dst.Type()).Elem()
+		}
+		n := d.failed
+		for i := 0; i < l; i++ {
+			d.decodeValue(fmt.Sprintf("%s[%d]", path, i), src.Index(i), s.Index(i))
+		}
+		if d.failed == n {
+			dst.Set(s)
+		}
+	default:
+		mismatch()
+	}
+}
+
+// FromStruct converts a struct (or a pointer to it) to M
+// The keys are named by the json, bson or mapstructure tags, `omitempty`
+// is respected. The nested structs and maps are converted to M, the
+// slices to []interface{}, time.Time and []byte are kept as they are.
+func FromStruct(s interface{}) (M, error) {
+	return FromStructWithTags(s, structTags)
+}
+
+// FromStructWithTags is FromStruct with the tags in priority, e.g.
+// BSONStructTags for bson.M
+func FromStructWithTags(s interface{}, tags []string) (M, error) {
+	if len(tags) == 0 {
+		tags = structTags
+	}
+	rv := reflect.ValueOf(s)
+	for rv.Kind() == reflect.Pointer && !rv.IsNil() {
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("%w: got %T", ErrNotStruct, s)
+	}
+	return encodeStruct(rv, tags), nil
+}
+
+func encodeStruct(rv reflect.Value, tags []string) M {
+	m := M{}
+	for _, f := range structFields(rv.Type(), tags) {
+		v, ok := fieldByIndexNoAlloc(rv, f.index)
+		if !ok {
+			continue
+		}
+		if f.omitEmpty && isEmptyValue(v) {
+			continue
+		}
+		m[f.name] = encodeValue(v, tags)
+	}
+	return m
+}
+
+// fieldByIndexNoAlloc returns false if an embedded pointer is nil
+func fieldByIndexNoAlloc(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Pointer {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
+
+func encodeValue(v reflect.Value, tags []string) interface{} {
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return encodeValue(v.Elem(), tags)
+	case reflect.Struct:
+		if v.Type() == timeType {
+			return v.Interface()
+		}
+		return encodeStruct(v, tags)
+	case reflect.Map:
+		if v.IsNil() {
+			return nil
+		}
+		if v.Type().Key().Kind() != reflect.String {
+			return v.Interface()
+		}
+		m := make(M, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			m[iter.Key().String()] = encodeValue(iter.Value(), tags)
+		}
+		return m
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return v.Interface()
+		}
+		l := make([]interface{}, v.Len())
+		for i := range l {
+			l[i] = encodeValue(v.Index(i), tags)
+		}
+		return l
+	}
+	return v.Interface()
+}
+
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64,
+		reflect.Interface, reflect.Pointer:
+		return v.IsZero()
+	case reflect.Struct:
+		if v.Type() == timeType {
+			return v.IsZero()
+		}
+	}
+	return false
+}
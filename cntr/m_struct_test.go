@@ -0,0 +1,213 @@
+package cntr
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/argcv/stork/assert"
+)
+
+type testBase struct {
+	ID      string    `bson:"_id"`
+	Created time.Time `json:"created"`
+}
+
+type testAddress struct {
+	City string `mapstructure:"city"`
+	Zip  *int   `json:"zip,omitempty"`
+}
+
+type testUser struct {
+	testBase
+	Name    string            `json:"name"`
+	Age     uint8             `json:"age"`
+	Score   float64           `json:"score,omitempty"`
+	Tags    []string          `json:"tags"`
+	Addr    testAddress       `json:"addr"`
+	Extra   map[string]int    `json:"extra,omitempty"`
+	Any     interface{}       `json:"any,omitempty"`
+	Labels  map[string]string `bson:"labels,omitempty"`
+	Skipped string            `json:"-"`
+	Nick    string
+	secret  string
+}
+
+func TestM_Decode(t *testing.T) {
+	m := M{}
+	assert.ExpectNoErr(t, json.Unmarshal([]byte(`{
+	"_id": "u1",
+	"created": "2020-01-02T03:04:05Z",
+	"name": "alice",
+	"score": 9.5,
+	"tags": ["a", "b"],
+	"any": [1, 2],
+	"NICK": "al",
+	"Skipped": "no",
+	"unknown": 1
+}`), &m))
+	// the integers, which are float64 from JSON
+	m["age"] = 30
+	m["addr"] = map[string]interface{}{"city": "paris", "zip": int64(75001)}
+	m["extra"] = M{"x": uint16(1)}
+
+	u := testUser{}
+	assert.ExpectNoErr(t, m.Decode(&u))
+	assert.ExpectEQ(t, "u1", u.ID)
+	assert.ExpectEQ(t, 2020, u.Created.Year())
+	assert.ExpectEQ(t, "alice", u.Name)
+	assert.ExpectEQ(t, uint8(30), u.Age)
+	assert.ExpectEQ(t, 9.5, u.Score)
+	assert.ExpectEQ(t, []string{"a", "b"}, u.Tags)
+	assert.ExpectEQ(t, "paris", u.Addr.City)
+	assert.ExpectEQ(t, 75001, *u.Addr.Zip)
+	assert.ExpectEQ(t, map[string]int{"x": 1}, u.Extra)
+	assert.ExpectEQ(t, []interface{}{1.0, 2.0}, u.Any)
+	assert.ExpectEQ(t, "al", u.Nick)
+	assert.ExpectEQ(t, "", u.Skipped)
+
+	err := m.DecodeStrict(&testUser{})
+	assert.ExpectTrue(t, errors.Is(err, ErrUnknownField))
+	var unknown []string
+	for _, e := range err.(interface{ Unwrap() []error }).Unwrap() {
+		unknown = append(unknown, e.(*FieldError).Path)
+	}
+	assert.ExpectTrue(t, NewSet(unknown...).Equal(NewSet("Skipped", "unknown")))
+
+	assert.ExpectErr(t, m.Decode(u))
+	assert.ExpectErr(t, m.Decode(&m))
+}
+
+func TestM_DecodeNumbers(t *testing.T) {
+	// as strict as GetInt and GetFloat64, no conversion across the kinds
+	for _, m := range []M{
+		{"age": 30.0},
+		{"age": json.Number("30")},
+		{"age": "30"},
+		{"score": 1},
+		{"score": "1.5"},
+		{"addr": M{"zip": 1.0}},
+	} {
+		u := testUser{}
+		err := m.DecodeStrict(&u)
+		assert.ExpectTrue(t, errors.Is(err, ErrPathMismatch), fmt.Sprint(m))
+		assert.ExpectEQ(t, testUser{}, u)
+	}
+
+	u := testUser{}
+	assert.ExpectNoErr(t, M{"age": int64(30), "score": float32(1.5), "addr": M{"zip": uint8(1)}}.DecodeStrict(&u))
+	assert.ExpectEQ(t, uint8(30), u.Age)
+	assert.ExpectEQ(t, 1.5, u.Score)
+	assert.ExpectEQ(t, 1, *u.Addr.Zip)
+	assert.ExpectTrue(t, errors.Is(M{"age": -1}.DecodeStrict(&u), ErrLossyConvert))
+	assert.ExpectTrue(t, errors.Is(M{"age": uint64(1) << 63}.DecodeStrict(&u), ErrLossyConvert))
+}
+
+func TestM_DecodeStrict(t *testing.T) {
+	m := M{
+		"name":  1,
+		"age":   300,
+		"score": int64(1) << 60,
+		"tags":  []interface{}{"a", 2},
+		"addr":  M{"city": "rome", "street": "x"},
+		"Nick":  "n",
+	}
+	u := testUser{}
+	// non-strict skips the bad fields
+	assert.ExpectNoErr(t, m.Decode(&u))
+	assert.ExpectEQ(t, "", u.Name)
+	assert.ExpectEQ(t, uint8(0), u.Age)
+	assert.ExpectEQ(t, 0, len(u.Tags))
+	assert.ExpectEQ(t, "rome", u.Addr.City)
+	assert.ExpectEQ(t, "n", u.Nick)
+
+	err := m.DecodeStrict(&u)
+	assert.ExpectErr(t, err)
+	paths := map[string]error{}
+	for _, e := range err.(interface{ Unwrap() []error }).Unwrap() {
+		fe := e.(*FieldError)
+		paths[fe.Path] = fe.Err
+	}
+	assert.ExpectEQ(t, 5, len(paths))
+	assert.ExpectTrue(t, errors.Is(paths["name"], ErrPathMismatch))
+	assert.ExpectTrue(t, errors.Is(paths["age"], ErrLossyConvert))
+	assert.ExpectTrue(t, errors.Is(paths["score"], ErrPathMismatch))
+	assert.ExpectTrue(t, errors.Is(paths["tags[1]"], ErrPathMismatch))
+	assert.ExpectTrue(t, errors.Is(paths["addr.street"], ErrUnknownField))
+}
+
+func TestFromStruct(t *testing.T) {
+	zip := 10
+	u := &testUser{
+		testBase: testBase{ID: "u1"},
+		Name:     "bob",
+		Age:      20,
+		Tags:     []string{"x"},
+		Addr:     testAddress{City: "oslo", Zip: &zip},
+		Labels:   map[string]string{"k": "v"},
+		Skipped:  "s",
+		secret:   "s",
+	}
+	m, err := FromStruct(u)
+	assert.ExpectNoErr(t, err)
+	assert.ExpectEQ(t, "u1", m.GetString("_id"))
+	assert.ExpectEQ(t, "bob", m.GetString("name"))
+	assert.ExpectEQ(t, uint8(20), m["age"])
+	assert.ExpectEQ(t, []interface{}{"x"}, m["tags"])
+	assert.ExpectEQ(t, "oslo", m.GetM("addr").GetString("city"))
+	assert.ExpectEQ(t, 10, m.GetM("addr").GetInt("zip"))
+	assert.ExpectEQ(t, "v", m.GetM("labels").GetString("k"))
+	_, ok := m["created"]
+	assert.ExpectTrue(t, ok)
+	for _, k := range []string{"score", "extra", "any", "Skipped", "secret"} {
+		_, ok = m[k]
+		assert.ExpectFalse(t, ok, k)
+	}
+
+	// round trip
+	u2 := testUser{}
+	assert.ExpectNoErr(t, m.DecodeStrict(&u2))
+	assert.ExpectEQ(t, u.ID, u2.ID)
+	assert.ExpectEQ(t, u.Addr.City, u2.Addr.City)
+	assert.ExpectEQ(t, zip, *u2.Addr.Zip)
+	assert.ExpectEQ(t, u.Labels, u2.Labels)
+
+	_, err = FromStruct(1)
+	assert.ExpectErr(t, err)
+}
+
+type testDoc struct {
+	ID    string `json:"id" bson:"_id"`
+	Name  string `json:",omitempty" bson:"nm"`
+	Note  string `json:"note,omitempty"`
+	Level int    `bson:"lv"`
+}
+
+func TestFromStruct_BothTags(t *testing.T) {
+	d := testDoc{ID: "d1", Level: 3}
+
+	m, err := FromStruct(d)
+	assert.ExpectNoErr(t, err)
+	assert.ExpectEQ(t, M{"id": "d1", "lv": 3}, m)
+
+	m, err = FromStructWithTags(d, BSONStructTags)
+	assert.ExpectNoErr(t, err)
+	assert.ExpectEQ(t, M{"_id": "d1", "nm": "", "lv": 3}, m)
+
+	// the options of a tag without a name are kept
+	d.Name = "doc"
+	m, err = FromStruct(d)
+	assert.ExpectNoErr(t, err)
+	assert.ExpectEQ(t, "doc", m.GetString("nm"))
+
+	d2 := testDoc{}
+	assert.ExpectNoErr(t, M{"_id": "d2", "nm": "x", "lv": 4}.DecodeStrictWithTags(&d2, BSONStructTags))
+	assert.ExpectEQ(t, testDoc{ID: "d2", Name: "x", Level: 4}, d2)
+
+	// the default tags for nil
+	d3 := testDoc{}
+	assert.ExpectNoErr(t, M{"id": "d3", "nm": "y"}.DecodeWithTags(&d3, nil))
+	assert.ExpectEQ(t, testDoc{ID: "d3", Name: "y"}, d3)
+}
@@ -0,0 +1,255 @@
+package cntr
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+)
+
+type orderedMapEntry[K comparable, V any] struct {
+	key   K
+	value V
+	prev  *orderedMapEntry[K, V]
+	next  *orderedMapEntry[K, V]
+}
+
+// OrderedMap is a map which keeps the insertion order of the keys
+// Updating an existing key keeps its position.
+// It is NOT thread safe.
+type OrderedMap[K comparable, V any] struct {
+	m    map[K]*orderedMapEntry[K, V]
+	head *orderedMapEntry[K, V]
+	tail *orderedMapEntry[K, V]
+}
+
+func NewOrderedMap[K comparable, V any]() *OrderedMap[K, V] {
+	return &OrderedMap[K, V]{
+		m: map[K]*orderedMapEntry[K, V]{},
+	}
+}
+
+func (om *OrderedMap[K, V]) lazyInit() {
+	if om.m == nil {
+		om.m = map[K]*orderedMapEntry[K, V]{}
+	}
+}
+
+// Set adds or updates a key, returns true if it is a new key
+func (om *OrderedMap[K, V]) Set(key K, value V) bool {
+	om.lazyInit()
+	if e, ok := om.m[key]; ok {
+		e.value = value
+		return false
+	}
+	e := &orderedMapEntry[K, V]{key: key, value: value, prev: om.tail}
+	if om.tail != nil {
+		om.tail.next = e
+	} else {
+		om.head = e
+	}
+	om.tail = e
+	om.m[key] = e
+	return true
+}
+
+func (om *OrderedMap[K, V]) Get(key K) (value V, ok bool) {
+	if e, found := om.m[key]; found {
+		return e.value, true
+	}
+	return
+}
+
+// GetOrDefault returns the value of the key, or `or` if not found
+func (om *OrderedMap[K, V]) GetOrDefault(key K, or V) V {
+	if v, ok := om.Get(key); ok {
+		return v
+	}
+	return or
+}
+
+func (om *OrderedMap[K, V]) Has(key K) bool {
+	_, ok := om.m[key]
+	return ok
+}
+
+// Delete removes a key, returns true if it existed
+func (om *OrderedMap[K, V]) Delete(key K) bool {
+	e, ok := om.m[key]
+	if !ok {
+		return false
+	}
+	if e.prev != nil {
+		e.prev.next = e.next
+	} else {
+		om.head = e.next
+	}
+	if e.next != nil {
+		e.next.prev = e.prev
+	} else {
+		om.tail = e.prev
+	}
+	delete(om.m, key)
+	return true
+}
+
+func (om *OrderedMap[K, V]) Len() int {
+	return len(om.m)
+}
+
+func (om *OrderedMap[K, V]) Clear() {
+	om.m = map[K]*orderedMapEntry[K, V]{}
+	om.head = nil
+	om.tail = nil
+}
+
+// Keys returns the keys in insertion order
+func (om *OrderedMap[K, V]) Keys() []K {
+	keys := make([]K, 0, om.Len())
+	for e := om.head; e != nil; e = e.next {
+		keys = append(keys, e.key)
+	}
+	return keys
+}
+
+// Values returns the values in insertion order
+func (om *OrderedMap[K, V]) Values() []V {
+	values := make([]V, 0, om.Len())
+	for e := om.head; e != nil; e = e.next {
+		values = append(values, e.value)
+	}
+	return values
+}
+
+// Range calls f for each pair in insertion order, until f returns false
+// The map must NOT be modified in f
+func (om *OrderedMap[K, V]) Range(f func(key K, value V) bool) {
+	for e := om.head; e != nil; e = e.next {
+		if !f(e.key, e.value) {
+			return
+		}
+	}
+}
+
+func (om *OrderedMap[K, V]) Clone() *OrderedMap[K, V] {
+	c := NewOrderedMap[K, V]()
+	om.Range(func(key K, value V) bool {
+		c.Set(key, value)
+		return true
+	})
+	return c
+}
+
+// MarshalJSON encodes the map as a json object, and keeps the order
+// The keys are encoded like encoding/json: strings, integers or
+// encoding.TextMarshaler
+func (om *OrderedMap[K, V]) MarshalJSON() ([]byte, error) {
+	buf := &bytes.Buffer{}
+	buf.WriteByte('{')
+	first := true
+	for e := om.head; e != nil; e = e.next {
+		ks, err := encodeMapKey(e.key)
+		if err != nil {
+			return nil, err
+		}
+		kb, err := json.Marshal(ks)
+		if err != nil {
+			return nil, err
+		}
+		vb, err := json.Marshal(e.value)
+		if err != nil {
+			return nil, err
+		}
+		if !first {
+			buf.WriteByte(',')
+		}
+		first = false
+		buf.Write(kb)
+		buf.WriteByte(':')
+		buf.Write(vb)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// UnmarshalJSON decodes a json object, and keeps the order in the input
+func (om *OrderedMap[K, V]) UnmarshalJSON(data []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	t, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if t == nil {
+		// null
+		om.Clear()
+		return nil
+	}
+	if d, ok := t.(json.Delim); !ok || d != '{' {
+		return errors.New("OrderedMap: json object expected")
+	}
+	om.Clear()
+	for dec.More() {
+		t, err = dec.Token()
+		if err != nil {
+			return err
+		}
+		var key K
+		if err = decodeMapKey(t.(string), &key); err != nil {
+			return err
+		}
+		var value V
+		if err = dec.Decode(&value); err != nil {
+			return err
+		}
+		om.Set(key, value)
+	}
+	_, err = dec.Token()
+	return err
+}
+
+func encodeMapKey(key interface{}) (string, error) {
+	if tm, ok := key.(encoding.TextMarshaler); ok {
+		b, err := tm.MarshalText()
+		return string(b), err
+	}
+	v := reflect.ValueOf(key)
+	switch v.Kind() {
+	case reflect.String:
+		return v.String(), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(v.Uint(), 10), nil
+	}
+	return "", fmt.Errorf("unsupported key type: %T", key)
+}
+
+func decodeMapKey(s string, key interface{}) error {
+	if tu, ok := key.(encoding.TextUnmarshaler); ok {
+		return tu.UnmarshalText([]byte(s))
+	}
+	v := reflect.ValueOf(key).Elem()
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+		return nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+		return nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n, err := strconv.ParseUint(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+		return nil
+	}
+	return fmt.Errorf("unsupported key type: %v", v.Type())
+}
@@ -0,0 +1,90 @@
+package cntr
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/argcv/stork/assert"
+)
+
+func TestOrderedMap(t *testing.T) {
+	om := NewOrderedMap[string, int]()
+	assert.ExpectTrue(t, om.Set("c", 1))
+	assert.ExpectTrue(t, om.Set("a", 2))
+	assert.ExpectTrue(t, om.Set("b", 3))
+	// update keeps the position
+	assert.ExpectFalse(t, om.Set("c", 4))
+
+	assert.ExpectEQ(t, 3, om.Len())
+	assert.ExpectEQ(t, []string{"c", "a", "b"}, om.Keys())
+	assert.ExpectEQ(t, []int{4, 2, 3}, om.Values())
+
+	v, ok := om.Get("a")
+	assert.ExpectTrue(t, ok)
+	assert.ExpectEQ(t, 2, v)
+	assert.ExpectEQ(t, 9, om.GetOrDefault("x", 9))
+
+	assert.ExpectTrue(t, om.Delete("a"))
+	assert.ExpectFalse(t, om.Delete("a"))
+	assert.ExpectFalse(t, om.Has("a"))
+	assert.ExpectEQ(t, []string{"c", "b"}, om.Keys())
+
+	// delete head and tail
+	om.Set("d", 5)
+	om.Delete("c")
+	om.Delete("d")
+	assert.ExpectEQ(t, []string{"b"}, om.Keys())
+
+	var keys []string
+	om.Set("e", 6)
+	om.Set("f", 7)
+	om.Range(func(key string, value int) bool {
+		keys = append(keys, key)
+		return key != "e"
+	})
+	assert.ExpectEQ(t, []string{"b", "e"}, keys)
+
+	c := om.Clone()
+	c.Delete("b")
+	assert.ExpectEQ(t, 3, om.Len())
+	assert.ExpectEQ(t, 2, c.Len())
+
+	om.Clear()
+	assert.ExpectEQ(t, 0, om.Len())
+	assert.ExpectEQ(t, 0, len(om.Keys()))
+}
+
+func TestOrderedMap_JSON(t *testing.T) {
+	om := NewOrderedMap[string, interface{}]()
+	om.Set("z", 1)
+	om.Set("a", "x")
+	om.Set("m", []int{1, 2})
+	b, err := json.Marshal(om)
+	assert.ExpectNoErr(t, err)
+	assert.ExpectEQ(t, `{"z":1,"a":"x","m":[1,2]}`, string(b))
+
+	om2 := NewOrderedMap[string, int]()
+	assert.ExpectNoErr(t, json.Unmarshal([]byte(`{"y":1,"b":2,"x":3}`), om2))
+	assert.ExpectEQ(t, []string{"y", "b", "x"}, om2.Keys())
+	assert.ExpectErr(t, json.Unmarshal([]byte(`[1]`), om2))
+
+	// integer keys
+	oi := NewOrderedMap[int, string]()
+	oi.Set(10, "a")
+	oi.Set(2, "b")
+	b, err = json.Marshal(oi)
+	assert.ExpectNoErr(t, err)
+	assert.ExpectEQ(t, `{"10":"a","2":"b"}`, string(b))
+	oi2 := NewOrderedMap[int, string]()
+	assert.ExpectNoErr(t, json.Unmarshal(b, oi2))
+	assert.ExpectEQ(t, []int{10, 2}, oi2.Keys())
+	assert.ExpectErr(t, json.Unmarshal([]byte(`{"x":"a"}`), oi2))
+
+	// nested in a struct
+	type wrapper struct {
+		Fields *OrderedMap[string, int] `json:"fields"`
+	}
+	w := wrapper{}
+	assert.ExpectNoErr(t, json.Unmarshal([]byte(`{"fields":{"q":1,"p":2}}`), &w))
+	assert.ExpectEQ(t, []string{"q", "p"}, w.Fields.Keys())
+}
@@ -12,6 +12,8 @@ const (
 	DefaultCharset          = CharsetLetters + CharsetNumbers
 )
 
+// RandomStringWithCharset is based on math/rand, which is predictable
+// Use SecureRandomString for the tokens and secrets.
 func RandomStringWithCharset(length int, charset string) string {
 	if len(charset) == 0 || length < 1 {
 		return ""
@@ -0,0 +1,134 @@
+package cntr
+
+import (
+	"encoding/json"
+)
+
+// RingBuffer is a bounded buffer, pushing into a full buffer overwrites
+// the oldest item. It is useful to keep the latest N records.
+// It is NOT thread safe.
+type RingBuffer[T any] struct {
+	buf  []T
+	head int // index of the oldest item
+	size int
+}
+
+// NewRingBuffer creates a ring buffer with the capacity, at least 1
+func NewRingBuffer[T any](capacity int) *RingBuffer[T] {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &RingBuffer[T]{
+		buf: make([]T, capacity),
+	}
+}
+
+// Push appends an item, if the buffer is full, the oldest item is
+// dropped and returned
+func (r *RingBuffer[T]) Push(item T) (evicted T, ok bool) {
+	if len(r.buf) == 0 {
+		r.buf = make([]T, 1)
+	}
+	if r.size == len(r.buf) {
+		evicted, ok = r.buf[r.head], true
+		r.buf[r.head] = item
+		r.head = (r.head + 1) % len(r.buf)
+		return
+	}
+	r.buf[(r.head+r.size)%len(r.buf)] = item
+	r.size++
+	return
+}
+
+// Pop removes and returns the oldest item
+func (r *RingBuffer[T]) Pop() (item T, ok bool) {
+	if r.size == 0 {
+		return
+	}
+	var zero T
+	item = r.buf[r.head]
+	r.buf[r.head] = zero
+	r.head = (r.head + 1) % len(r.buf)
+	r.size--
+	return item, true
+}
+
+// Peek returns the oldest item
+func (r *RingBuffer[T]) Peek() (item T, ok bool) {
+	if r.size == 0 {
+		return
+	}
+	return r.buf[r.head], true
+}
+
+// Last returns the newest item
+func (r *RingBuffer[T]) Last() (item T, ok bool) {
+	if r.size == 0 {
+		return
+	}
+	return r.buf[(r.head+r.size-1)%len(r.buf)], true
+}
+
+// At returns the i-th item from the oldest, it panics if i is out of range
+func (r *RingBuffer[T]) At(i int) T {
+	if i < 0 || i >= r.size {
+		panic("RingBuffer: index out of range")
+	}
+	return r.buf[(r.head+i)%len(r.buf)]
+}
+
+func (r *RingBuffer[T]) Len() int {
+	return r.size
+}
+
+func (r *RingBuffer[T]) Cap() int {
+	return len(r.buf)
+}
+
+func (r *RingBuffer[T]) Full() bool {
+	return r.size == len(r.buf)
+}
+
+func (r *RingBuffer[T]) Clear() {
+	var zero T
+	for i := range r.buf {
+		r.buf[i] = zero
+	}
+	r.head = 0
+	r.size = 0
+}
+
+// Items returns the items from the oldest to the newest
+func (r *RingBuffer[T]) Items() []T {
+	items := make([]T, r.size)
+	for i := range items {
+		items[i] = r.buf[(r.head+i)%len(r.buf)]
+	}
+	return items
+}
+
+// MarshalJSON encodes the buffer as a json array from the oldest to the newest
+func (r *RingBuffer[T]) MarshalJSON() ([]byte, error) {
+	return json.Marshal(r.Items())
+}
+
+// UnmarshalJSON decodes a json array, only the latest Cap() items are kept
+// For a buffer without capacity, the capacity is the length of the array
+func (r *RingBuffer[T]) UnmarshalJSON(data []byte) error {
+	var items []T
+	if err := json.Unmarshal(data, &items); err != nil {
+		return err
+	}
+	if len(r.buf) == 0 {
+		n := len(items)
+		if n < 1 {
+			n = 1
+		}
+		r.buf = make([]T, n)
+	}
+	r.Clear()
+	for _, item := range items {
+		r.Push(item)
+	}
+	return nil
+}
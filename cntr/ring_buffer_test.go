@@ -0,0 +1,64 @@
+package cntr
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/argcv/stork/assert"
+)
+
+func TestRingBuffer(t *testing.T) {
+	r := NewRingBuffer[int](3)
+	assert.ExpectEQ(t, 3, r.Cap())
+	for i := 1; i <= 3; i++ {
+		_, evicted := r.Push(i)
+		assert.ExpectFalse(t, evicted)
+	}
+	assert.ExpectTrue(t, r.Full())
+
+	v, evicted := r.Push(4)
+	assert.ExpectTrue(t, evicted)
+	assert.ExpectEQ(t, 1, v)
+	assert.ExpectEQ(t, []int{2, 3, 4}, r.Items())
+	assert.ExpectEQ(t, 3, r.At(1))
+
+	v, _ = r.Peek()
+	assert.ExpectEQ(t, 2, v)
+	v, _ = r.Last()
+	assert.ExpectEQ(t, 4, v)
+
+	v, ok := r.Pop()
+	assert.ExpectTrue(t, ok)
+	assert.ExpectEQ(t, 2, v)
+	assert.ExpectEQ(t, 2, r.Len())
+	assert.ExpectFalse(t, r.Full())
+
+	r.Push(5)
+	r.Push(6)
+	assert.ExpectEQ(t, []int{4, 5, 6}, r.Items())
+
+	r.Clear()
+	_, ok = r.Pop()
+	assert.ExpectFalse(t, ok)
+	assert.ExpectEQ(t, 0, len(r.Items()))
+}
+
+func TestRingBuffer_JSON(t *testing.T) {
+	r := NewRingBuffer[int](2)
+	r.Push(1)
+	r.Push(2)
+	r.Push(3)
+	b, err := json.Marshal(r)
+	assert.ExpectNoErr(t, err)
+	assert.ExpectEQ(t, `[2,3]`, string(b))
+
+	// only the latest items are kept
+	assert.ExpectNoErr(t, json.Unmarshal([]byte(`[7,8,9]`), r))
+	assert.ExpectEQ(t, []int{8, 9}, r.Items())
+
+	// capacity from the input
+	r2 := &RingBuffer[int]{}
+	assert.ExpectNoErr(t, json.Unmarshal([]byte(`[7,8,9]`), r2))
+	assert.ExpectEQ(t, 3, r2.Cap())
+	assert.ExpectEQ(t, []int{7, 8, 9}, r2.Items())
+}
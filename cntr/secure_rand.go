@@ -0,0 +1,219 @@
+package cntr
+
+import (
+	crand "crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrEmptyCharset   = errors.New("empty_charset")
+	ErrInvalidPattern = errors.New("invalid_pattern")
+)
+
+// patternCharsets are the placeholders in the patterns
+var patternCharsets = map[rune]string{
+	'X': CharsetUpperCaseLetters,
+	'x': CharsetLowerCaseLetters,
+	'9': CharsetNumbers,
+	'*': DefaultCharset,
+}
+
+/**
+ * RandomGenerator generates random numbers and strings from a source of
+ * random bytes, the charsets are sampled without bias.
+ *
+ * Use SecureRandom (crypto/rand) for the tokens, invite codes, secrets
+ * and so on. NewSeededRandom is a deterministic one for the tests.
+ *
+ * It is safe for concurrent use.
+ */
+type RandomGenerator struct {
+	mx  sync.Mutex
+	src io.Reader
+}
+
+// SecureRandom is the generator backed by crypto/rand
+var SecureRandom = NewRandomGenerator(crand.Reader)
+
+func NewRandomGenerator(src io.Reader) *RandomGenerator {
+	return &RandomGenerator{src: src}
+}
+
+// NewSeededRandom returns a deterministic generator, which always gives
+// the same results for the same seed. NEVER use it for secrets.
+func NewSeededRandom(seed int64) *RandomGenerator {
+	return NewRandomGenerator(rand.New(rand.NewSource(seed)))
+}
+
+// Read fills p with random bytes
+func (g *RandomGenerator) Read(p []byte) (int, error) {
+	g.mx.Lock()
+	defer g.mx.Unlock()
+	return io.ReadFull(g.src, p)
+}
+
+func (g *RandomGenerator) Bytes(n int) ([]byte, error) {
+	b := make([]byte, n)
+	if _, err := g.Read(b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Uint64n returns a uniform random number in [0, n), n must be positive
+func (g *RandomGenerator) Uint64n(n uint64) (uint64, error) {
+	if n == 0 {
+		return 0, errors.New("invalid argument to Uint64n")
+	}
+	// reject the values in the last incomplete range of n, [2^64 - r, 2^64)
+	r := (math.MaxUint64%n + 1) % n
+	var b [8]byte
+	for {
+		if _, err := g.Read(b[:]); err != nil {
+			return 0, err
+		}
+		v := binary.LittleEndian.Uint64(b[:])
+		if r == 0 || v < -r {
+			return v % n, nil
+		}
+	}
+}
+
+// Intn returns a uniform random number in [0, n), n must be positive
+func (g *RandomGenerator) Intn(n int) (int, error) {
+	if n <= 0 {
+		return 0, errors.New("invalid argument to Intn")
+	}
+	v, err := g.Uint64n(uint64(n))
+	return int(v), err
+}
+
+// charsetRunes returns the distinct runes of charset in order, so a
+// duplicated one is not more likely
+func charsetRunes(charset string) []rune {
+	seen := map[rune]bool{}
+	cs := make([]rune, 0, len(charset))
+	for _, c := range charset {
+		if !seen[c] {
+			seen[c] = true
+			cs = append(cs, c)
+		}
+	}
+	return cs
+}
+
+// String returns a random string of length characters (runes) in charset,
+// the duplicated characters count once
+func (g *RandomGenerator) String(length int, charset string) (string, error) {
+	cs := charsetRunes(charset)
+	if len(cs) == 0 {
+		return "", ErrEmptyCharset
+	}
+	sb := strings.Builder{}
+	for i := 0; i < length; i++ {
+		idx, err := g.Intn(len(cs))
+		if err != nil {
+			return "", err
+		}
+		sb.WriteRune(cs[idx])
+	}
+	return sb.String(), nil
+}
+
+// Token returns n random bytes in base64 (url safe, no padding), e.g.
+// for the session secrets or the api keys
+func (g *RandomGenerator) Token(n int) (string, error) {
+	b, err := g.Bytes(n)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Pattern returns a random string following the pattern, e.g. `XXXX-9999`
+//
+// - `X` an upper case letter
+// - `x` a lower case letter
+// - `9` a digit
+// - `*` a letter or a digit
+// - `\` escapes the next character
+//
+// The other characters are kept as they are.
+func (g *RandomGenerator) Pattern(pattern string) (string, error) {
+	sb := strings.Builder{}
+	err := walkPattern(pattern, func(literal rune, charset string) error {
+		if charset == "" {
+			sb.WriteRune(literal)
+			return nil
+		}
+		s, err := g.String(1, charset)
+		sb.WriteString(s)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return sb.String(), nil
+}
+
+func walkPattern(pattern string, f func(literal rune, charset string) error) error {
+	escaped := false
+	for _, c := range pattern {
+		if escaped {
+			escaped = false
+			if err := f(c, ""); err != nil {
+				return err
+			}
+			continue
+		}
+		if c == '\\' {
+			escaped = true
+			continue
+		}
+		if err := f(c, patternCharsets[c]); err != nil {
+			return err
+		}
+	}
+	if escaped {
+		return fmt.Errorf("%w: dangling escape in %q", ErrInvalidPattern, pattern)
+	}
+	return nil
+}
+
+// CharsetEntropy returns the entropy in bits of a random string of length
+// characters in charset, the duplicated characters count once
+func CharsetEntropy(length int, charset string) float64 {
+	n := len(charsetRunes(charset))
+	if n == 0 || length <= 0 {
+		return 0
+	}
+	return float64(length) * math.Log2(float64(n))
+}
+
+// PatternEntropy returns the entropy in bits of the strings of a pattern
+func PatternEntropy(pattern string) (float64, error) {
+	bits := 0.0
+	err := walkPattern(pattern, func(_ rune, charset string) error {
+		bits += CharsetEntropy(1, charset)
+		return nil
+	})
+	return bits, err
+}
+
+// SecureRandomString is RandomStringWithCharset backed by crypto/rand
+func SecureRandomString(length int, charset string) (string, error) {
+	return SecureRandom.String(length, charset)
+}
+
+// SecureRandomPattern is RandomGenerator.Pattern backed by crypto/rand
+func SecureRandomPattern(pattern string) (string, error) {
+	return SecureRandom.Pattern(pattern)
+}
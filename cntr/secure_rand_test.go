@@ -0,0 +1,98 @@
+package cntr
+
+import (
+	"errors"
+	"math"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/argcv/stork/assert"
+)
+
+type failingReader struct{}
+
+func (failingReader) Read(p []byte) (int, error) {
+	return 0, errors.New("no entropy")
+}
+
+func TestRandomGenerator_String(t *testing.T) {
+	s, err := SecureRandomString(32, DefaultCharset)
+	assert.ExpectNoErr(t, err)
+	assert.ExpectEQ(t, 32, len(s))
+	assert.ExpectTrue(t, regexp.MustCompile(`^[A-Za-z0-9]{32}$`).MatchString(s))
+
+	s, err = SecureRandom.String(5, "αβγ")
+	assert.ExpectNoErr(t, err)
+	assert.ExpectEQ(t, 5, len([]rune(s)))
+
+	_, err = SecureRandom.String(5, "")
+	assert.ExpectEQ(t, ErrEmptyCharset, err)
+
+	tok, err := SecureRandom.Token(32)
+	assert.ExpectNoErr(t, err)
+	assert.ExpectEQ(t, 43, len(tok))
+
+	_, err = NewRandomGenerator(failingReader{}).String(5, "ab")
+	assert.ExpectErr(t, err)
+}
+
+func TestRandomGenerator_Seeded(t *testing.T) {
+	g1, g2 := NewSeededRandom(7), NewSeededRandom(7)
+	for i := 0; i < 10; i++ {
+		s1, _ := g1.Pattern("XXXX-9999")
+		s2, _ := g2.Pattern("XXXX-9999")
+		assert.ExpectEQ(t, s1, s2)
+	}
+	s3, _ := NewSeededRandom(8).Pattern("XXXX-9999")
+	s4, _ := NewSeededRandom(7).Pattern("XXXX-9999")
+	assert.ExpectNE(t, s3, s4)
+}
+
+func TestRandomGenerator_Pattern(t *testing.T) {
+	s, err := SecureRandomPattern(`XXXX-9999-xx**-\X\9\\`)
+	assert.ExpectNoErr(t, err)
+	assert.ExpectTrue(t, regexp.MustCompile(`^[A-Z]{4}-[0-9]{4}-[a-z]{2}[A-Za-z0-9]{2}-X9\\$`).MatchString(s), s)
+
+	_, err = SecureRandomPattern(`XX\`)
+	assert.ExpectTrue(t, errors.Is(err, ErrInvalidPattern))
+}
+
+func TestRandomGenerator_Uniform(t *testing.T) {
+	g := NewSeededRandom(1)
+	// 3 does not divide 2^64, the naive modulo would be biased
+	counts := make([]int, 3)
+	n := 30000
+	for i := 0; i < n; i++ {
+		v, err := g.Intn(3)
+		assert.ExpectNoErr(t, err)
+		counts[v]++
+	}
+	for _, c := range counts {
+		assert.ExpectTrue(t, math.Abs(float64(c)-float64(n)/3) < float64(n)/30)
+	}
+	_, err := g.Intn(0)
+	assert.ExpectErr(t, err)
+}
+
+func TestRandomGenerator_DuplicatedCharset(t *testing.T) {
+	g := NewSeededRandom(1)
+	// the same as "ab", which the entropy is computed for
+	s, err := g.String(20000, "aaab")
+	assert.ExpectNoErr(t, err)
+	a := strings.Count(s, "a")
+	assert.ExpectEQ(t, len(s), a+strings.Count(s, "b"))
+	assert.ExpectTrue(t, math.Abs(float64(a)-10000) < 500)
+	assert.ExpectEQ(t, 20000.0, CharsetEntropy(20000, "aaab"))
+}
+
+func TestEntropy(t *testing.T) {
+	assert.ExpectEQ(t, 0.0, CharsetEntropy(10, ""))
+	assert.ExpectEQ(t, 8.0, CharsetEntropy(8, "01"))
+	assert.ExpectEQ(t, 8.0, CharsetEntropy(8, "0110"))
+	bits, err := PatternEntropy("XXXX-9999")
+	assert.ExpectNoErr(t, err)
+	assert.ExpectTrue(t, math.Abs(4*math.Log2(26)+4*math.Log2(10)-bits) < 1e-9)
+	bits, _ = PatternEntropy(`\X-`)
+	assert.ExpectEQ(t, 0.0, bits)
+}
@@ -0,0 +1,143 @@
+package cntr
+
+import (
+	"encoding/json"
+)
+
+// Set is a set which keeps the insertion order of the items,
+// so the iteration and the json output are stable.
+// It is NOT thread safe.
+type Set[T comparable] struct {
+	om OrderedMap[T, struct{}]
+}
+
+func NewSet[T comparable](items ...T) *Set[T] {
+	s := &Set[T]{}
+	s.Add(items...)
+	return s
+}
+
+// Add adds the items, returns the number of new items
+func (s *Set[T]) Add(items ...T) (n int) {
+	for _, item := range items {
+		if s.om.Set(item, struct{}{}) {
+			n++
+		}
+	}
+	return
+}
+
+// Remove removes the items, returns the number of removed items
+func (s *Set[T]) Remove(items ...T) (n int) {
+	for _, item := range items {
+		if s.om.Delete(item) {
+			n++
+		}
+	}
+	return
+}
+
+func (s *Set[T]) Contains(item T) bool {
+	return s.om.Has(item)
+}
+
+// ContainsAll returns true if all the items are in the set
+func (s *Set[T]) ContainsAll(items ...T) bool {
+	for _, item := range items {
+		if !s.om.Has(item) {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *Set[T]) Len() int {
+	return s.om.Len()
+}
+
+func (s *Set[T]) Clear() {
+	s.om.Clear()
+}
+
+// Items returns the items in insertion order
+func (s *Set[T]) Items() []T {
+	return s.om.Keys()
+}
+
+// Range calls f for each item in insertion order, until f returns false
+func (s *Set[T]) Range(f func(item T) bool) {
+	s.om.Range(func(key T, _ struct{}) bool {
+		return f(key)
+	})
+}
+
+func (s *Set[T]) Clone() *Set[T] {
+	return NewSet(s.Items()...)
+}
+
+// Union returns a new set with the items in s or other
+func (s *Set[T]) Union(other *Set[T]) *Set[T] {
+	u := s.Clone()
+	u.Add(other.Items()...)
+	return u
+}
+
+// Intersect returns a new set with the items in both s and other
+func (s *Set[T]) Intersect(other *Set[T]) *Set[T] {
+	r := NewSet[T]()
+	s.Range(func(item T) bool {
+		if other.Contains(item) {
+			r.Add(item)
+		}
+		return true
+	})
+	return r
+}
+
+// Diff returns a new set with the items in s but not in other
+func (s *Set[T]) Diff(other *Set[T]) *Set[T] {
+	r := NewSet[T]()
+	s.Range(func(item T) bool {
+		if !other.Contains(item) {
+			r.Add(item)
+		}
+		return true
+	})
+	return r
+}
+
+// SymmetricDiff returns a new set with the items in exactly one of s and other
+func (s *Set[T]) SymmetricDiff(other *Set[T]) *Set[T] {
+	r := s.Diff(other)
+	r.Add(other.Diff(s).Items()...)
+	return r
+}
+
+// IsSubsetOf returns true if all the items of s are in other
+func (s *Set[T]) IsSubsetOf(other *Set[T]) bool {
+	if s.Len() > other.Len() {
+		return false
+	}
+	return other.ContainsAll(s.Items()...)
+}
+
+// Equal returns true if both sets have the same items, the order is ignored
+func (s *Set[T]) Equal(other *Set[T]) bool {
+	return s.Len() == other.Len() && s.IsSubsetOf(other)
+}
+
+// MarshalJSON encodes the set as a json array in insertion order
+func (s *Set[T]) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.Items())
+}
+
+// UnmarshalJSON decodes a json array, the duplicated items are merged
+func (s *Set[T]) UnmarshalJSON(data []byte) error {
+	var items []T
+	if err := json.Unmarshal(data, &items); err != nil {
+		return err
+	}
+	s.Clear()
+	s.Add(items...)
+	return nil
+}
@@ -0,0 +1,58 @@
+package cntr
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/argcv/stork/assert"
+)
+
+func TestSet(t *testing.T) {
+	s := NewSet("b", "a", "b", "c")
+	assert.ExpectEQ(t, 3, s.Len())
+	assert.ExpectEQ(t, []string{"b", "a", "c"}, s.Items())
+	assert.ExpectTrue(t, s.Contains("a"))
+	assert.ExpectFalse(t, s.Contains("d"))
+	assert.ExpectTrue(t, s.ContainsAll("a", "c"))
+	assert.ExpectFalse(t, s.ContainsAll("a", "d"))
+
+	assert.ExpectEQ(t, 1, s.Add("a", "d"))
+	assert.ExpectEQ(t, 1, s.Remove("a", "x"))
+	assert.ExpectEQ(t, []string{"b", "c", "d"}, s.Items())
+
+	// the zero value is ready to use
+	var z Set[int]
+	assert.ExpectFalse(t, z.Contains(1))
+	z.Add(1)
+	assert.ExpectEQ(t, 1, z.Len())
+}
+
+func TestSet_Algebra(t *testing.T) {
+	a := NewSet(1, 2, 3, 4)
+	b := NewSet(3, 4, 5)
+
+	assert.ExpectEQ(t, []int{1, 2, 3, 4, 5}, a.Union(b).Items())
+	assert.ExpectEQ(t, []int{3, 4}, a.Intersect(b).Items())
+	assert.ExpectEQ(t, []int{1, 2}, a.Diff(b).Items())
+	assert.ExpectEQ(t, []int{1, 2, 5}, a.SymmetricDiff(b).Items())
+
+	assert.ExpectTrue(t, NewSet(4, 3).IsSubsetOf(a))
+	assert.ExpectFalse(t, b.IsSubsetOf(a))
+	assert.ExpectTrue(t, NewSet(4, 3, 2, 1).Equal(a))
+	assert.ExpectFalse(t, b.Equal(a))
+
+	// the operands are untouched
+	assert.ExpectEQ(t, 4, a.Len())
+	assert.ExpectEQ(t, 3, b.Len())
+}
+
+func TestSet_JSON(t *testing.T) {
+	s := NewSet("z", "a", "m")
+	b, err := json.Marshal(s)
+	assert.ExpectNoErr(t, err)
+	assert.ExpectEQ(t, `["z","a","m"]`, string(b))
+
+	s2 := NewSet[string]()
+	assert.ExpectNoErr(t, json.Unmarshal([]byte(`["x","y","x"]`), s2))
+	assert.ExpectEQ(t, []string{"x", "y"}, s2.Items())
+}
@@ -0,0 +1,298 @@
+package cntr
+
+import (
+	"math"
+	"strings"
+)
+
+// JaroSimilarity returns the Jaro similarity in [0, 1], 1 for equal strings
+func JaroSimilarity(s1, s2 string) float64 {
+	a, b := []rune(s1), []rune(s2)
+	if len(a) == 0 && len(b) == 0 {
+		return 1
+	}
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+	window := len(a)
+	if len(b) > window {
+		window = len(b)
+	}
+	window = window/2 - 1
+	if window < 0 {
+		window = 0
+	}
+
+	matchedA := make([]bool, len(a))
+	matchedB := make([]bool, len(b))
+	matches := 0
+	for i := range a {
+		lo, hi := i-window, i+window+1
+		if lo < 0 {
+			lo = 0
+		}
+		if hi > len(b) {
+			hi = len(b)
+		}
+		for j := lo; j < hi; j++ {
+			if !matchedB[j] && a[i] == b[j] {
+				matchedA[i], matchedB[j] = true, true
+				matches++
+				break
+			}
+		}
+	}
+	if matches == 0 {
+		return 0
+	}
+
+	// half of the matched characters out of order
+	transpositions := 0
+	j := 0
+	for i := range a {
+		if !matchedA[i] {
+			continue
+		}
+		for !matchedB[j] {
+			j++
+		}
+		if a[i] != b[j] {
+			transpositions++
+		}
+		j++
+	}
+	m := float64(matches)
+	return (m/float64(len(a)) + m/float64(len(b)) + (m-float64(transpositions)/2)/m) / 3
+}
+
+// JaroWinklerSimilarity returns the Jaro-Winkler similarity in [0, 1],
+// which favors the strings with a common prefix (up to 4 characters)
+func JaroWinklerSimilarity(s1, s2 string) float64 {
+	sim := JaroSimilarity(s1, s2)
+	a, b := []rune(s1), []rune(s2)
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && prefix < 4 && a[prefix] == b[prefix] {
+		prefix++
+	}
+	return sim + float64(prefix)*0.1*(1-sim)
+}
+
+// LongestCommonSubsequence returns a longest common subsequence of
+// two strings, in runes
+func LongestCommonSubsequence(s1, s2 string) string {
+	a, b := []rune(s1), []rune(s2)
+	d := make([][]int, len(a)+1)
+	for i := range d {
+		d[i] = make([]int, len(b)+1)
+	}
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			if a[i-1] == b[j-1] {
+				d[i][j] = d[i-1][j-1] + 1
+			} else if d[i-1][j] >= d[i][j-1] {
+				d[i][j] = d[i-1][j]
+			} else {
+				d[i][j] = d[i][j-1]
+			}
+		}
+	}
+	lcs := make([]rune, d[len(a)][len(b)])
+	for i, j, k := len(a), len(b), len(lcs)-1; k >= 0; {
+		switch {
+		case a[i-1] == b[j-1]:
+			lcs[k] = a[i-1]
+			i, j, k = i-1, j-1, k-1
+		case d[i-1][j] >= d[i][j-1]:
+			i--
+		default:
+			j--
+		}
+	}
+	return string(lcs)
+}
+
+// LCSSimilarity returns 2 * |lcs| / (|s1| + |s2|) in [0, 1]
+func LCSSimilarity(s1, s2 string) float64 {
+	total := len([]rune(s1)) + len([]rune(s2))
+	if total == 0 {
+		return 1
+	}
+	return 2 * float64(len([]rune(LongestCommonSubsequence(s1, s2)))) / float64(total)
+}
+
+// NGrams returns the n-grams of a string in runes, the duplicates are
+// kept. A string shorter than n is a gram itself.
+func NGrams(s string, n int) []string {
+	r := []rune(s)
+	if n < 1 {
+		n = 1
+	}
+	if len(r) == 0 {
+		return nil
+	}
+	if len(r) <= n {
+		return []string{s}
+	}
+	grams := make([]string, 0, len(r)-n+1)
+	for i := 0; i+n <= len(r); i++ {
+		grams = append(grams, string(r[i:i+n]))
+	}
+	return grams
+}
+
+// JaccardSimilarity returns the Jaccard index of the n-gram sets of two
+// strings in [0, 1], 1 for two empty strings
+func JaccardSimilarity(s1, s2 string, n int) float64 {
+	g1, g2 := NewSet(NGrams(s1, n)...), NewSet(NGrams(s2, n)...)
+	union := g1.Union(g2).Len()
+	if union == 0 {
+		return 1
+	}
+	return float64(g1.Intersect(g2).Len()) / float64(union)
+}
+
+type EditOp byte
+
+const (
+	EditEqual      EditOp = '='
+	EditSubstitute EditOp = '~'
+	EditInsert     EditOp = '+'
+	EditDelete     EditOp = '-'
+	EditTranspose  EditOp = 'x'
+)
+
+func (op EditOp) String() string {
+	switch op {
+	case EditEqual:
+		return "equal"
+	case EditSubstitute:
+		return "substitute"
+	case EditInsert:
+		return "insert"
+	case EditDelete:
+		return "delete"
+	case EditTranspose:
+		return "transpose"
+	}
+	return "unknown"
+}
+
+// EditStep is an operation in an Alignment
+// From is the text in the source, To is the text in the target,
+// I and J are their positions in runes.
+type EditStep struct {
+	Op   EditOp
+	From string
+	To   string
+	I    int
+	J    int
+}
+
+// Alignment is a sequence of operations to transform a string into another
+type Alignment struct {
+	Steps []EditStep
+	Cost  float64
+}
+
+// Align returns the cheapest alignment of two strings, with the costs
+// of WeightedEditDistance
+func Align(s1, s2 string, costs EditCosts) Alignment {
+	c := costs.normalized()
+	a, b := []rune(s1), []rune(s2)
+	d := make([][]float64, len(a)+1)
+	for i := range d {
+		d[i] = make([]float64, len(b)+1)
+	}
+	for j := 1; j <= len(b); j++ {
+		d[0][j] = d[0][j-1] + c.Insert
+	}
+	canSwap := func(i, j int) bool {
+		return c.Transpose > 0 && i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] && a[i-1] != b[j-1]
+	}
+	subCost := func(i, j int) float64 {
+		if a[i-1] == b[j-1] {
+			return 0
+		}
+		return c.Substitute
+	}
+	for i := 1; i <= len(a); i++ {
+		d[i][0] = d[i-1][0] + c.Delete
+		for j := 1; j <= len(b); j++ {
+			v := d[i-1][j-1] + subCost(i, j)
+			v = math.Min(v, d[i-1][j]+c.Delete)
+			v = math.Min(v, d[i][j-1]+c.Insert)
+			if canSwap(i, j) {
+				v = math.Min(v, d[i-2][j-2]+c.Transpose)
+			}
+			d[i][j] = v
+		}
+	}
+
+	var steps []EditStep
+	for i, j := len(a), len(b); i > 0 || j > 0; {
+		switch {
+		case i > 0 && j > 0 && d[i][j] == d[i-1][j-1]+subCost(i, j):
+			op := EditEqual
+			if a[i-1] != b[j-1] {
+				op = EditSubstitute
+			}
+			steps = append(steps, EditStep{Op: op, From: string(a[i-1]), To: string(b[j-1]), I: i - 1, J: j - 1})
+			i, j = i-1, j-1
+		case canSwap(i, j) && d[i][j] == d[i-2][j-2]+c.Transpose:
+			steps = append(steps, EditStep{Op: EditTranspose, From: string(a[i-2 : i]), To: string(b[j-2 : j]), I: i - 2, J: j - 2})
+			i, j = i-2, j-2
+		case i > 0 && d[i][j] == d[i-1][j]+c.Delete:
+			steps = append(steps, EditStep{Op: EditDelete, From: string(a[i-1]), I: i - 1, J: j})
+			i--
+		default:
+			steps = append(steps, EditStep{Op: EditInsert, To: string(b[j-1]), I: i, J: j - 1})
+			j--
+		}
+	}
+	for l, r := 0, len(steps)-1; l < r; l, r = l+1, r-1 {
+		steps[l], steps[r] = steps[r], steps[l]
+	}
+	return Alignment{Steps: steps, Cost: d[len(a)][len(b)]}
+}
+
+// Ops returns the operations in a line, e.g. `=~=+` for `cat` to `cuts`
+func (al Alignment) Ops() string {
+	sb := strings.Builder{}
+	for _, s := range al.Steps {
+		sb.WriteByte(byte(s.Op))
+	}
+	return sb.String()
+}
+
+// Chunks merges the adjacent steps with the same operation, except the
+// transpositions, which is handy for a diff output
+func (al Alignment) Chunks() []EditStep {
+	var chunks []EditStep
+	for _, s := range al.Steps {
+		if n := len(chunks); n > 0 && chunks[n-1].Op == s.Op && s.Op != EditTranspose {
+			chunks[n-1].From += s.From
+			chunks[n-1].To += s.To
+			continue
+		}
+		chunks = append(chunks, s)
+	}
+	return chunks
+}
+
+// String formats the alignment as a diff, e.g. `c[a->u]t{+s}`
+func (al Alignment) String() string {
+	sb := strings.Builder{}
+	for _, c := range al.Chunks() {
+		switch c.Op {
+		case EditEqual:
+			sb.WriteString(c.From)
+		case EditInsert:
+			sb.WriteString("{+" + c.To + "}")
+		case EditDelete:
+			sb.WriteString("{-" + c.From + "}")
+		default:
+			sb.WriteString("[" + c.From + "->" + c.To + "]")
+		}
+	}
+	return sb.String()
+}
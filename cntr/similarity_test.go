@@ -0,0 +1,75 @@
+package cntr
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/argcv/stork/assert"
+)
+
+func expectNear(t *testing.T, want, got float64) {
+	t.Helper()
+	assert.ExpectTrue(t, math.Abs(want-got) < 1e-3, fmt.Sprintf("want %v, got %v", want, got))
+}
+
+func TestJaroWinklerSimilarity(t *testing.T) {
+	expectNear(t, 1, JaroSimilarity("", ""))
+	expectNear(t, 0, JaroSimilarity("abc", ""))
+	expectNear(t, 0.944, JaroSimilarity("MARTHA", "MARHTA"))
+	expectNear(t, 0.767, JaroSimilarity("DIXON", "DICKSONX"))
+	expectNear(t, 0.961, JaroWinklerSimilarity("MARTHA", "MARHTA"))
+	expectNear(t, 0.813, JaroWinklerSimilarity("DIXON", "DICKSONX"))
+	expectNear(t, 0.840, JaroWinklerSimilarity("DWAYNE", "DUANE"))
+	expectNear(t, 1, JaroWinklerSimilarity("同じ", "同じ"))
+	expectNear(t, 0, JaroWinklerSimilarity("abc", "xyz"))
+}
+
+func TestLongestCommonSubsequence(t *testing.T) {
+	assert.ExpectEQ(t, "", LongestCommonSubsequence("", "abc"))
+	assert.ExpectEQ(t, "GTAB", LongestCommonSubsequence("AGGTAB", "GXTXAYB"))
+	assert.ExpectEQ(t, "日語", LongestCommonSubsequence("日本語", "日の語"))
+	expectNear(t, 1, LCSSimilarity("", ""))
+	expectNear(t, 8.0/13, LCSSimilarity("AGGTAB", "GXTXAYB"))
+}
+
+func TestJaccardSimilarity(t *testing.T) {
+	assert.ExpectEQ(t, []string{"ab", "bc", "cd"}, NGrams("abcd", 2))
+	assert.ExpectEQ(t, []string{"a"}, NGrams("a", 2))
+	assert.ExpectEQ(t, 0, len(NGrams("", 2)))
+
+	expectNear(t, 1, JaccardSimilarity("", "", 2))
+	expectNear(t, 1, JaccardSimilarity("night", "night", 2))
+	// ni ig gh ht vs na ac ch ht
+	expectNear(t, 1.0/7, JaccardSimilarity("night", "nacht", 2))
+	expectNear(t, 0, JaccardSimilarity("abc", "xyz", 3))
+}
+
+func TestAlign(t *testing.T) {
+	al := Align("cat", "cuts", DefaultEditCosts)
+	assert.ExpectEQ(t, 2.0, al.Cost)
+	assert.ExpectEQ(t, "=~=+", al.Ops())
+	assert.ExpectEQ(t, "c[a->u]t{+s}", al.String())
+	assert.ExpectEQ(t, EditStep{Op: EditInsert, To: "s", I: 3, J: 3}, al.Steps[3])
+
+	al = Align("kitten", "sitting", DefaultEditCosts)
+	assert.ExpectEQ(t, 3.0, al.Cost)
+	assert.ExpectEQ(t, "[k->s]itt[e->i]n{+g}", al.String())
+
+	al = Align("abcdef", "abef", DefaultEditCosts)
+	assert.ExpectEQ(t, "ab{-cd}ef", al.String())
+	assert.ExpectEQ(t, 3, len(al.Chunks()))
+
+	costs := DefaultEditCosts
+	costs.Transpose = 1
+	al = Align("form", "from", costs)
+	assert.ExpectEQ(t, 1.0, al.Cost)
+	assert.ExpectEQ(t, "f[or->ro]m", al.String())
+	assert.ExpectEQ(t, "=x=", al.Ops())
+	assert.ExpectEQ(t, "transpose", al.Steps[1].Op.String())
+
+	// the cost is consistent with the distance
+	for _, p := range [][2]string{{"", "abc"}, {"abc", ""}, {"flaw", "lawn"}, {"日本語", "本日語"}} {
+		assert.ExpectEQ(t, WeightedEditDistance(p[0], p[1], costs), Align(p[0], p[1], costs).Cost)
+	}
+}
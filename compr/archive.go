@@ -0,0 +1,504 @@
+package compr
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	ArchiveTar   = "tar"
+	ArchiveTarGz = "tar.gz"
+	ArchiveZip   = "zip"
+)
+
+var (
+	ErrUnknownArchive = errors.New("unknown_archive")
+	ErrUnsafePath     = errors.New("unsafe_path")
+)
+
+// ArchiveFormat returns the format by the file extension, e.g. .tgz
+func ArchiveFormat(name string) (string, error) {
+	lower := strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(lower, ".tar.gz"), strings.HasSuffix(lower, ".tgz"):
+		return ArchiveTarGz, nil
+	case strings.HasSuffix(lower, ".tar"):
+		return ArchiveTar, nil
+	case strings.HasSuffix(lower, ".zip"):
+		return ArchiveZip, nil
+	}
+	return "", fmt.Errorf("%w: %s", ErrUnknownArchive, name)
+}
+
+// ArchiveEntry is a file or a directory in the archive
+type ArchiveEntry struct {
+	Name    string      // slash separated and relative
+	Size    int64       // required by tar
+	Mode    os.FileMode // 0644 (files) or 0755 (dirs) if zero
+	ModTime time.Time   // now if zero
+	IsDir   bool
+}
+
+func (e *ArchiveEntry) normalize() (err error) {
+	if e.Name, err = cleanEntryName(e.Name); err != nil {
+		return
+	}
+	if e.Mode.Perm() == 0 {
+		if e.IsDir {
+			e.Mode |= 0755
+		} else {
+			e.Mode |= 0644
+		}
+	}
+	if e.ModTime.IsZero() {
+		e.ModTime = time.Now()
+	}
+	return
+}
+
+// cleanEntryName rejects the absolute names and the names escaping the
+// root, backslashes are treated as separators
+func cleanEntryName(name string) (string, error) {
+	n := strings.ReplaceAll(name, "\\", "/")
+	if n == "" || strings.HasPrefix(n, "/") || (len(n) > 1 && n[1] == ':') {
+		return "", fmt.Errorf("%w: %q", ErrUnsafePath, name)
+	}
+	n = path.Clean(n)
+	if n == "." || n == ".." || strings.HasPrefix(n, "../") {
+		return "", fmt.Errorf("%w: %q", ErrUnsafePath, name)
+	}
+	return n, nil
+}
+
+/**
+ * ArchiveWriter writes a tar, tar.gz or zip archive, the content is
+ * streamed to the underlying writer, so the large files never sit in
+ * the memory.
+ *
+ * The writer must be closed to flush the archive.
+ */
+type ArchiveWriter struct {
+	format string
+	gz     *gzip.Writer
+	tw     *tar.Writer
+	zw     *zip.Writer
+}
+
+// NewArchiveWriter returns a writer of the format
+func NewArchiveWriter(w io.Writer, format string) (*ArchiveWriter, error) {
+	a := &ArchiveWriter{format: format}
+	switch format {
+	case ArchiveTar:
+		a.tw = tar.NewWriter(w)
+	case ArchiveTarGz:
+		a.gz = gzip.NewWriter(w)
+		a.tw = tar.NewWriter(a.gz)
+	case ArchiveZip:
+		a.zw = zip.NewWriter(w)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownArchive, format)
+	}
+	return a, nil
+}
+
+// Add writes an entry, the body is ignored for the directories
+// For tar, the body must have exactly e.Size bytes.
+func (a *ArchiveWriter) Add(e ArchiveEntry, body io.Reader) error {
+	if err := e.normalize(); err != nil {
+		return err
+	}
+	if a.zw != nil {
+		return a.addZip(e, body)
+	}
+	hdr := &tar.Header{
+		Name:    e.Name,
+		Mode:    int64(e.Mode.Perm()),
+		ModTime: e.ModTime,
+		Size:    e.Size,
+	}
+	if e.IsDir {
+		hdr.Name += "/"
+		hdr.Typeflag = tar.TypeDir
+		hdr.Size = 0
+	} else {
+		hdr.Typeflag = tar.TypeReg
+	}
+	if err := a.tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if e.IsDir {
+		return nil
+	}
+	n, err := io.CopyN(a.tw, body, e.Size)
+	if err == io.EOF {
+		return fmt.Errorf("archive: %s has %d bytes, expect %d", e.Name, n, e.Size)
+	}
+	return err
+}
+
+func (a *ArchiveWriter) addZip(e ArchiveEntry, body io.Reader) error {
+	fh := &zip.FileHeader{
+		Name:     e.Name,
+		Method:   zip.Deflate,
+		Modified: e.ModTime,
+	}
+	mode := e.Mode.Perm()
+	if e.IsDir {
+		fh.Name += "/"
+		fh.Method = zip.Store
+		mode |= os.ModeDir
+	}
+	fh.SetMode(mode)
+	w, err := a.zw.CreateHeader(fh)
+	if err != nil || e.IsDir {
+		return err
+	}
+	_, err = io.Copy(w, body)
+	return err
+}
+
+// AddBytes writes a file from the memory
+func (a *ArchiveWriter) AddBytes(name string, data []byte) error {
+	return a.Add(ArchiveEntry{Name: name, Size: int64(len(data))}, bytes.NewReader(data))
+}
+
+// AddFile writes a file or a directory (recursively) from the disk as
+// name, the symlinks and the special files are skipped
+func (a *ArchiveWriter) AddFile(file, name string) error {
+	fi, err := os.Stat(file)
+	if err != nil {
+		return err
+	}
+	if fi.IsDir() {
+		return a.AddDir(file, name)
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return a.Add(ArchiveEntry{
+		Name:    name,
+		Size:    fi.Size(),
+		Mode:    fi.Mode(),
+		ModTime: fi.ModTime(),
+	}, f)
+}
+
+// AddDir writes the files under root with the prefix, an empty prefix
+// puts them at the top level
+func (a *ArchiveWriter) AddDir(root, prefix string) error {
+	return filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		name := path.Join(prefix, filepath.ToSlash(rel))
+		if name == "." || name == "" {
+			return nil
+		}
+		switch {
+		case d.IsDir():
+			fi, err := d.Info()
+			if err != nil {
+				return err
+			}
+			return a.Add(ArchiveEntry{Name: name, Mode: fi.Mode(), ModTime: fi.ModTime(), IsDir: true}, nil)
+		case d.Type().IsRegular():
+			return a.AddFile(p, name)
+		}
+		return nil
+	})
+}
+
+// Close flushes the archive, the underlying writer is not closed
+func (a *ArchiveWriter) Close() error {
+	if a.zw != nil {
+		return a.zw.Close()
+	}
+	if err := a.tw.Close(); err != nil {
+		return err
+	}
+	if a.gz != nil {
+		return a.gz.Close()
+	}
+	return nil
+}
+
+// WriteArchive writes the files in the memory as an archive, in the
+// order of names
+func WriteArchive(w io.Writer, format string, names []string, files map[string][]byte) error {
+	a, err := NewArchiveWriter(w, format)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if err = a.AddBytes(name, files[name]); err != nil {
+			return err
+		}
+	}
+	return a.Close()
+}
+
+/**
+ * WalkArchive reads the entries of the archive in order, the body is only
+ * valid in the callback and nil for the directories.
+ *
+ * The links and the special files are skipped, the names escaping the root
+ * fail with ErrUnsafePath.
+ *
+ * Zip requires the random access, r is read directly if it is a
+ * *bytes.Reader, *strings.Reader or *os.File, or else it is spooled to a
+ * temp file.
+ */
+func WalkArchive(r io.Reader, format string, fn func(e ArchiveEntry, body io.Reader) error) error {
+	return walkArchive(r, format, Limits{}, fn)
+}
+
+// walkArchive is WalkArchive with the limits on the decompressed size of
+// the whole archive, for zip it is the sum of the entries read, and the
+// ratio is over their compressed sizes
+func walkArchive(r io.Reader, format string, limits Limits, fn func(e ArchiveEntry, body io.Reader) error) error {
+	switch format {
+	case ArchiveTar, ArchiveTarGz:
+		in := &countingReader{r: r}
+		var rc io.ReadCloser = io.NopCloser(in)
+		if format == ArchiveTarGz {
+			gr, err := gzip.NewReader(in)
+			if err != nil {
+				return err
+			}
+			rc = gr
+		}
+		defer rc.Close()
+		if limits == (Limits{}) {
+			return walkTar(rc, fn)
+		}
+		return walkTar(&limitedReader{rc: rc, in: in, limits: limits}, fn)
+	case ArchiveZip:
+		return walkZip(r, limits, fn)
+	}
+	return fmt.Errorf("%w: %s", ErrUnknownArchive, format)
+}
+
+func walkTar(r io.Reader, fn func(e ArchiveEntry, body io.Reader) error) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		e := ArchiveEntry{
+			Name:    hdr.Name,
+			Size:    hdr.Size,
+			Mode:    os.FileMode(hdr.Mode).Perm(),
+			ModTime: hdr.ModTime,
+		}
+		var body io.Reader
+		// by the type flag, the mode of a hard link is regular
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			e.IsDir, e.Size = true, 0
+		case tar.TypeReg:
+			body = tr
+		default:
+			continue
+		}
+		if e.Name, err = cleanEntryName(e.Name); err != nil {
+			return err
+		}
+		if err = fn(e, body); err != nil {
+			return err
+		}
+	}
+}
+
+func walkZip(r io.Reader, limits Limits, fn func(e ArchiveEntry, body io.Reader) error) error {
+	var ra io.ReaderAt
+	var size int64
+	switch v := r.(type) {
+	case *bytes.Reader:
+		ra, size = v, v.Size()
+	case *strings.Reader:
+		ra, size = v, v.Size()
+	case *os.File:
+		fi, err := v.Stat()
+		if err != nil {
+			return err
+		}
+		ra, size = v, fi.Size()
+	default:
+		tmp, err := os.CreateTemp("", "stork-zip-*")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(tmp.Name())
+		defer tmp.Close()
+		if size, err = io.Copy(tmp, r); err != nil {
+			return err
+		}
+		ra = tmp
+	}
+	zr, err := zip.NewReader(ra, size)
+	if err != nil {
+		return err
+	}
+	// shared by the entries, the input is the compressed size read
+	var lr *limitedReader
+	if limits != (Limits{}) {
+		lr = &limitedReader{in: &countingReader{}, limits: limits}
+	}
+	for _, f := range zr.File {
+		mode := f.Mode()
+		e := ArchiveEntry{
+			Name:    f.Name,
+			Size:    int64(f.UncompressedSize64),
+			Mode:    mode.Perm(),
+			ModTime: f.Modified,
+			IsDir:   mode.IsDir(),
+		}
+		if !e.IsDir && !mode.IsRegular() {
+			continue
+		}
+		if f.ModifiedDate == 0 && f.ModifiedTime == 0 && f.Modified.Equal(zipZeroTime) {
+			// undated
+			e.ModTime = time.Time{}
+		}
+		if e.Name, err = cleanEntryName(e.Name); err != nil {
+			return err
+		}
+		if e.IsDir {
+			e.Size = 0
+			if err = fn(e, nil); err != nil {
+				return err
+			}
+			continue
+		}
+		if err = walkZipFile(f, e, lr, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// zipZeroTime is the DOS time of zero, as the zip reader parses it
+var zipZeroTime = time.Date(1980, 0, 0, 0, 0, 0, 0, time.UTC)
+
+func walkZipFile(f *zip.File, e ArchiveEntry, lr *limitedReader, fn func(e ArchiveEntry, body io.Reader) error) error {
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	if lr == nil {
+		return fn(e, rc)
+	}
+	lr.rc = rc
+	lr.in.n += int64(f.CompressedSize64)
+	return fn(e, lr)
+}
+
+// ReadArchive reads all the files of the archive into the memory, the
+// directories are omitted
+func ReadArchive(r io.Reader, format string) (map[string][]byte, error) {
+	files := map[string][]byte{}
+	err := WalkArchive(r, format, func(e ArchiveEntry, body io.Reader) error {
+		if e.IsDir {
+			return nil
+		}
+		data, err := io.ReadAll(body)
+		files[e.Name] = data
+		return err
+	})
+	return files, err
+}
+
+// safeJoin joins the cleaned entry name to dest, and checks it again
+func safeJoin(dest, name string) (string, error) {
+	p := filepath.Join(dest, filepath.FromSlash(name))
+	rel, err := filepath.Rel(dest, p)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%w: %q", ErrUnsafePath, name)
+	}
+	return p, nil
+}
+
+// Extract extracts the archive into dest, see WalkArchive for the
+// skipped entries
+func Extract(r io.Reader, format, dest string) error {
+	return ExtractLimited(r, format, dest, Limits{})
+}
+
+// ExtractLimited is Extract with the limits on the decompressed size of
+// the whole archive, a *LimitError is returned once a limit is exceeded,
+// and the files already extracted are left in dest
+func ExtractLimited(r io.Reader, format, dest string, limits Limits) error {
+	if err := os.MkdirAll(dest, 0755); err != nil {
+		return err
+	}
+	return walkArchive(r, format, limits, func(e ArchiveEntry, body io.Reader) error {
+		p, err := safeJoin(dest, e.Name)
+		if err != nil {
+			return err
+		}
+		if e.IsDir {
+			return os.MkdirAll(p, e.Mode|0700)
+		}
+		if err = os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			return err
+		}
+		mode := e.Mode
+		if mode == 0 {
+			mode = 0644
+		}
+		f, err := os.OpenFile(p, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, mode)
+		if err != nil {
+			return err
+		}
+		if _, err = io.Copy(f, body); err != nil {
+			f.Close()
+			return err
+		}
+		if err = f.Close(); err != nil || e.ModTime.IsZero() {
+			return err
+		}
+		return os.Chtimes(p, e.ModTime, e.ModTime)
+	})
+}
+
+// ExtractFile extracts the archive file into dest, the format is detected
+// from the extension
+func ExtractFile(file, dest string) error {
+	return ExtractFileLimited(file, dest, Limits{})
+}
+
+// ExtractFileLimited is ExtractFile with the limits
+func ExtractFileLimited(file, dest string, limits Limits) error {
+	format, err := ArchiveFormat(file)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return ExtractLimited(f, format, dest, limits)
+}
@@ -0,0 +1,277 @@
+package compr
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/argcv/stork/assert"
+)
+
+var archiveFormats = []string{ArchiveTar, ArchiveTarGz, ArchiveZip}
+
+func testArchiveFiles() ([]string, map[string][]byte) {
+	names := []string{"a.txt", "dir/b.json", "dir/sub/c.bin", "empty"}
+	return names, map[string][]byte{
+		"a.txt":         []byte("hello"),
+		"dir/b.json":    benchPayload(100000),
+		"dir/sub/c.bin": randomBytes(3000),
+		"empty":         {},
+	}
+}
+
+// rawArchive writes the entries as they are, which may be unsafe
+func rawArchive(t *testing.T, format string, entries ...*tar.Header) []byte {
+	buf := &bytes.Buffer{}
+	if format == ArchiveZip {
+		zw := zip.NewWriter(buf)
+		for _, hdr := range entries {
+			if hdr.Typeflag == tar.TypeLink {
+				// no hard links in zip
+				continue
+			}
+			fh := &zip.FileHeader{Name: hdr.Name, Method: zip.Deflate}
+			mode := os.FileMode(0644)
+			body := []byte("x")
+			if hdr.Typeflag == tar.TypeSymlink {
+				mode |= os.ModeSymlink
+				body = []byte(hdr.Linkname)
+			}
+			fh.SetMode(mode)
+			w, err := zw.CreateHeader(fh)
+			assert.ExpectNoErr(t, err)
+			_, err = w.Write(body)
+			assert.ExpectNoErr(t, err)
+		}
+		assert.ExpectNoErr(t, zw.Close())
+		return buf.Bytes()
+	}
+	var w io.Writer = buf
+	var gz *gzip.Writer
+	if format == ArchiveTarGz {
+		gz = gzip.NewWriter(buf)
+		w = gz
+	}
+	tw := tar.NewWriter(w)
+	for _, hdr := range entries {
+		if hdr.Typeflag == 0 {
+			hdr.Typeflag = tar.TypeReg
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			hdr.Size = 1
+		}
+		hdr.Mode = 0644
+		assert.ExpectNoErr(t, tw.WriteHeader(hdr))
+		if hdr.Size > 0 {
+			_, err := tw.Write([]byte("x"))
+			assert.ExpectNoErr(t, err)
+		}
+	}
+	assert.ExpectNoErr(t, tw.Close())
+	if gz != nil {
+		assert.ExpectNoErr(t, gz.Close())
+	}
+	return buf.Bytes()
+}
+
+func TestArchiveRoundTrip(t *testing.T) {
+	names, files := testArchiveFiles()
+	for _, format := range archiveFormats {
+		buf := &bytes.Buffer{}
+		assert.ExpectNoErr(t, WriteArchive(buf, format, names, files))
+		out, err := ReadArchive(bytes.NewReader(buf.Bytes()), format)
+		assert.ExpectNoErr(t, err)
+		assert.ExpectEQ(t, files, out)
+
+		// in order
+		var walked []string
+		assert.ExpectNoErr(t, WalkArchive(bytes.NewReader(buf.Bytes()), format, func(e ArchiveEntry, body io.Reader) error {
+			walked = append(walked, e.Name)
+			assert.ExpectEQ(t, int64(len(files[e.Name])), e.Size)
+			assert.ExpectEQ(t, os.FileMode(0644), e.Mode)
+			return nil
+		}))
+		assert.ExpectEQ(t, names, walked)
+	}
+	_, err := ReadArchive(bytes.NewReader(nil), "rar")
+	assert.ExpectTrue(t, errors.Is(err, ErrUnknownArchive))
+	assert.ExpectTrue(t, errors.Is(WriteArchive(io.Discard, "rar", nil, nil), ErrUnknownArchive))
+}
+
+func TestArchiveFormat(t *testing.T) {
+	for name, format := range map[string]string{
+		"a.tar":    ArchiveTar,
+		"a.tar.gz": ArchiveTarGz,
+		"A.TGZ":    ArchiveTarGz,
+		"a.zip":    ArchiveZip,
+	} {
+		f, err := ArchiveFormat(name)
+		assert.ExpectNoErr(t, err)
+		assert.ExpectEQ(t, format, f)
+	}
+	_, err := ArchiveFormat("a.gz")
+	assert.ExpectTrue(t, errors.Is(err, ErrUnknownArchive))
+}
+
+func TestArchiveUnsafePath(t *testing.T) {
+	unsafe := []string{
+		"../evil",
+		"a/../../evil",
+		"..",
+		"/etc/passwd",
+		"\\evil",
+		"C:evil",
+		"C:\\Windows\\evil",
+		"c:/evil",
+		"a\\..\\..\\evil",
+	}
+	for _, name := range unsafe {
+		err := WriteArchive(io.Discard, ArchiveTar, []string{name}, map[string][]byte{name: nil})
+		if !errors.Is(err, ErrUnsafePath) {
+			t.Fatalf("write %q: expected %v, got %v", name, ErrUnsafePath, err)
+		}
+		for _, format := range archiveFormats {
+			data := rawArchive(t, format, &tar.Header{Name: "ok.txt"}, &tar.Header{Name: name})
+			_, err := ReadArchive(bytes.NewReader(data), format)
+			if !errors.Is(err, ErrUnsafePath) {
+				t.Fatalf("read %q in %s: expected %v, got %v", name, format, ErrUnsafePath, err)
+			}
+			dest := t.TempDir()
+			err = Extract(bytes.NewReader(data), format, filepath.Join(dest, "out"))
+			if !errors.Is(err, ErrUnsafePath) {
+				t.Fatalf("extract %q in %s: expected %v, got %v", name, format, ErrUnsafePath, err)
+			}
+			// nothing escapes
+			entries, err := os.ReadDir(dest)
+			assert.ExpectNoErr(t, err)
+			assert.ExpectEQ(t, 1, len(entries))
+		}
+	}
+
+	// the names are cleaned
+	for _, format := range archiveFormats {
+		data := rawArchive(t, format, &tar.Header{Name: "./a//b/../c.txt"}, &tar.Header{Name: "d\\e.txt"})
+		out, err := ReadArchive(bytes.NewReader(data), format)
+		assert.ExpectNoErr(t, err)
+		assert.ExpectEQ(t, map[string][]byte{"a/c.txt": []byte("x"), "d/e.txt": []byte("x")}, out)
+	}
+}
+
+func TestArchiveSymlink(t *testing.T) {
+	for _, format := range archiveFormats {
+		data := rawArchive(t, format,
+			&tar.Header{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "/etc/passwd"},
+			&tar.Header{Name: "a.txt"},
+			&tar.Header{Name: "hard", Typeflag: tar.TypeLink, Linkname: "a.txt"},
+		)
+		out, err := ReadArchive(bytes.NewReader(data), format)
+		assert.ExpectNoErr(t, err)
+		assert.ExpectEQ(t, map[string][]byte{"a.txt": []byte("x")}, out)
+
+		dest := t.TempDir()
+		assert.ExpectNoErr(t, Extract(bytes.NewReader(data), format, dest))
+		_, err = os.Lstat(filepath.Join(dest, "link"))
+		assert.ExpectTrue(t, os.IsNotExist(err))
+	}
+
+	// the symlinks on the disk are skipped as well
+	root := t.TempDir()
+	assert.ExpectNoErr(t, os.WriteFile(filepath.Join(root, "a.txt"), []byte("a"), 0644))
+	assert.ExpectNoErr(t, os.Symlink(filepath.Join(root, "a.txt"), filepath.Join(root, "link")))
+	buf := &bytes.Buffer{}
+	a, err := NewArchiveWriter(buf, ArchiveTar)
+	assert.ExpectNoErr(t, err)
+	assert.ExpectNoErr(t, a.AddDir(root, "root"))
+	assert.ExpectNoErr(t, a.Close())
+	out, err := ReadArchive(buf, ArchiveTar)
+	assert.ExpectNoErr(t, err)
+	assert.ExpectEQ(t, map[string][]byte{"root/a.txt": []byte("a")}, out)
+}
+
+func TestArchiveZipStream(t *testing.T) {
+	names, files := testArchiveFiles()
+	buf := &bytes.Buffer{}
+	assert.ExpectNoErr(t, WriteArchive(buf, ArchiveZip, names, files))
+
+	// hides the io.ReaderAt, so it is spooled to a temp file
+	r := struct{ io.Reader }{bytes.NewReader(buf.Bytes())}
+	out, err := ReadArchive(r, ArchiveZip)
+	assert.ExpectNoErr(t, err)
+	assert.ExpectEQ(t, files, out)
+
+	// from a file
+	file := filepath.Join(t.TempDir(), "a.zip")
+	assert.ExpectNoErr(t, os.WriteFile(file, buf.Bytes(), 0644))
+	dest := t.TempDir()
+	assert.ExpectNoErr(t, ExtractFile(file, dest))
+	for _, name := range names {
+		data, err := os.ReadFile(filepath.Join(dest, filepath.FromSlash(name)))
+		assert.ExpectNoErr(t, err)
+		assert.ExpectTrue(t, bytes.Equal(files[name], data))
+	}
+}
+
+func TestArchiveExtractLimited(t *testing.T) {
+	for _, format := range archiveFormats {
+		// 4 files of 1MiB zeros
+		names := []string{"a", "b", "c", "d"}
+		files := map[string][]byte{}
+		for _, name := range names {
+			files[name] = make([]byte, 1<<20)
+		}
+		buf := &bytes.Buffer{}
+		assert.ExpectNoErr(t, WriteArchive(buf, format, names, files))
+
+		dest := t.TempDir()
+		assert.ExpectNoErr(t, ExtractLimited(bytes.NewReader(buf.Bytes()), format, dest, Limits{MaxOutputSize: 5 << 20}))
+
+		// the total of the entries
+		err := ExtractLimited(bytes.NewReader(buf.Bytes()), format, t.TempDir(), Limits{MaxOutputSize: 3 << 20})
+		assert.ExpectTrue(t, errors.Is(err, ErrLimitExceeded), format)
+		var le *LimitError
+		assert.ExpectTrue(t, errors.As(err, &le))
+		assert.ExpectEQ(t, LimitOutputSize, le.Limit)
+
+		if format != ArchiveTar {
+			err = ExtractLimited(bytes.NewReader(buf.Bytes()), format, t.TempDir(), DefaultLimits)
+			assert.ExpectTrue(t, errors.Is(err, ErrLimitExceeded), format)
+			assert.ExpectTrue(t, errors.As(err, &le))
+			assert.ExpectEQ(t, LimitRatio, le.Limit)
+		}
+
+		file := filepath.Join(t.TempDir(), "a."+format)
+		assert.ExpectNoErr(t, os.WriteFile(file, buf.Bytes(), 0644))
+		assert.ExpectTrue(t, errors.Is(ExtractFileLimited(file, t.TempDir(), Limits{MaxOutputSize: 1 << 20}), ErrLimitExceeded))
+	}
+}
+
+func TestArchiveExtractModTime(t *testing.T) {
+	modTime := time.Date(2020, 1, 2, 3, 4, 6, 0, time.UTC)
+	for _, format := range archiveFormats {
+		buf := &bytes.Buffer{}
+		a, err := NewArchiveWriter(buf, format)
+		assert.ExpectNoErr(t, err)
+		assert.ExpectNoErr(t, a.Add(ArchiveEntry{Name: "a.txt", Size: 1, ModTime: modTime}, bytes.NewReader([]byte("a"))))
+		assert.ExpectNoErr(t, a.Close())
+		dest := t.TempDir()
+		assert.ExpectNoErr(t, Extract(buf, format, dest))
+		fi, err := os.Stat(filepath.Join(dest, "a.txt"))
+		assert.ExpectNoErr(t, err)
+		assert.ExpectTrue(t, modTime.Equal(fi.ModTime()), format)
+	}
+
+	// the undated zip entries keep the time of the extraction
+	start := time.Now().Add(-time.Second)
+	dest := t.TempDir()
+	assert.ExpectNoErr(t, Extract(bytes.NewReader(rawArchive(t, ArchiveZip, &tar.Header{Name: "a.txt"})), ArchiveZip, dest))
+	fi, err := os.Stat(filepath.Join(dest, "a.txt"))
+	assert.ExpectNoErr(t, err)
+	assert.ExpectTrue(t, fi.ModTime().After(start))
+}
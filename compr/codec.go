@@ -0,0 +1,173 @@
+package compr
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+const (
+	NoCompression      = flate.NoCompression
+	BestSpeed          = flate.BestSpeed
+	BestCompression    = flate.BestCompression
+	DefaultCompression = flate.DefaultCompression
+)
+
+// detectSize is the max size of the header to detect a codec
+const detectSize = 16
+
+var (
+	ErrUnknownCodec = errors.New("unknown_codec")
+	ErrCorrupt      = errors.New("corrupt_input")
+)
+
+/**
+ * Codec is a streaming compression format
+ *
+ * The level is in [BestSpeed, BestCompression], or NoCompression and
+ * DefaultCompression, the codecs may ignore it.
+ */
+type Codec interface {
+	// Name is the unique name in the registry, e.g. gzip
+	Name() string
+	// Match returns true if the header (at most 16 bytes) is in the format,
+	// it always returns false for the formats without magic bytes
+	Match(header []byte) bool
+	NewWriter(w io.Writer, level int) (io.WriteCloser, error)
+	NewReader(r io.Reader) (io.ReadCloser, error)
+}
+
+var (
+	codecsMx sync.RWMutex
+	codecs   []Codec // in the registration order
+)
+
+// Register adds a codec to the registry, or replaces the one with the
+// same name
+func Register(c Codec) {
+	codecsMx.Lock()
+	defer codecsMx.Unlock()
+	for i, e := range codecs {
+		if e.Name() == c.Name() {
+			codecs[i] = c
+			return
+		}
+	}
+	codecs = append(codecs, c)
+}
+
+// GetCodec returns the codec by name
+func GetCodec(name string) (Codec, error) {
+	codecsMx.RLock()
+	defer codecsMx.RUnlock()
+	for _, c := range codecs {
+		if c.Name() == name {
+			return c, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnknownCodec, name)
+}
+
+// Codecs returns the names of the registered codecs
+func Codecs() []string {
+	codecsMx.RLock()
+	defer codecsMx.RUnlock()
+	names := make([]string, len(codecs))
+	for i, c := range codecs {
+		names[i] = c.Name()
+	}
+	return names
+}
+
+// Detect returns the codec matching the header, the first registered wins
+func Detect(header []byte) (Codec, error) {
+	codecsMx.RLock()
+	defer codecsMx.RUnlock()
+	for _, c := range codecs {
+		if c.Match(header) {
+			return c, nil
+		}
+	}
+	return nil, ErrUnknownCodec
+}
+
+// NewWriter returns a compressing writer of the codec
+// The writer must be closed to flush the data.
+func NewWriter(w io.Writer, name string, level int) (io.WriteCloser, error) {
+	c, err := GetCodec(name)
+	if err != nil {
+		return nil, err
+	}
+	return c.NewWriter(w, level)
+}
+
+// NewReader returns a decompressing reader of the codec
+func NewReader(r io.Reader, name string) (io.ReadCloser, error) {
+	c, err := GetCodec(name)
+	if err != nil {
+		return nil, err
+	}
+	return c.NewReader(r)
+}
+
+// NewDetectReader returns a decompressing reader, the codec is detected
+// from the magic bytes
+func NewDetectReader(r io.Reader) (io.ReadCloser, Codec, error) {
+	br := bufio.NewReader(r)
+	header, err := br.Peek(detectSize)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return nil, nil, err
+	}
+	c, err := Detect(header)
+	if err != nil {
+		return nil, nil, err
+	}
+	rc, err := c.NewReader(br)
+	if err != nil {
+		return nil, nil, err
+	}
+	return rc, c, nil
+}
+
+// Compress compresses the whole buffer with the codec
+func Compress(name string, in []byte, level int) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	w, err := NewWriter(buf, name, level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = w.Write(in); err != nil {
+		w.Close()
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decompress decompresses the whole buffer, the codec is detected
+// from the magic bytes
+func Decompress(in []byte) ([]byte, error) {
+	r, _, err := NewDetectReader(bytes.NewReader(in))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// DecompressWith decompresses the whole buffer with the codec, e.g. for
+// the formats without magic bytes
+func DecompressWith(name string, in []byte) ([]byte, error) {
+	r, err := NewReader(bytes.NewReader(in), name)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
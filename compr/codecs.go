@@ -0,0 +1,82 @@
+package compr
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+)
+
+const (
+	CodecGzip    = "gzip"
+	CodecZlib    = "zlib"
+	CodecDeflate = "deflate"
+	CodecSLZ     = "slz"
+)
+
+func init() {
+	Register(GzipCodec{})
+	Register(ZlibCodec{})
+	Register(DeflateCodec{})
+	Register(SLZCodec{})
+}
+
+// GzipCodec is the gzip format (RFC 1952)
+type GzipCodec struct{}
+
+func (GzipCodec) Name() string {
+	return CodecGzip
+}
+
+func (GzipCodec) Match(header []byte) bool {
+	return len(header) >= 2 && header[0] == 0x1f && header[1] == 0x8b
+}
+
+func (GzipCodec) NewWriter(w io.Writer, level int) (io.WriteCloser, error) {
+	return gzip.NewWriterLevel(w, level)
+}
+
+func (GzipCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+// ZlibCodec is the zlib format (RFC 1950)
+type ZlibCodec struct{}
+
+func (ZlibCodec) Name() string {
+	return CodecZlib
+}
+
+// Match checks the compression method (deflate) and the check bits
+func (ZlibCodec) Match(header []byte) bool {
+	return len(header) >= 2 && header[0]&0x0f == 8 && header[0]>>4 <= 7 &&
+		(uint16(header[0])<<8|uint16(header[1]))%31 == 0
+}
+
+func (ZlibCodec) NewWriter(w io.Writer, level int) (io.WriteCloser, error) {
+	return zlib.NewWriterLevel(w, level)
+}
+
+func (ZlibCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return zlib.NewReader(r)
+}
+
+// DeflateCodec is the raw deflate format (RFC 1951), which has no magic
+// bytes, so it is never detected
+type DeflateCodec struct{}
+
+func (DeflateCodec) Name() string {
+	return CodecDeflate
+}
+
+func (DeflateCodec) Match(header []byte) bool {
+	return false
+}
+
+func (DeflateCodec) NewWriter(w io.Writer, level int) (io.WriteCloser, error) {
+	return flate.NewWriter(w, level)
+}
+
+func (DeflateCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return flate.NewReader(r), nil
+}
@@ -6,6 +6,8 @@ import (
 	"io"
 )
 
+// GzipDecompress decompresses the whole buffer without any limit
+// Use GzipDecompressLimited for the untrusted input.
 func GzipDecompress(in []byte) (out []byte, err error) {
 	gr, err := gzip.NewReader(bytes.NewReader(in))
 	if err != nil {
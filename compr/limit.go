@@ -0,0 +1,146 @@
+package compr
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrLimitExceeded is matched by errors.Is for all *LimitError
+var ErrLimitExceeded = errors.New("decompression_limit_exceeded")
+
+const (
+	LimitOutputSize = "output_size"
+	LimitRatio      = "ratio"
+)
+
+/**
+ * Limits guards the decompression of the untrusted input, e.g. a small
+ * gzip bomb expanding to gigabytes
+ *
+ * The ratio is the output size over the compressed input consumed, it is
+ * checked only after RatioMinOutput bytes, since the small payloads may
+ * have high ratios legally.
+ */
+type Limits struct {
+	MaxOutputSize  int64   // 0 for unlimited
+	MaxRatio       float64 // 0 for unlimited
+	RatioMinOutput int64
+}
+
+// DefaultLimits is a reasonable guard for the web services
+var DefaultLimits = Limits{
+	MaxOutputSize:  256 << 20,
+	MaxRatio:       200,
+	RatioMinOutput: 1 << 20,
+}
+
+// LimitError is returned once a limit is exceeded
+type LimitError struct {
+	Limit  string // LimitOutputSize or LimitRatio
+	Output int64  // decompressed bytes
+	Input  int64  // compressed bytes consumed
+	Max    float64
+}
+
+func (e *LimitError) Error() string {
+	if e.Limit == LimitRatio {
+		return fmt.Sprintf("%v: ratio %.1f (%d/%d) exceeds %v",
+			ErrLimitExceeded, float64(e.Output)/float64(e.Input), e.Output, e.Input, e.Max)
+	}
+	return fmt.Sprintf("%v: output exceeds %v bytes", ErrLimitExceeded, int64(e.Max))
+}
+
+func (e *LimitError) Is(target error) bool {
+	return target == ErrLimitExceeded
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+type limitedReader struct {
+	rc     io.ReadCloser // the decompressor
+	in     *countingReader
+	limits Limits
+	out    int64
+	err    error
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.err != nil {
+		return 0, l.err
+	}
+	max := l.limits.MaxOutputSize
+	if max > 0 && int64(len(p)) > max-l.out+1 {
+		// one more byte to know whether it is exceeded
+		p = p[:max-l.out+1]
+	}
+	n, err := l.rc.Read(p)
+	l.out += int64(n)
+	if max > 0 && l.out > max {
+		n -= int(l.out - max)
+		l.out = max
+		l.err = &LimitError{Limit: LimitOutputSize, Output: l.out, Input: l.in.n, Max: float64(max)}
+		return n, l.err
+	}
+	if r := l.limits.MaxRatio; r > 0 && l.out > l.limits.RatioMinOutput && l.in.n > 0 &&
+		float64(l.out)/float64(l.in.n) > r {
+		l.err = &LimitError{Limit: LimitRatio, Output: l.out, Input: l.in.n, Max: r}
+		return n, l.err
+	}
+	return n, err
+}
+
+func (l *limitedReader) Close() error {
+	return l.rc.Close()
+}
+
+// NewLimitedReader returns a decompressing reader of the codec, a
+// *LimitError is returned by Read once a limit is exceeded
+func NewLimitedReader(r io.Reader, name string, limits Limits) (io.ReadCloser, error) {
+	in := &countingReader{r: r}
+	rc, err := NewReader(in, name)
+	if err != nil {
+		return nil, err
+	}
+	return &limitedReader{rc: rc, in: in, limits: limits}, nil
+}
+
+// NewLimitedDetectReader is NewDetectReader with the limits
+func NewLimitedDetectReader(r io.Reader, limits Limits) (io.ReadCloser, Codec, error) {
+	in := &countingReader{r: r}
+	rc, c, err := NewDetectReader(in)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &limitedReader{rc: rc, in: in, limits: limits}, c, nil
+}
+
+// DecompressLimited is Decompress with the limits
+func DecompressLimited(in []byte, limits Limits) ([]byte, error) {
+	r, _, err := NewLimitedDetectReader(bytes.NewReader(in), limits)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// GzipDecompressLimited is GzipDecompress with the limits
+func GzipDecompressLimited(in []byte, limits Limits) ([]byte, error) {
+	r, err := NewLimitedReader(bytes.NewReader(in), CodecGzip, limits)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
@@ -0,0 +1,129 @@
+package compr
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"testing"
+
+	"github.com/argcv/stork/assert"
+)
+
+// gzipBomb returns the gzip of size zero bytes
+func gzipBomb(t *testing.T, size int) []byte {
+	buf := &bytes.Buffer{}
+	w, err := gzip.NewWriterLevel(buf, BestCompression)
+	assert.ExpectNoErr(t, err)
+	zeros := make([]byte, 1<<20)
+	for size > 0 {
+		k := len(zeros)
+		if k > size {
+			k = size
+		}
+		_, err = w.Write(zeros[:k])
+		assert.ExpectNoErr(t, err)
+		size -= k
+	}
+	assert.ExpectNoErr(t, w.Close())
+	return buf.Bytes()
+}
+
+func expectLimitError(t *testing.T, err error, limit string) *LimitError {
+	t.Helper()
+	if !errors.Is(err, ErrLimitExceeded) {
+		t.Fatalf("expected %v, got %v", ErrLimitExceeded, err)
+	}
+	var le *LimitError
+	if !errors.As(err, &le) {
+		t.Fatalf("expected a *LimitError, got %T", err)
+	}
+	assert.ExpectEQ(t, limit, le.Limit)
+	return le
+}
+
+func TestLimitsOutputSize(t *testing.T) {
+	const max = 100000
+	limits := Limits{MaxOutputSize: max}
+	for _, name := range []string{CodecGzip, CodecSLZ} {
+		in := benchPayload(max)
+		comp, err := Compress(name, in, DefaultCompression)
+		assert.ExpectNoErr(t, err)
+		out, err := DecompressLimited(comp, limits)
+		assert.ExpectNoErr(t, err)
+		assert.ExpectTrue(t, bytes.Equal(in, out))
+
+		in = benchPayload(max + 1)
+		comp, err = Compress(name, in, DefaultCompression)
+		assert.ExpectNoErr(t, err)
+		out, err = DecompressLimited(comp, limits)
+		le := expectLimitError(t, err, LimitOutputSize)
+		assert.ExpectEQ(t, int64(max), le.Output)
+		assert.ExpectEQ(t, float64(max), le.Max)
+		// never more than the limit
+		assert.ExpectEQ(t, max, len(out))
+		assert.ExpectTrue(t, bytes.Equal(in[:max], out))
+	}
+
+	// unlimited
+	in := benchPayload(max + 1)
+	comp, err := GzipCompress(in)
+	assert.ExpectNoErr(t, err)
+	out, err := GzipDecompressLimited(comp, Limits{})
+	assert.ExpectNoErr(t, err)
+	assert.ExpectTrue(t, bytes.Equal(in, out))
+}
+
+func TestLimitsRatio(t *testing.T) {
+	limits := Limits{MaxRatio: 10, RatioMinOutput: 1 << 20}
+
+	// zeros of 1MiB are far over the ratio, but not checked yet
+	comp := gzipBomb(t, 1<<20)
+	out, err := GzipDecompressLimited(comp, limits)
+	assert.ExpectNoErr(t, err)
+	assert.ExpectEQ(t, 1<<20, len(out))
+
+	comp = gzipBomb(t, 2<<20)
+	_, err = GzipDecompressLimited(comp, limits)
+	le := expectLimitError(t, err, LimitRatio)
+	assert.ExpectTrue(t, le.Output > limits.RatioMinOutput)
+	assert.ExpectTrue(t, float64(le.Output)/float64(le.Input) > limits.MaxRatio)
+	assert.ExpectEQ(t, limits.MaxRatio, le.Max)
+
+	// the incompressible payload is in the ratio
+	in := randomBytes(2 << 20)
+	comp, err = GzipCompress(in)
+	assert.ExpectNoErr(t, err)
+	out, err = GzipDecompressLimited(comp, limits)
+	assert.ExpectNoErr(t, err)
+	assert.ExpectTrue(t, bytes.Equal(in, out))
+}
+
+func TestLimitsGzipBomb(t *testing.T) {
+	// 512MiB of zeros in about 512KiB, over DefaultLimits.MaxOutputSize
+	const size = 512 << 20
+	comp := gzipBomb(t, size)
+	assert.ExpectLT(t, len(comp), size/500)
+
+	out, err := GzipDecompressLimited(comp, DefaultLimits)
+	expectLimitError(t, err, LimitRatio)
+	assert.ExpectLT(t, int64(len(out)), DefaultLimits.MaxOutputSize)
+
+	out, err = DecompressLimited(comp, DefaultLimits)
+	expectLimitError(t, err, LimitRatio)
+	assert.ExpectLT(t, int64(len(out)), DefaultLimits.MaxOutputSize)
+
+	// the output size only
+	limits := Limits{MaxOutputSize: 16 << 20}
+	r, c, err := NewLimitedDetectReader(bytes.NewReader(comp), limits)
+	assert.ExpectNoErr(t, err)
+	assert.ExpectEQ(t, CodecGzip, c.Name())
+	n, err := bytes.NewBuffer(nil).ReadFrom(r)
+	le := expectLimitError(t, err, LimitOutputSize)
+	assert.ExpectEQ(t, limits.MaxOutputSize, n)
+	assert.ExpectEQ(t, limits.MaxOutputSize, le.Output)
+	assert.ExpectTrue(t, le.Input < int64(len(comp)))
+	// the error sticks
+	_, err = r.Read(make([]byte, 10))
+	expectLimitError(t, err, LimitOutputSize)
+	assert.ExpectNoErr(t, r.Close())
+}
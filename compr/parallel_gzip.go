@@ -0,0 +1,221 @@
+package compr
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"runtime"
+	"sync"
+)
+
+const defaultParallelBlockSize = 1 << 20
+
+type gzipBlock struct {
+	done chan struct{}
+	out  *bytes.Buffer
+	err  error
+}
+
+/**
+ * ParallelGzipWriter compresses the independent blocks on multiple
+ * goroutines, each block is a gzip member, and the output is a valid
+ * multi-member gzip stream (RFC 1952), which is read by any gzip reader.
+ *
+ * The ratio is slightly lower than GzipCompress, since the blocks share no
+ * history. At most workers blocks are in flight, the order is preserved.
+ *
+ * The setters must be called before the first write, and the writer must
+ * be closed to flush the data.
+ */
+type ParallelGzipWriter struct {
+	w         io.Writer
+	level     int
+	blockSize int
+	workers   int
+
+	buf     []byte
+	pending []*gzipBlock
+	wrote   bool
+	closed  bool
+	err     error
+
+	gzPool  sync.Pool
+	bufPool sync.Pool
+}
+
+// NewParallelGzipWriter returns a writer with 1MiB blocks and GOMAXPROCS
+// workers
+func NewParallelGzipWriter(w io.Writer, level int) (*ParallelGzipWriter, error) {
+	// check the level once
+	if _, err := gzip.NewWriterLevel(io.Discard, level); err != nil {
+		return nil, err
+	}
+	return &ParallelGzipWriter{
+		w:         w,
+		level:     level,
+		blockSize: defaultParallelBlockSize,
+		workers:   runtime.GOMAXPROCS(0),
+	}, nil
+}
+
+// SetBlockSize sets the raw size of a block, it is ignored if n <= 0
+func (z *ParallelGzipWriter) SetBlockSize(n int) *ParallelGzipWriter {
+	if n > 0 {
+		z.blockSize = n
+	}
+	return z
+}
+
+// SetWorkers sets the max number of the blocks in flight, it is ignored
+// if n <= 0
+func (z *ParallelGzipWriter) SetWorkers(n int) *ParallelGzipWriter {
+	if n > 0 {
+		z.workers = n
+	}
+	return z
+}
+
+func (z *ParallelGzipWriter) Write(p []byte) (n int, err error) {
+	if z.err != nil {
+		return 0, z.err
+	}
+	if z.closed {
+		return 0, errors.New("gzip: write after close")
+	}
+	if z.buf == nil {
+		z.buf = make([]byte, 0, z.blockSize)
+	}
+	for len(p) > 0 {
+		k := copy(z.buf[len(z.buf):cap(z.buf)], p)
+		z.buf = z.buf[:len(z.buf)+k]
+		p = p[k:]
+		n += k
+		if len(z.buf) == cap(z.buf) {
+			if err = z.submit(); err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// submit compresses the pending data in the background, and waits for the
+// oldest block if there are too many in flight
+func (z *ParallelGzipWriter) submit() error {
+	for len(z.pending) >= z.workers {
+		if err := z.writeOldest(); err != nil {
+			return err
+		}
+	}
+	raw := z.buf
+	z.buf = make([]byte, 0, z.blockSize)
+	b := &gzipBlock{done: make(chan struct{})}
+	z.pending = append(z.pending, b)
+	z.wrote = true
+	go func() {
+		defer close(b.done)
+		b.out, b.err = z.compress(raw)
+	}()
+	return nil
+}
+
+func (z *ParallelGzipWriter) compress(raw []byte) (*bytes.Buffer, error) {
+	out, _ := z.bufPool.Get().(*bytes.Buffer)
+	if out == nil {
+		out = &bytes.Buffer{}
+	}
+	out.Reset()
+	gw, _ := z.gzPool.Get().(*gzip.Writer)
+	if gw == nil {
+		gw, _ = gzip.NewWriterLevel(out, z.level)
+	} else {
+		gw.Reset(out)
+	}
+	defer z.gzPool.Put(gw)
+	if _, err := gw.Write(raw); err != nil {
+		return nil, err
+	}
+	if err := gw.Close(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (z *ParallelGzipWriter) writeOldest() error {
+	b := z.pending[0]
+	<-b.done
+	z.pending[0] = nil
+	z.pending = z.pending[1:]
+	if b.err != nil {
+		z.err = b.err
+		return z.err
+	}
+	_, err := z.w.Write(b.out.Bytes())
+	z.bufPool.Put(b.out)
+	if err != nil {
+		z.err = err
+	}
+	return z.err
+}
+
+// Flush compresses the pending data as a block, and writes all the blocks
+// in flight
+func (z *ParallelGzipWriter) Flush() error {
+	if z.err != nil {
+		return z.err
+	}
+	if len(z.buf) > 0 {
+		if err := z.submit(); err != nil {
+			return err
+		}
+	}
+	for len(z.pending) > 0 {
+		if err := z.writeOldest(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close flushes the data, an empty member is written if nothing is
+// written, the underlying writer is not closed
+func (z *ParallelGzipWriter) Close() error {
+	if z.closed {
+		return z.err
+	}
+	err := z.Flush()
+	z.closed = true
+	if err != nil {
+		// wait for the blocks in flight
+		for _, b := range z.pending {
+			<-b.done
+		}
+		z.pending = nil
+		return err
+	}
+	if !z.wrote {
+		gw, _ := gzip.NewWriterLevel(z.w, z.level)
+		if err = gw.Close(); err != nil {
+			z.err = err
+		}
+	}
+	return z.err
+}
+
+// ParallelGzipCompress is GzipCompress with a ParallelGzipWriter
+func ParallelGzipCompress(in []byte) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	w, err := NewParallelGzipWriter(buf, DefaultCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = w.Write(in); err != nil {
+		w.Close()
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,74 @@
+package compr
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/argcv/stork/assert"
+)
+
+// benchPayload looks like a dump of the documents
+func benchPayload(size int) []byte {
+	rnd := rand.New(rand.NewSource(42))
+	buf := &bytes.Buffer{}
+	for buf.Len() < size {
+		fmt.Fprintf(buf, `{"_id":%d,"name":"user-%d","score":%.3f,"tags":["t%d","t%d"]}`+"\n",
+			rnd.Int63(), rnd.Intn(100000), rnd.Float64()*100, rnd.Intn(50), rnd.Intn(50))
+	}
+	return buf.Bytes()[:size]
+}
+
+func TestParallelGzipWriter(t *testing.T) {
+	for _, size := range []int{0, 1, 1000, 64 << 10, 300000} {
+		in := benchPayload(size)
+		buf := &bytes.Buffer{}
+		w, err := NewParallelGzipWriter(buf, BestSpeed)
+		assert.ExpectNoErr(t, err)
+		w.SetBlockSize(64 << 10).SetWorkers(3)
+		// small writes cross the block boundaries
+		for p := in; len(p) > 0; {
+			k := 7777
+			if k > len(p) {
+				k = len(p)
+			}
+			_, err = w.Write(p[:k])
+			assert.ExpectNoErr(t, err)
+			p = p[k:]
+		}
+		assert.ExpectNoErr(t, w.Close())
+		out, err := GzipDecompress(buf.Bytes())
+		assert.ExpectNoErr(t, err)
+		assert.ExpectTrue(t, bytes.Equal(in, out))
+	}
+	_, err := NewParallelGzipWriter(&bytes.Buffer{}, 42)
+	assert.ExpectErr(t, err)
+}
+
+func benchmarkCompress(b *testing.B, size int, fn func([]byte) ([]byte, error)) {
+	in := benchPayload(size)
+	b.SetBytes(int64(size))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := fn(in); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkGzipCompress(b *testing.B) {
+	benchmarkCompress(b, 32<<20, GzipCompress)
+}
+
+func BenchmarkParallelGzipCompress(b *testing.B) {
+	benchmarkCompress(b, 32<<20, ParallelGzipCompress)
+}
+
+func BenchmarkGzipCompressSmall(b *testing.B) {
+	benchmarkCompress(b, 256<<10, GzipCompress)
+}
+
+func BenchmarkParallelGzipCompressSmall(b *testing.B) {
+	benchmarkCompress(b, 256<<10, ParallelGzipCompress)
+}
@@ -0,0 +1,435 @@
+package compr
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+const (
+	slzMagic        = "SLZ1"
+	slzBlockSize    = 64 << 10
+	slzMinMatch     = 4
+	slzMaxOffset    = 1<<16 - 1
+	slzHashLog      = 14
+	slzBlockLZ      = 0x00
+	slzBlockStored  = 0x01
+	slzBlockEnd     = 0xff
+	slzMaxCompBlock = slzBlockSize + slzBlockSize/255 + 16
+)
+
+var slzTable = crc32.MakeTable(crc32.Castagnoli)
+
+/**
+ * SLZCodec is a pure go LZ77 block codec in the spirit of LZ4 and snappy,
+ * which is much faster than deflate, with a lower compression ratio.
+ *
+ * Stream: "SLZ1", then the blocks of at most 64KiB raw data:
+ *
+ *   type (1 byte): 0 compressed, 1 stored, 0xff the end of the stream
+ *   raw length (uvarint)
+ *   data length (uvarint, compressed only)
+ *   data
+ *   crc32-c of the raw data (4 bytes, little endian)
+ *
+ * A compressed block is a sequence of (literals, match), each starts with
+ * a token byte: the literal length in the high 4 bits and the match length
+ * minus 4 in the low 4 bits, 15 is followed by the extra bytes (255 for
+ * more). Then the literals, and the offset of the match (2 bytes, little
+ * endian). The last sequence has no match.
+ *
+ * The level is ignored except NoCompression, which stores the blocks.
+ */
+type SLZCodec struct{}
+
+func (SLZCodec) Name() string {
+	return CodecSLZ
+}
+
+func (SLZCodec) Match(header []byte) bool {
+	return bytes.HasPrefix(header, []byte(slzMagic))
+}
+
+func (SLZCodec) NewWriter(w io.Writer, level int) (io.WriteCloser, error) {
+	if level < DefaultCompression || level > BestCompression {
+		return nil, fmt.Errorf("slz: invalid compression level: %d", level)
+	}
+	return &slzWriter{
+		w:      w,
+		stored: level == NoCompression,
+		buf:    make([]byte, 0, slzBlockSize),
+	}, nil
+}
+
+func (SLZCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(slzMagic))
+	if _, err := io.ReadFull(br, magic); err != nil {
+		return nil, slzTruncated(err)
+	}
+	if string(magic) != slzMagic {
+		return nil, fmt.Errorf("%w: bad slz magic", ErrCorrupt)
+	}
+	return &slzReader{r: br}, nil
+}
+
+type slzWriter struct {
+	w        io.Writer
+	stored   bool
+	buf      []byte // pending raw data
+	out      []byte // reused output buffer
+	wroteHdr bool
+	closed   bool
+	err      error
+}
+
+func (z *slzWriter) Write(p []byte) (n int, err error) {
+	if z.err != nil {
+		return 0, z.err
+	}
+	if z.closed {
+		return 0, errors.New("slz: write after close")
+	}
+	for len(p) > 0 {
+		k := copy(z.buf[len(z.buf):cap(z.buf)], p)
+		z.buf = z.buf[:len(z.buf)+k]
+		p = p[k:]
+		n += k
+		if len(z.buf) == cap(z.buf) {
+			if err = z.writeBlock(); err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// Flush writes the pending data as a block
+func (z *slzWriter) Flush() error {
+	if z.err != nil {
+		return z.err
+	}
+	if len(z.buf) > 0 {
+		return z.writeBlock()
+	}
+	return nil
+}
+
+func (z *slzWriter) Close() error {
+	if z.closed {
+		return z.err
+	}
+	z.closed = true
+	if err := z.Flush(); err != nil {
+		return err
+	}
+	if err := z.writeHeader(); err != nil {
+		return err
+	}
+	if _, err := z.w.Write([]byte{slzBlockEnd}); err != nil {
+		z.err = err
+	}
+	return z.err
+}
+
+func (z *slzWriter) writeHeader() error {
+	if z.wroteHdr {
+		return nil
+	}
+	z.wroteHdr = true
+	if _, err := io.WriteString(z.w, slzMagic); err != nil {
+		z.err = err
+	}
+	return z.err
+}
+
+func (z *slzWriter) writeBlock() error {
+	if err := z.writeHeader(); err != nil {
+		return err
+	}
+	raw := z.buf
+	var comp []byte
+	if !z.stored {
+		comp = slzEncodeBlock(z.out[:0], raw)
+		z.out = comp
+	}
+
+	hdr := make([]byte, 0, 1+2*binary.MaxVarintLen64)
+	var data []byte
+	if z.stored || len(comp) >= len(raw) {
+		hdr = append(hdr, slzBlockStored)
+		hdr = binary.AppendUvarint(hdr, uint64(len(raw)))
+		data = raw
+	} else {
+		hdr = append(hdr, slzBlockLZ)
+		hdr = binary.AppendUvarint(hdr, uint64(len(raw)))
+		hdr = binary.AppendUvarint(hdr, uint64(len(comp)))
+		data = comp
+	}
+	crc := binary.LittleEndian.AppendUint32(nil, crc32.Checksum(raw, slzTable))
+	for _, b := range [][]byte{hdr, data, crc} {
+		if _, err := z.w.Write(b); err != nil {
+			z.err = err
+			return err
+		}
+	}
+	z.buf = z.buf[:0]
+	return nil
+}
+
+func slzHash(v uint32) uint32 {
+	return (v * 2654435761) >> (32 - slzHashLog)
+}
+
+func slzAppendLen(dst []byte, n int) []byte {
+	for n >= 255 {
+		dst = append(dst, 255)
+		n -= 255
+	}
+	return append(dst, byte(n))
+}
+
+func slzAppendSequence(dst, literals []byte, offset, matchLen int) []byte {
+	token := byte(0)
+	litLen := len(literals)
+	if litLen >= 15 {
+		token = 15 << 4
+	} else {
+		token = byte(litLen) << 4
+	}
+	ml := matchLen - slzMinMatch
+	if matchLen > 0 {
+		if ml >= 15 {
+			token |= 15
+		} else {
+			token |= byte(ml)
+		}
+	}
+	dst = append(dst, token)
+	if litLen >= 15 {
+		dst = slzAppendLen(dst, litLen-15)
+	}
+	dst = append(dst, literals...)
+	if matchLen > 0 {
+		dst = append(dst, byte(offset), byte(offset>>8))
+		if ml >= 15 {
+			dst = slzAppendLen(dst, ml-15)
+		}
+	}
+	return dst
+}
+
+// slzEncodeBlock compresses src (at most slzBlockSize) and appends to dst
+func slzEncodeBlock(dst, src []byte) []byte {
+	var table [1 << slzHashLog]int32 // position + 1, 0 for empty
+	anchor := 0
+	for i := 0; i+slzMinMatch <= len(src); {
+		v := binary.LittleEndian.Uint32(src[i:])
+		h := slzHash(v)
+		cand := int(table[h]) - 1
+		table[h] = int32(i + 1)
+		if cand < 0 || i-cand > slzMaxOffset || binary.LittleEndian.Uint32(src[cand:]) != v {
+			i++
+			continue
+		}
+		m := slzMinMatch
+		for i+m < len(src) && src[cand+m] == src[i+m] {
+			m++
+		}
+		dst = slzAppendSequence(dst, src[anchor:i], i-cand, m)
+		i += m
+		anchor = i
+	}
+	if anchor < len(src) {
+		dst = slzAppendSequence(dst, src[anchor:], 0, 0)
+	}
+	return dst
+}
+
+// slzDecodeBlock decompresses src into dst, which has the raw length
+func slzDecodeBlock(dst, src []byte) error {
+	corrupt := func(reason string) error {
+		return fmt.Errorf("%w: slz %s", ErrCorrupt, reason)
+	}
+	readLen := func(n int, pos *int) (int, error) {
+		for {
+			if *pos >= len(src) {
+				return 0, corrupt("truncated length")
+			}
+			b := src[*pos]
+			*pos++
+			n += int(b)
+			if n > len(dst) {
+				return 0, corrupt("length out of range")
+			}
+			if b != 255 {
+				return n, nil
+			}
+		}
+	}
+	d, s := 0, 0
+	for d < len(dst) {
+		if s >= len(src) {
+			return corrupt("truncated block")
+		}
+		token := src[s]
+		s++
+		litLen := int(token >> 4)
+		if litLen == 15 {
+			var err error
+			if litLen, err = readLen(litLen, &s); err != nil {
+				return err
+			}
+		}
+		if litLen > len(dst)-d || litLen > len(src)-s {
+			return corrupt("literals out of range")
+		}
+		copy(dst[d:], src[s:s+litLen])
+		d += litLen
+		s += litLen
+		if d == len(dst) {
+			break
+		}
+		if s+2 > len(src) {
+			return corrupt("truncated offset")
+		}
+		offset := int(src[s]) | int(src[s+1])<<8
+		s += 2
+		matchLen := int(token & 0x0f)
+		if matchLen == 15 {
+			var err error
+			if matchLen, err = readLen(matchLen, &s); err != nil {
+				return err
+			}
+		}
+		matchLen += slzMinMatch
+		if offset == 0 || offset > d || matchLen > len(dst)-d {
+			return corrupt("match out of range")
+		}
+		// byte by byte, the match may overlap the output
+		for k := 0; k < matchLen; k++ {
+			dst[d+k] = dst[d-offset+k]
+		}
+		d += matchLen
+	}
+	if s != len(src) {
+		return corrupt("trailing data in block")
+	}
+	return nil
+}
+
+type slzReader struct {
+	r    *bufio.Reader
+	raw  []byte // decoded block
+	pos  int
+	comp []byte
+	err  error
+}
+
+func (z *slzReader) Read(p []byte) (int, error) {
+	for z.pos >= len(z.raw) {
+		if z.err != nil {
+			return 0, z.err
+		}
+		z.err = z.readBlock()
+	}
+	n := copy(p, z.raw[z.pos:])
+	z.pos += n
+	return n, nil
+}
+
+func (z *slzReader) readBlock() (err error) {
+	defer func() {
+		if err != nil {
+			// never serve a broken block
+			z.raw, z.pos = z.raw[:0], 0
+		}
+	}()
+	typ, err := z.r.ReadByte()
+	if err != nil {
+		return slzTruncated(err)
+	}
+	if typ == slzBlockEnd {
+		return io.EOF
+	}
+	if typ != slzBlockLZ && typ != slzBlockStored {
+		return fmt.Errorf("%w: slz block type %#x", ErrCorrupt, typ)
+	}
+	rawLen, err := z.readLen("block", slzBlockSize)
+	if err != nil {
+		return err
+	}
+	if cap(z.raw) < rawLen {
+		z.raw = make([]byte, rawLen)
+	}
+	z.raw = z.raw[:rawLen]
+	z.pos = 0
+
+	if typ == slzBlockStored {
+		if _, err = io.ReadFull(z.r, z.raw); err != nil {
+			return slzTruncated(err)
+		}
+	} else {
+		compLen, err := z.readLen("compressed block", slzMaxCompBlock)
+		if err != nil {
+			return err
+		}
+		if cap(z.comp) < compLen {
+			z.comp = make([]byte, compLen)
+		}
+		z.comp = z.comp[:compLen]
+		if _, err = io.ReadFull(z.r, z.comp); err != nil {
+			return slzTruncated(err)
+		}
+		if err = slzDecodeBlock(z.raw, z.comp); err != nil {
+			return err
+		}
+	}
+	var crc [4]byte
+	if _, err = io.ReadFull(z.r, crc[:]); err != nil {
+		return slzTruncated(err)
+	}
+	if binary.LittleEndian.Uint32(crc[:]) != crc32.Checksum(z.raw, slzTable) {
+		return fmt.Errorf("%w: slz checksum mismatch", ErrCorrupt)
+	}
+	return nil
+}
+
+// readLen reads a uvarint length, which is at most max
+func (z *slzReader) readLen(what string, max int) (int, error) {
+	n := 0
+	for shift := 0; ; shift += 7 {
+		b, err := z.r.ReadByte()
+		if err != nil {
+			return 0, slzTruncated(err)
+		}
+		n |= int(b&0x7f) << shift
+		if n > max {
+			return 0, fmt.Errorf("%w: slz %s over %d bytes", ErrCorrupt, what, max)
+		}
+		if b < 0x80 {
+			return n, nil
+		}
+		if shift >= 21 {
+			// the lengths never take 4 bytes
+			return 0, fmt.Errorf("%w: slz %s length overflow", ErrCorrupt, what)
+		}
+	}
+}
+
+// slzTruncated reports the end of the input in the middle of a stream as
+// corrupt, the other errors of the underlying reader are kept
+func slzTruncated(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return fmt.Errorf("%w: slz truncated stream: %w", ErrCorrupt, io.ErrUnexpectedEOF)
+	}
+	return err
+}
+
+func (z *slzReader) Close() error {
+	return nil
+}
@@ -0,0 +1,167 @@
+package compr
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/argcv/stork/assert"
+)
+
+func randomBytes(size int) []byte {
+	rnd := rand.New(rand.NewSource(7))
+	out := make([]byte, size)
+	rnd.Read(out)
+	return out
+}
+
+func TestSLZRoundTrip(t *testing.T) {
+	cases := map[string][]byte{
+		"empty":          {},
+		"one byte":       []byte("x"),
+		"four bytes":     []byte("abcd"),
+		"repeated":       bytes.Repeat([]byte("a"), 1000),
+		"block":          benchPayload(slzBlockSize),
+		"block - 1":      benchPayload(slzBlockSize - 1),
+		"block + 1":      benchPayload(slzBlockSize + 1),
+		"blocks":         benchPayload(3*slzBlockSize + 123),
+		"incompressible": randomBytes(slzBlockSize + 4567),
+	}
+	for name, in := range cases {
+		for _, level := range []int{DefaultCompression, NoCompression, BestSpeed, BestCompression} {
+			comp, err := Compress(CodecSLZ, in, level)
+			assert.ExpectNoErr(t, err)
+			out, err := Decompress(comp)
+			if err != nil {
+				t.Fatalf("%s, level %d: %v", name, level, err)
+			}
+			if !bytes.Equal(in, out) {
+				t.Fatalf("%s, level %d: mismatch", name, level)
+			}
+		}
+	}
+}
+
+func TestSLZBlocks(t *testing.T) {
+	// the incompressible data is stored
+	in := randomBytes(1000)
+	comp, err := Compress(CodecSLZ, in, DefaultCompression)
+	assert.ExpectNoErr(t, err)
+	assert.ExpectEQ(t, byte(slzBlockStored), comp[len(slzMagic)])
+	assert.ExpectLT(t, len(comp), len(in)+16)
+
+	in = benchPayload(1000)
+	comp, err = Compress(CodecSLZ, in, DefaultCompression)
+	assert.ExpectNoErr(t, err)
+	assert.ExpectEQ(t, byte(slzBlockLZ), comp[len(slzMagic)])
+	assert.ExpectLT(t, len(comp), len(in))
+
+	// NoCompression stores every block
+	in = benchPayload(2*slzBlockSize + 10)
+	comp, err = Compress(CodecSLZ, in, NoCompression)
+	assert.ExpectNoErr(t, err)
+	assert.ExpectEQ(t, byte(slzBlockStored), comp[len(slzMagic)])
+	// magic, 3 blocks of type, length (3 bytes at most), data and crc, end
+	assert.ExpectGE(t, len(comp), len(in)+len(slzMagic)+3*(1+1+4)+1)
+	assert.ExpectLT(t, len(comp), len(in)+len(slzMagic)+3*(1+3+4)+2)
+	out, err := DecompressWith(CodecSLZ, comp)
+	assert.ExpectNoErr(t, err)
+	assert.ExpectTrue(t, bytes.Equal(in, out))
+
+	_, err = Compress(CodecSLZ, in, 10)
+	assert.ExpectErr(t, err)
+}
+
+func TestSLZCorrupt(t *testing.T) {
+	in := benchPayload(slzBlockSize + 1000)
+	comp, err := Compress(CodecSLZ, in, DefaultCompression)
+	assert.ExpectNoErr(t, err)
+	stored, err := Compress(CodecSLZ, in[:300], NoCompression)
+	assert.ExpectNoErr(t, err)
+
+	expectCorrupt := func(name string, data []byte) {
+		t.Helper()
+		_, err := DecompressWith(CodecSLZ, data)
+		if !errors.Is(err, ErrCorrupt) {
+			t.Fatalf("%s: expected %v, got %v", name, ErrCorrupt, err)
+		}
+	}
+
+	// truncated at every position of the header and around the blocks
+	for _, n := range []int{0, 1, 3, 4, 5, 6, 7, 100, len(comp) / 2, len(comp) - 5, len(comp) - 1} {
+		expectCorrupt(fmt.Sprintf("truncated at %d", n), comp[:n])
+	}
+	for n := 0; n < len(stored); n++ {
+		expectCorrupt("truncated stored", stored[:n])
+	}
+
+	// every single bit flip of the small streams
+	small, err := Compress(CodecSLZ, benchPayload(300), DefaultCompression)
+	assert.ExpectNoErr(t, err)
+	for _, src := range [][]byte{stored, small} {
+		for i := 0; i < len(src); i++ {
+			for bit := 0; bit < 8; bit++ {
+				data := append([]byte(nil), src...)
+				data[i] ^= 1 << bit
+				expectCorrupt("bit flipped", data)
+			}
+		}
+	}
+
+	// a bad crc of the first block
+	data := append([]byte(nil), stored...)
+	data[len(data)-2] ^= 0xff
+	_, err = DecompressWith(CodecSLZ, data)
+	assert.ExpectTrue(t, errors.Is(err, ErrCorrupt))
+	assert.ExpectTrue(t, bytes.Contains([]byte(err.Error()), []byte("checksum")))
+
+	// the block is never served before the crc is checked
+	r, err := NewReader(bytes.NewReader(data), CodecSLZ)
+	assert.ExpectNoErr(t, err)
+	n, err := r.Read(make([]byte, 10))
+	assert.ExpectEQ(t, 0, n)
+	assert.ExpectTrue(t, errors.Is(err, ErrCorrupt))
+
+	expectCorrupt("bad magic", []byte("SLZ2\xff"))
+	expectCorrupt("bad block type", []byte("SLZ1\x07"))
+	expectCorrupt("block too large", []byte("SLZ1\x01\xff\xff\x7f"))
+}
+
+func TestCodecRegistry(t *testing.T) {
+	in := benchPayload(5000)
+	for _, name := range []string{CodecGzip, CodecZlib, CodecDeflate, CodecSLZ} {
+		c, err := GetCodec(name)
+		assert.ExpectNoErr(t, err)
+		assert.ExpectEQ(t, name, c.Name())
+
+		comp, err := Compress(name, in, BestSpeed)
+		assert.ExpectNoErr(t, err)
+		out, err := DecompressWith(name, comp)
+		assert.ExpectNoErr(t, err)
+		assert.ExpectTrue(t, bytes.Equal(in, out))
+
+		d, err := Detect(comp[:detectSize])
+		if name == CodecDeflate {
+			// no magic bytes
+			assert.ExpectTrue(t, errors.Is(err, ErrUnknownCodec))
+			_, err = Decompress(comp)
+			assert.ExpectTrue(t, errors.Is(err, ErrUnknownCodec))
+			continue
+		}
+		assert.ExpectNoErr(t, err)
+		assert.ExpectEQ(t, name, d.Name())
+		out, err = Decompress(comp)
+		assert.ExpectNoErr(t, err)
+		assert.ExpectTrue(t, bytes.Equal(in, out))
+	}
+	assert.ExpectEQ(t, []string{CodecGzip, CodecZlib, CodecDeflate, CodecSLZ}, Codecs())
+
+	_, err := GetCodec("lzma")
+	assert.ExpectTrue(t, errors.Is(err, ErrUnknownCodec))
+	_, err = Compress("lzma", in, DefaultCompression)
+	assert.ExpectTrue(t, errors.Is(err, ErrUnknownCodec))
+	_, err = Detect([]byte("plain text"))
+	assert.ExpectTrue(t, errors.Is(err, ErrUnknownCodec))
+}
@@ -0,0 +1,511 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/argcv/stork/cntr"
+	"github.com/spf13/cast"
+	"github.com/spf13/viper"
+)
+
+var (
+	ErrNotStructPointer = errors.New("config_not_struct_pointer")
+	ErrRequired         = errors.New("config_required")
+	ErrOutOfRange       = errors.New("config_out_of_range")
+	ErrNotInEnum        = errors.New("config_not_in_enum")
+	ErrInvalidValue     = errors.New("config_invalid_value")
+	ErrInvalidTag       = errors.New("config_invalid_tag")
+)
+
+var (
+	durationType = reflect.TypeOf(time.Duration(0))
+	timeType     = reflect.TypeOf(time.Time{})
+)
+
+/*
+Bind fills the struct pointed by out with the keys under key (e.g.
+redis.default) of the global viper.
+
+Tags:
+
+	config:"name"         the key, or the mapstructure tag, or the lower
+	                      case field name, "-" to skip, ",squash" to
+	                      promote the fields of a nested struct
+	default:"6379"        the value if the key is not set
+	validate:"required,min=1,max=65535,enum=tcp|udp"
+
+min and max are the values of the numbers and durations (e.g. min=1s),
+or the lengths of the strings, slices and maps. enum is checked for each
+element of a slice. required fails if the key is not set, or the value is
+an empty string, slice or map.
+
+The fields of the missing keys without a default are left as is, so the
+struct could be pre-filled. A duration is a string like "5s", or the
+nanoseconds.
+
+All the invalid fields are reported as *cntr.FieldError (the path is the
+key) joined in the returned error, the valid fields are filled anyway.
+
+Example:
+
+	type RedisConfig struct {
+		Host string `config:"host" default:"127.0.0.1"`
+		Port int    `config:"port" default:"6379" validate:"min=1,max=65535"`
+		Pass string `config:"pass"`
+		Db   int    `config:"db" validate:"min=0,max=15"`
+	}
+
+	cfg := &RedisConfig{}
+	err := config.Bind("redis.default", cfg)
+*/
+func Bind(key string, out interface{}) error {
+	return BindViper(viper.GetViper(), key, out)
+}
+
+// BindViper is Bind with a viper instance
+func BindViper(v *viper.Viper, key string, out interface{}) error {
+	rv := reflect.ValueOf(out)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("%w: %T", ErrNotStructPointer, out)
+	}
+	b := &binder{}
+	b.bindStruct(viperSource{v}, key, key, rv.Elem())
+	return errors.Join(b.errs...)
+}
+
+// Bind is BindViper under the key of the builder, e.g.
+// NewKeyBuilder(KeyRedisBase).WithProfile(profile).Bind("", cfg)
+func (ckb *KeyBuilder) Bind(key string, out interface{}) error {
+	return BindViper(ckb.Src, ckb.GetKey(key), out)
+}
+
+// bindSource looks up a dotted key
+type bindSource interface {
+	get(key string) (interface{}, bool)
+}
+
+type viperSource struct {
+	v *viper.Viper
+}
+
+func (s viperSource) get(key string) (interface{}, bool) {
+	if !s.v.IsSet(key) {
+		return nil, false
+	}
+	return s.v.Get(key), true
+}
+
+// mapSource is a nested map, e.g. an element of a slice, the keys are
+// case insensitive
+type mapSource map[string]interface{}
+
+func (s mapSource) get(key string) (interface{}, bool) {
+	var cur interface{} = map[string]interface{}(s)
+	for _, part := range strings.Split(key, ".") {
+		m, err := cast.ToStringMapE(cur)
+		if err != nil {
+			return nil, false
+		}
+		found := false
+		for k, v := range m {
+			if strings.EqualFold(k, part) {
+				cur, found = v, true
+				break
+			}
+		}
+		if !found {
+			return nil, false
+		}
+	}
+	return cur, true
+}
+
+func joinKey(prefix, key string) string {
+	if prefix == "" {
+		return key
+	}
+	return prefix + "." + key
+}
+
+type binder struct {
+	errs []error
+}
+
+func (b *binder) fail(path string, err error) {
+	b.errs = append(b.errs, &cntr.FieldError{Path: path, Err: err})
+}
+
+func bindKey(sf reflect.StructField) (name string, squash bool, skip bool) {
+	tag, ok := sf.Tag.Lookup("config")
+	if !ok {
+		tag = sf.Tag.Get("mapstructure")
+	}
+	if tag == "-" {
+		return "", false, true
+	}
+	parts := strings.Split(tag, ",")
+	for _, o := range parts[1:] {
+		if o == "squash" {
+			squash = true
+		}
+	}
+	name = parts[0]
+	if name == "" {
+		if sf.Anonymous {
+			squash = true
+		}
+		name = strings.ToLower(sf.Name)
+	}
+	return
+}
+
+// isSection returns true if t is bound from the nested keys
+func isSection(t reflect.Type) bool {
+	return t.Kind() == reflect.Struct && t != timeType
+}
+
+// bindStruct binds the fields of rv from the keys under prefix, the path
+// is for the errors
+func (b *binder) bindStruct(src bindSource, prefix, path string, rv reflect.Value) {
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		sf := rt.Field(i)
+		name, squash, skip := bindKey(sf)
+		if skip {
+			continue
+		}
+		fv := rv.Field(i)
+		if squash && isSection(sf.Type) {
+			// the exported fields of an unexported embedded struct are
+			// settable as well
+			b.bindStruct(src, prefix, path, fv)
+			continue
+		}
+		if !sf.IsExported() {
+			continue
+		}
+		key, fpath := joinKey(prefix, name), joinKey(path, name)
+		rules, err := parseBindRules(sf.Tag.Get("validate"))
+		if err != nil {
+			b.fail(fpath, err)
+			continue
+		}
+		raw, ok := src.get(key)
+		if !ok {
+			if def, has := sf.Tag.Lookup("default"); has {
+				raw, ok = def, true
+			}
+		}
+
+		switch {
+		case isSection(sf.Type):
+			if rules.required && !ok {
+				b.fail(fpath, ErrRequired)
+				continue
+			}
+			b.bindStruct(src, key, fpath, fv)
+			continue
+		case sf.Type.Kind() == reflect.Pointer && isSection(sf.Type.Elem()):
+			if !ok {
+				if rules.required {
+					b.fail(fpath, ErrRequired)
+				}
+				continue
+			}
+			if fv.IsNil() {
+				fv.Set(reflect.New(sf.Type.Elem()))
+			}
+			b.bindStruct(src, key, fpath, fv.Elem())
+			continue
+		}
+
+		if !ok {
+			if rules.required {
+				b.fail(fpath, ErrRequired)
+			}
+			continue
+		}
+		nv := reflect.New(sf.Type).Elem()
+		if err = b.setValue(nv, raw, fpath); err != nil {
+			b.fail(fpath, fmt.Errorf("%w: %v", ErrInvalidValue, err))
+			continue
+		}
+		if err = rules.validate(nv); err != nil {
+			b.fail(fpath, err)
+			continue
+		}
+		fv.Set(nv)
+	}
+}
+
+func (b *binder) setValue(fv reflect.Value, raw interface{}, path string) (err error) {
+	t := fv.Type()
+	switch t {
+	case durationType:
+		var d time.Duration
+		if d, err = cast.ToDurationE(raw); err == nil {
+			fv.SetInt(int64(d))
+		}
+		return
+	case timeType:
+		var tm time.Time
+		if tm, err = cast.ToTimeE(raw); err == nil {
+			fv.Set(reflect.ValueOf(tm))
+		}
+		return
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		nv := reflect.New(t.Elem())
+		if err = b.setValue(nv.Elem(), raw, path); err == nil {
+			fv.Set(nv)
+		}
+	case reflect.String:
+		var s string
+		if s, err = cast.ToStringE(raw); err == nil {
+			fv.SetString(s)
+		}
+	case reflect.Bool:
+		var v bool
+		if v, err = cast.ToBoolE(raw); err == nil {
+			fv.SetBool(v)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if f, isFloat := raw.(float64); isFloat && f != math.Trunc(f) {
+			return fmt.Errorf("%v is not an integer", raw)
+		}
+		var v int64
+		if s, isString := raw.(string); isString {
+			// base 10, cast takes "010" as an octal
+			v, err = strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+		} else {
+			v, err = cast.ToInt64E(raw)
+		}
+		if err != nil {
+			return
+		}
+		if fv.OverflowInt(v) {
+			return fmt.Errorf("%v overflows %v", v, t)
+		}
+		fv.SetInt(v)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if f, isFloat := raw.(float64); isFloat && f != math.Trunc(f) {
+			return fmt.Errorf("%v is not an integer", raw)
+		}
+		var v uint64
+		if s, isString := raw.(string); isString {
+			v, err = strconv.ParseUint(strings.TrimSpace(s), 10, 64)
+		} else {
+			v, err = cast.ToUint64E(raw)
+		}
+		if err != nil {
+			return
+		}
+		if fv.OverflowUint(v) {
+			return fmt.Errorf("%v overflows %v", v, t)
+		}
+		fv.SetUint(v)
+	case reflect.Float32, reflect.Float64:
+		var v float64
+		if v, err = cast.ToFloat64E(raw); err != nil {
+			return
+		}
+		if fv.OverflowFloat(v) {
+			return fmt.Errorf("%v overflows %v", v, t)
+		}
+		fv.SetFloat(v)
+	case reflect.Slice:
+		items, err := toItems(raw)
+		if err != nil {
+			return err
+		}
+		sv := reflect.MakeSlice(t, len(items), len(items))
+		for i, item := range items {
+			if err = b.setValue(sv.Index(i), item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return fmt.Errorf("[%d]: %v", i, err)
+			}
+		}
+		fv.Set(sv)
+	case reflect.Map:
+		if t.Key().Kind() != reflect.String {
+			return fmt.Errorf("unsupported type %v", t)
+		}
+		m, err := cast.ToStringMapE(raw)
+		if err != nil {
+			return err
+		}
+		mv := reflect.MakeMapWithSize(t, len(m))
+		for k, v := range m {
+			ev := reflect.New(t.Elem()).Elem()
+			if err = b.setValue(ev, v, joinKey(path, k)); err != nil {
+				return fmt.Errorf("%s: %v", k, err)
+			}
+			mv.SetMapIndex(reflect.ValueOf(k).Convert(t.Key()), ev)
+		}
+		fv.Set(mv)
+	case reflect.Struct:
+		m, err := cast.ToStringMapE(raw)
+		if err != nil {
+			return err
+		}
+		b.bindStruct(mapSource(m), "", path, fv)
+	case reflect.Interface:
+		if raw != nil {
+			fv.Set(reflect.ValueOf(raw))
+		}
+	default:
+		return fmt.Errorf("unsupported type %v", t)
+	}
+	return
+}
+
+// toItems converts a slice, or a comma separated string (e.g. from the
+// env or the default tag)
+func toItems(raw interface{}) ([]interface{}, error) {
+	if s, ok := raw.(string); ok {
+		if strings.TrimSpace(s) == "" {
+			return nil, nil
+		}
+		parts := strings.Split(s, ",")
+		items := make([]interface{}, len(parts))
+		for i, p := range parts {
+			items[i] = strings.TrimSpace(p)
+		}
+		return items, nil
+	}
+	rv := reflect.ValueOf(raw)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return nil, fmt.Errorf("%v is not a list", raw)
+	}
+	items := make([]interface{}, rv.Len())
+	for i := range items {
+		items[i] = rv.Index(i).Interface()
+	}
+	return items, nil
+}
+
+type bindRules struct {
+	required bool
+	min, max string
+	enum     []string
+}
+
+func parseBindRules(tag string) (r bindRules, err error) {
+	if tag == "" {
+		return
+	}
+	for _, rule := range strings.Split(tag, ",") {
+		name, arg, _ := strings.Cut(strings.TrimSpace(rule), "=")
+		switch name {
+		case "required":
+			r.required = true
+		case "min":
+			r.min = arg
+		case "max":
+			r.max = arg
+		case "enum":
+			r.enum = strings.Split(arg, "|")
+		case "":
+		default:
+			return r, fmt.Errorf("%w: %q", ErrInvalidTag, rule)
+		}
+	}
+	return
+}
+
+func (r bindRules) validate(v reflect.Value) error {
+	for v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	if r.required {
+		switch v.Kind() {
+		case reflect.String, reflect.Slice, reflect.Map:
+			if v.Len() == 0 {
+				return ErrRequired
+			}
+		}
+	}
+	if r.min != "" || r.max != "" {
+		if err := r.checkRange(v); err != nil {
+			return err
+		}
+	}
+	if len(r.enum) > 0 {
+		if v.Kind() == reflect.Slice || v.Kind() == reflect.Array {
+			for i := 0; i < v.Len(); i++ {
+				if err := r.checkEnum(v.Index(i)); err != nil {
+					return fmt.Errorf("[%d]: %w", i, err)
+				}
+			}
+			return nil
+		}
+		return r.checkEnum(v)
+	}
+	return nil
+}
+
+func (r bindRules) checkRange(v reflect.Value) error {
+	var value float64
+	what := fmt.Sprint(v.Interface())
+	parse := func(s string) (float64, error) {
+		return strconv.ParseFloat(s, 64)
+	}
+	switch {
+	case v.Type() == durationType:
+		value = float64(v.Int())
+		parse = func(s string) (float64, error) {
+			d, err := cast.ToDurationE(s)
+			return float64(d), err
+		}
+	case v.CanInt():
+		value = float64(v.Int())
+	case v.CanUint():
+		value = float64(v.Uint())
+	case v.CanFloat():
+		value = v.Float()
+	case v.Kind() == reflect.String, v.Kind() == reflect.Slice, v.Kind() == reflect.Map:
+		value = float64(v.Len())
+		what = fmt.Sprintf("length %d", v.Len())
+	default:
+		return fmt.Errorf("%w: min and max on %v", ErrInvalidTag, v.Type())
+	}
+	for _, bound := range []struct {
+		arg string
+		min bool
+	}{{r.min, true}, {r.max, false}} {
+		if bound.arg == "" {
+			continue
+		}
+		limit, err := parse(bound.arg)
+		if err != nil {
+			return fmt.Errorf("%w: %q", ErrInvalidTag, bound.arg)
+		}
+		if bound.min && value < limit {
+			return fmt.Errorf("%w: %s < min %s", ErrOutOfRange, what, bound.arg)
+		}
+		if !bound.min && value > limit {
+			return fmt.Errorf("%w: %s > max %s", ErrOutOfRange, what, bound.arg)
+		}
+	}
+	return nil
+}
+
+func (r bindRules) checkEnum(v reflect.Value) error {
+	s := fmt.Sprint(v.Interface())
+	for _, e := range r.enum {
+		if s == e {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %q not in %s", ErrNotInEnum, s, strings.Join(r.enum, "|"))
+}
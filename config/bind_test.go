@@ -0,0 +1,179 @@
+package config
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/argcv/stork/assert"
+	"github.com/argcv/stork/cntr"
+	"github.com/spf13/viper"
+)
+
+type testBindServer struct {
+	Host    string        `config:"host" default:"127.0.0.1"`
+	Port    int           `config:"port" default:"6379" validate:"min=1,max=65535"`
+	Timeout time.Duration `config:"timeout" default:"5s" validate:"min=1s"`
+	Mode    string        `config:"mode" validate:"enum=tcp|udp"`
+	Tags    []string      `config:"tags"`
+}
+
+type testBindConfig struct {
+	Name    string           `config:"name" validate:"required"`
+	Server  testBindServer   `config:"server"`
+	Backups []testBindServer `config:"backups"`
+	Extra   *testBindServer  `config:"extra"`
+	Ignored string           `config:"-"`
+}
+
+// fieldErrors returns the *cntr.FieldError in err by the path
+func fieldErrors(t *testing.T, err error) map[string]error {
+	t.Helper()
+	joined, ok := err.(interface{ Unwrap() []error })
+	if !ok {
+		t.Fatalf("expected the joined errors, got %v", err)
+	}
+	errs := map[string]error{}
+	for _, e := range joined.Unwrap() {
+		fe, ok := e.(*cntr.FieldError)
+		if !ok {
+			t.Fatalf("expected a *cntr.FieldError, got %T: %v", e, e)
+		}
+		errs[fe.Path] = fe.Err
+	}
+	return errs
+}
+
+func TestBindViperDefaults(t *testing.T) {
+	v := viper.New()
+	v.Set("app.name", "stork")
+	v.Set("app.server.port", 8080)
+	v.Set("app.server.timeout", "0s")
+	v.Set("app.server.tags", "a, b")
+
+	cfg := &testBindConfig{Ignored: "kept"}
+	cfg.Server.Mode = "prefilled"
+	err := BindViper(v, "app", cfg)
+	// 0s is set, so the default 5s is not applied, and it is out of range
+	errs := fieldErrors(t, err)
+	assert.ExpectEQ(t, 1, len(errs))
+	assert.ExpectTrue(t, errors.Is(errs["app.server.timeout"], ErrOutOfRange))
+
+	assert.ExpectEQ(t, "stork", cfg.Name)
+	assert.ExpectEQ(t, "127.0.0.1", cfg.Server.Host)
+	assert.ExpectEQ(t, 8080, cfg.Server.Port)
+	assert.ExpectEQ(t, time.Duration(0), cfg.Server.Timeout)
+	assert.ExpectEQ(t, "prefilled", cfg.Server.Mode)
+	assert.ExpectEQ(t, []string{"a", "b"}, cfg.Server.Tags)
+	assert.ExpectTrue(t, cfg.Extra == nil)
+	assert.ExpectEQ(t, "kept", cfg.Ignored)
+
+	v.Set("app.server.timeout", "1m")
+	v.Set("app.extra.host", "10.0.0.1")
+	cfg = &testBindConfig{}
+	assert.ExpectNoErr(t, BindViper(v, "app", cfg))
+	assert.ExpectEQ(t, time.Minute, cfg.Server.Timeout)
+	assert.ExpectEQ(t, "10.0.0.1", cfg.Extra.Host)
+	// the defaults of the section
+	assert.ExpectEQ(t, 6379, cfg.Extra.Port)
+	assert.ExpectEQ(t, 5*time.Second, cfg.Extra.Timeout)
+
+	// the defaults for the keys unset only
+	v = viper.New()
+	v.Set("app.name", "stork")
+	v.Set("app.server.host", "")
+	cfg = &testBindConfig{}
+	assert.ExpectNoErr(t, BindViper(v, "app", cfg))
+	assert.ExpectEQ(t, "", cfg.Server.Host)
+	assert.ExpectEQ(t, 6379, cfg.Server.Port)
+}
+
+func TestBindViperErrors(t *testing.T) {
+	v := viper.New()
+	v.Set("app.server.port", 70000)
+	v.Set("app.server.mode", "http")
+	v.Set("app.server.timeout", "soon")
+	v.Set("app.backups", []interface{}{
+		map[string]interface{}{"host": "b0", "port": 1},
+		map[string]interface{}{"host": "b1", "port": "x"},
+		map[string]interface{}{"host": "b2", "mode": "udp"},
+	})
+
+	cfg := &testBindConfig{}
+	err := BindViper(v, "app", cfg)
+	errs := fieldErrors(t, err)
+	assert.ExpectEQ(t, 5, len(errs))
+	assert.ExpectTrue(t, errors.Is(errs["app.name"], ErrRequired))
+	assert.ExpectTrue(t, errors.Is(errs["app.server.port"], ErrOutOfRange))
+	assert.ExpectTrue(t, errors.Is(errs["app.server.mode"], ErrNotInEnum))
+	assert.ExpectTrue(t, errors.Is(errs["app.server.timeout"], ErrInvalidValue))
+	assert.ExpectTrue(t, errors.Is(errs["app.backups[1].port"], ErrInvalidValue))
+	assert.ExpectTrue(t, errors.Is(err, ErrRequired))
+
+	// the valid fields are filled anyway
+	assert.ExpectEQ(t, "127.0.0.1", cfg.Server.Host)
+	assert.ExpectEQ(t, 0, cfg.Server.Port)
+	assert.ExpectEQ(t, 3, len(cfg.Backups))
+	assert.ExpectEQ(t, "b2", cfg.Backups[2].Host)
+	assert.ExpectEQ(t, "udp", cfg.Backups[2].Mode)
+	assert.ExpectEQ(t, 6379, cfg.Backups[2].Port)
+
+	// a required section
+	type withRequired struct {
+		Server testBindServer `config:"server" validate:"required"`
+	}
+	errs = fieldErrors(t, BindViper(viper.New(), "app", &withRequired{}))
+	assert.ExpectTrue(t, errors.Is(errs["app.server"], ErrRequired))
+
+	type badTag struct {
+		Port int `config:"port" validate:"positive"`
+	}
+	errs = fieldErrors(t, BindViper(viper.New(), "app", &badTag{}))
+	assert.ExpectTrue(t, errors.Is(errs["app.port"], ErrInvalidTag))
+
+	assert.ExpectTrue(t, errors.Is(BindViper(v, "app", testBindConfig{}), ErrNotStructPointer))
+	var nilCfg *testBindConfig
+	assert.ExpectTrue(t, errors.Is(BindViper(v, "app", nilCfg), ErrNotStructPointer))
+}
+
+func TestBindViperSquash(t *testing.T) {
+	type Common struct {
+		Debug bool `config:"debug"`
+	}
+	type cfgType struct {
+		Common
+		Level int `mapstructure:"log_level"`
+		Limit int
+	}
+	v := viper.New()
+	v.Set("svc.debug", true)
+	v.Set("svc.log_level", 3)
+	v.Set("svc.limit", 10)
+	cfg := &cfgType{}
+	assert.ExpectNoErr(t, BindViper(v, "svc", cfg))
+	assert.ExpectEQ(t, cfgType{Common: Common{Debug: true}, Level: 3, Limit: 10}, *cfg)
+}
+
+func TestBindViperDecimal(t *testing.T) {
+	type cfgType struct {
+		Port  int    `config:"port"`
+		Mask  uint16 `config:"mask"`
+		Count int8   `config:"count"`
+	}
+	v := viper.New()
+	v.Set("app.port", "010")
+	v.Set("app.mask", " 0755 ")
+	cfg := &cfgType{}
+	assert.ExpectNoErr(t, BindViper(v, "app", cfg))
+	assert.ExpectEQ(t, 10, cfg.Port)
+	assert.ExpectEQ(t, uint16(755), cfg.Mask)
+
+	v.Set("app.port", "0x10")
+	v.Set("app.mask", "-1")
+	v.Set("app.count", "300")
+	errs := fieldErrors(t, BindViper(v, "app", &cfgType{}))
+	assert.ExpectEQ(t, 3, len(errs))
+	for _, key := range []string{"app.port", "app.mask", "app.count"} {
+		assert.ExpectTrue(t, errors.Is(errs[key], ErrInvalidValue), key)
+	}
+}
@@ -0,0 +1,485 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/argcv/stork/log"
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/cast"
+	"github.com/spf13/viper"
+)
+
+// DefaultDebounce is the quiet period after the last change of the file,
+// editors usually write a file in several steps
+const DefaultDebounce = 200 * time.Millisecond
+
+// Snapshot is an immutable view of a loaded config file
+type Snapshot struct {
+	v        *viper.Viper
+	flat     map[string]interface{}
+	version  uint64
+	loadedAt time.Time
+}
+
+func newSnapshot(v *viper.Viper, version uint64) *Snapshot {
+	flat := map[string]interface{}{}
+	flattenSettings("", v.AllSettings(), flat)
+	return &Snapshot{v: v, flat: flat, version: version, loadedAt: time.Now()}
+}
+
+func flattenSettings(prefix string, in map[string]interface{}, out map[string]interface{}) {
+	for k, v := range in {
+		key := joinKey(prefix, k)
+		if m, err := cast.ToStringMapE(v); err == nil && len(m) > 0 {
+			flattenSettings(key, m, out)
+		} else {
+			out[key] = v
+		}
+	}
+}
+
+// Version starts from 1, and increases on each swap
+func (s *Snapshot) Version() uint64 {
+	return s.version
+}
+
+func (s *Snapshot) LoadedAt() time.Time {
+	return s.loadedAt
+}
+
+// Viper returns the underlying viper, which must not be modified, e.g. for
+// a KeyBuilder
+func (s *Snapshot) Viper() *viper.Viper {
+	return s.v
+}
+
+func (s *Snapshot) Get(key string) interface{} {
+	return s.v.Get(key)
+}
+
+func (s *Snapshot) IsSet(key string) bool {
+	return s.v.IsSet(key)
+}
+
+// Keys returns the sorted leaf keys, e.g. mail.smtp.default.host
+func (s *Snapshot) Keys() []string {
+	keys := make([]string, 0, len(s.flat))
+	for k := range s.flat {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Bind is BindViper on the snapshot
+func (s *Snapshot) Bind(key string, out interface{}) error {
+	return BindViper(s.v, key, out)
+}
+
+// diffKeys returns the sorted leaf keys added, removed or modified
+func diffKeys(old, new *Snapshot) []string {
+	var keys []string
+	for k, nv := range new.flat {
+		if ov, ok := old.flat[k]; !ok || !reflect.DeepEqual(ov, nv) {
+			keys = append(keys, k)
+		}
+	}
+	for k := range old.flat {
+		if _, ok := new.flat[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// ChangeEvent is sent to the subscribers of Prefix, Keys are the changed
+// leaf keys under Prefix
+type ChangeEvent struct {
+	Prefix string
+	Keys   []string
+	Old    *Snapshot
+	New    *Snapshot
+}
+
+// underPrefix returns the keys under the prefix, all for an empty prefix
+func underPrefix(keys []string, prefix string) []string {
+	if prefix == "" {
+		return keys
+	}
+	var result []string
+	for _, k := range keys {
+		if k == prefix || strings.HasPrefix(k, prefix+".") || strings.HasPrefix(prefix, k+".") {
+			result = append(result, k)
+		}
+	}
+	return result
+}
+
+type watcherHook struct {
+	prefix   string
+	notify   func(ev ChangeEvent)
+	validate func(s *Snapshot) error
+}
+
+/**
+ * Watcher reloads a config file on change.
+ *
+ * The changes are debounced, the new file is loaded into a new snapshot,
+ * which is swapped atomically with the current one if all the validators
+ * pass, or else the current snapshot is kept (the rollback) and the error
+ * is reported. Then the subscribers of the prefixes with the changed keys
+ * are notified, in the order of subscription.
+ *
+ * The snapshots are independent of the global viper.
+ *
+ * Example:
+ *
+ *   w := config.NewWatcher("stork.yml").SetDebounce(time.Second)
+ *   cancel := config.SubscribeBind(w, "mail.smtp.default",
+ *       func(old, new *config.SMTPConfig) {
+ *           // reconnect
+ *       })
+ *   defer cancel()
+ *   if err := w.Start(); err != nil {
+ *       return err
+ *   }
+ *   defer w.Stop()
+ */
+type Watcher struct {
+	file       string
+	configType string
+	debounce   time.Duration
+	onError    func(err error)
+
+	current  atomic.Pointer[Snapshot]
+	previous atomic.Pointer[Snapshot]
+	version  uint64
+
+	mu       sync.Mutex // hooks, reload and the lifecycle
+	notifyMu sync.Mutex // the notifications in the order of the swaps
+	hooks    []*watcherHook
+	fsw      *fsnotify.Watcher
+	stop     chan struct{}
+	stopped  chan struct{}
+	// a reload of the loop is in progress, Stop must not wait for the
+	// loop, which may be the caller, e.g. a subscriber
+	loopReloading atomic.Bool
+}
+
+// NewWatcher returns a watcher of the file, the type is taken from the
+// extension
+func NewWatcher(file string) *Watcher {
+	return &Watcher{
+		file:     filepath.Clean(file),
+		debounce: DefaultDebounce,
+		onError: func(err error) {
+			log.Warnf("config: reload failed, keep the previous config: %v", err)
+		},
+	}
+}
+
+// WatchConfig returns a watcher of the file loaded by LoadConfig
+func WatchConfig() (*Watcher, error) {
+	file := viper.ConfigFileUsed()
+	if file == "" {
+		return nil, errors.New("no config file loaded")
+	}
+	return NewWatcher(file), nil
+}
+
+// SetConfigType sets the type if the extension is not, e.g. yaml
+func (w *Watcher) SetConfigType(t string) *Watcher {
+	w.configType = t
+	return w
+}
+
+func (w *Watcher) SetDebounce(d time.Duration) *Watcher {
+	w.debounce = d
+	return w
+}
+
+// SetErrorHandler sets the handler of the failed reloads in background,
+// the errors are logged by default
+func (w *Watcher) SetErrorHandler(fn func(err error)) *Watcher {
+	w.onError = fn
+	return w
+}
+
+// AddValidator adds a check of the new snapshots, the returned function
+// removes it
+func (w *Watcher) AddValidator(fn func(s *Snapshot) error) (cancel func()) {
+	return w.addHook(&watcherHook{validate: fn})
+}
+
+// Subscribe calls fn on the changes of the keys under the prefix (e.g.
+// mail.smtp.default, or empty for all), the returned function removes it
+// fn must not call Reload or Rollback, it may call Stop.
+func (w *Watcher) Subscribe(prefix string, fn func(ev ChangeEvent)) (cancel func()) {
+	return w.addHook(&watcherHook{prefix: strings.ToLower(prefix), notify: fn})
+}
+
+func (w *Watcher) addHook(h *watcherHook) func() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.hooks = append(w.hooks, h)
+	return func() {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		for i, e := range w.hooks {
+			if e == h {
+				w.hooks = append(w.hooks[:i:i], w.hooks[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// SubscribeBind binds the section under key into T on each change of it,
+// T must be a struct. The binding is also a validator, so a new file with
+// an invalid section is rolled back.
+func SubscribeBind[T any](w *Watcher, key string, fn func(old, new *T)) (cancel func()) {
+	bind := func(s *Snapshot) (*T, error) {
+		t := new(T)
+		if err := s.Bind(key, t); err != nil {
+			return nil, err
+		}
+		return t, nil
+	}
+	cancelValidator := w.AddValidator(func(s *Snapshot) error {
+		_, err := bind(s)
+		return err
+	})
+	cancelSubscription := w.Subscribe(key, func(ev ChangeEvent) {
+		// the old one may fail for a new subscription
+		old, _ := bind(ev.Old)
+		cur, err := bind(ev.New)
+		if err != nil {
+			return
+		}
+		fn(old, cur)
+	})
+	return func() {
+		cancelValidator()
+		cancelSubscription()
+	}
+}
+
+// Current returns the current snapshot, nil before Start
+func (w *Watcher) Current() *Snapshot {
+	return w.current.Load()
+}
+
+func (w *Watcher) load() (*viper.Viper, error) {
+	v := viper.New()
+	v.SetConfigFile(w.file)
+	if w.configType != "" {
+		v.SetConfigType(w.configType)
+	}
+	if err := v.ReadInConfig(); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// Start loads the file, which must be valid, and watches it
+func (w *Watcher) Start() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.fsw != nil {
+		return nil
+	}
+	// nothing to notify for the first snapshot
+	if _, _, err := w.reload(); err != nil {
+		return err
+	}
+	fsw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	// watch the directory, the file may be replaced by a rename
+	if err = fsw.Add(filepath.Dir(w.file)); err != nil {
+		fsw.Close()
+		return err
+	}
+	w.fsw = fsw
+	w.stop = make(chan struct{})
+	w.stopped = make(chan struct{})
+	go w.loop(fsw, w.stop, w.stopped)
+	return nil
+}
+
+// Stop stops watching, the current snapshot is kept
+// It waits for the loop to exit, unless a reload in background is in
+// progress (e.g. Stop is called by a subscriber), which is the last one.
+func (w *Watcher) Stop() {
+	w.mu.Lock()
+	if w.fsw == nil {
+		w.mu.Unlock()
+		return
+	}
+	fsw, stop, stopped := w.fsw, w.stop, w.stopped
+	w.fsw = nil
+	w.mu.Unlock()
+
+	close(stop)
+	if !w.loopReloading.Load() {
+		<-stopped
+	}
+	fsw.Close()
+}
+
+func (w *Watcher) loop(fsw *fsnotify.Watcher, stop, stopped chan struct{}) {
+	defer close(stopped)
+	realPath, _ := filepath.EvalSymlinks(w.file)
+	var timer *time.Timer
+	var fire <-chan time.Time
+	defer func() {
+		if timer != nil {
+			timer.Stop()
+		}
+	}()
+	for {
+		select {
+		case ev, ok := <-fsw.Events:
+			if !ok {
+				return
+			}
+			// the symlinked files (e.g. the config maps of k8s) are
+			// swapped by the events of other files
+			cur, _ := filepath.EvalSymlinks(w.file)
+			if filepath.Clean(ev.Name) != w.file && cur == realPath {
+				continue
+			}
+			realPath = cur
+			if timer != nil {
+				timer.Stop()
+			}
+			timer = time.NewTimer(w.debounce)
+			fire = timer.C
+		case err, ok := <-fsw.Errors:
+			if !ok {
+				return
+			}
+			w.onError(err)
+		case <-fire:
+			fire = nil
+			select {
+			case <-stop:
+				// never reload once stopped
+				return
+			default:
+			}
+			w.loopReloading.Store(true)
+			_, err := w.Reload()
+			w.loopReloading.Store(false)
+			if err != nil {
+				w.onError(err)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// Reload loads the file now, the current snapshot is kept on errors
+// It returns false if nothing is changed.
+func (w *Watcher) Reload() (bool, error) {
+	w.mu.Lock()
+	return w.reloadAndNotify()
+}
+
+// reloadAndNotify is called with the lock, which is released
+func (w *Watcher) reloadAndNotify() (bool, error) {
+	changed, events, err := w.reload()
+	w.notifyMu.Lock()
+	w.mu.Unlock()
+	defer w.notifyMu.Unlock()
+	for _, ev := range events {
+		w.notify(ev)
+	}
+	return changed, err
+}
+
+func (w *Watcher) reload() (bool, []hookEvent, error) {
+	v, err := w.load()
+	if err != nil {
+		return false, nil, err
+	}
+	next := newSnapshot(v, w.version+1)
+	old := w.current.Load()
+	if old != nil && len(diffKeys(old, next)) == 0 {
+		return false, nil, nil
+	}
+	for _, h := range w.hooks {
+		if h.validate == nil {
+			continue
+		}
+		if err = h.validate(next); err != nil {
+			return false, nil, fmt.Errorf("invalid config %s: %w", w.file, err)
+		}
+	}
+	return true, w.swap(old, next), nil
+}
+
+// Rollback restores the previous snapshot, e.g. a new config breaks the
+// service in a way the validators could not find
+func (w *Watcher) Rollback() error {
+	w.mu.Lock()
+	prev, old := w.previous.Load(), w.current.Load()
+	if prev == nil {
+		w.mu.Unlock()
+		return errors.New("no previous config")
+	}
+	events := w.swap(old, &Snapshot{v: prev.v, flat: prev.flat, version: w.version + 1, loadedAt: time.Now()})
+	w.notifyMu.Lock()
+	w.mu.Unlock()
+	defer w.notifyMu.Unlock()
+	for _, ev := range events {
+		w.notify(ev)
+	}
+	return nil
+}
+
+type hookEvent struct {
+	hook *watcherHook
+	ev   ChangeEvent
+}
+
+// swap sets the new snapshot and returns the notifications, it is called
+// with the lock
+func (w *Watcher) swap(old, next *Snapshot) (events []hookEvent) {
+	w.version = next.version
+	w.previous.Store(old)
+	w.current.Store(next)
+	if old == nil {
+		return
+	}
+	changed := diffKeys(old, next)
+	for _, h := range w.hooks {
+		if h.notify == nil {
+			continue
+		}
+		if keys := underPrefix(changed, h.prefix); len(keys) > 0 {
+			events = append(events, hookEvent{h, ChangeEvent{Prefix: h.prefix, Keys: keys, Old: old, New: next}})
+		}
+	}
+	return
+}
+
+func (w *Watcher) notify(he hookEvent) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Warnf("config: subscriber of %q panicked: %v", he.hook.prefix, r)
+		}
+	}()
+	he.hook.notify(he.ev)
+}
@@ -0,0 +1,136 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/argcv/stork/assert"
+)
+
+const testDebounce = 100 * time.Millisecond
+
+func writeConfig(t *testing.T, file, content string) {
+	t.Helper()
+	assert.ExpectNoErr(t, os.WriteFile(file, []byte(content), 0644))
+}
+
+func startWatcher(t *testing.T, content string) (*Watcher, string) {
+	file := filepath.Join(t.TempDir(), "stork.yml")
+	writeConfig(t, file, content)
+	w := NewWatcher(file).SetDebounce(testDebounce)
+	return w, file
+}
+
+func TestWatcherDebounce(t *testing.T) {
+	w, file := startWatcher(t, "app:\n  port: 1\nother:\n  name: a\n")
+	events := make(chan ChangeEvent, 10)
+	w.Subscribe("app", func(ev ChangeEvent) {
+		events <- ev
+	})
+	var otherCalls int32
+	w.Subscribe("other", func(ev ChangeEvent) {
+		atomic.AddInt32(&otherCalls, 1)
+	})
+	assert.ExpectNoErr(t, w.Start())
+	defer w.Stop()
+	assert.ExpectEQ(t, uint64(1), w.Current().Version())
+
+	// a burst of writes in the debounce window
+	for port := 2; port <= 5; port++ {
+		writeConfig(t, file, "app:\n  port: "+strconv.Itoa(port)+"\nother:\n  name: a\n")
+		time.Sleep(testDebounce / 5)
+	}
+
+	select {
+	case ev := <-events:
+		assert.ExpectEQ(t, "app", ev.Prefix)
+		assert.ExpectEQ(t, []string{"app.port"}, ev.Keys)
+		assert.ExpectEQ(t, 1, ev.Old.Get("app.port"))
+		assert.ExpectEQ(t, 5, ev.New.Get("app.port"))
+	case <-time.After(5 * time.Second):
+		t.Fatal("no notification")
+	}
+	// only one for the burst
+	select {
+	case ev := <-events:
+		t.Fatalf("unexpected notification: %v", ev.Keys)
+	case <-time.After(3 * testDebounce):
+	}
+	assert.ExpectEQ(t, uint64(2), w.Current().Version())
+	assert.ExpectEQ(t, int32(0), atomic.LoadInt32(&otherCalls))
+}
+
+func TestWatcherInvalidRewrite(t *testing.T) {
+	w, file := startWatcher(t, "app:\n  port: 1\n")
+	errs := make(chan error, 10)
+	w.SetErrorHandler(func(err error) {
+		errs <- err
+	})
+	w.AddValidator(func(s *Snapshot) error {
+		if s.Viper().GetInt("app.port") <= 0 {
+			return errors.New("invalid port")
+		}
+		return nil
+	})
+	var calls int32
+	w.Subscribe("", func(ev ChangeEvent) {
+		atomic.AddInt32(&calls, 1)
+	})
+	assert.ExpectNoErr(t, w.Start())
+	defer w.Stop()
+
+	for _, content := range []string{
+		// the syntax error
+		"app:\n  port: [1\n",
+		// rejected by the validator
+		"app:\n  port: -1\n",
+	} {
+		writeConfig(t, file, content)
+		select {
+		case err := <-errs:
+			assert.ExpectErr(t, err)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no error for %q", content)
+		}
+		// the previous config is kept
+		assert.ExpectEQ(t, uint64(1), w.Current().Version())
+		assert.ExpectEQ(t, 1, w.Current().Get("app.port"))
+	}
+	assert.ExpectEQ(t, int32(0), atomic.LoadInt32(&calls))
+
+	// recovered by a valid file
+	writeConfig(t, file, "app:\n  port: 2\n")
+	deadline := time.Now().Add(5 * time.Second)
+	for w.Current().Version() == 1 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.ExpectEQ(t, uint64(2), w.Current().Version())
+	assert.ExpectEQ(t, 2, w.Current().Get("app.port"))
+	assert.ExpectEQ(t, int32(1), atomic.LoadInt32(&calls))
+}
+
+func TestWatcherStopInSubscriber(t *testing.T) {
+	w, file := startWatcher(t, "app:\n  port: 1\n")
+	stopped := make(chan struct{})
+	w.Subscribe("app", func(ev ChangeEvent) {
+		w.Stop()
+		close(stopped)
+	})
+	assert.ExpectNoErr(t, w.Start())
+	writeConfig(t, file, "app:\n  port: 2\n")
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stop in a subscriber is blocked")
+	}
+	// stopped, the changes are not watched anymore
+	writeConfig(t, file, "app:\n  port: 3\n")
+	time.Sleep(3 * testDebounce)
+	assert.ExpectEQ(t, 2, w.Current().Get("app.port"))
+	w.Stop()
+}
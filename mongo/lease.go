@@ -0,0 +1,328 @@
+package mongo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/argcv/stork/log"
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+)
+
+var (
+	ErrLeaseNotHeld = errors.New("lease_not_held")
+	ErrLeaseLost    = errors.New("lease_lost")
+)
+
+// LeaseRecord is the document stored for each lease.
+// One lease is one document, whose _id is the name of the lease
+type LeaseRecord struct {
+	Name       string    `json:"name" bson:"_id"`
+	Owner      string    `json:"owner" bson:"owner"`
+	Token      int64     `json:"token" bson:"token"`
+	AcquiredAt time.Time `json:"acquired_at" bson:"acquired_at"`
+	ExpireAt   time.Time `json:"expire_at" bson:"expire_at"`
+}
+
+/**
+ * Lease is a simple distributed lock stored in a mongo collection
+ *
+ * Only one owner can hold a lease at a time. The holder must renew it
+ * before the ttl elapsed, otherwise any other candidate is able to take
+ * it over. Every successful acquisition increases the fencing token, so
+ * the downstream could reject the requests from a stale holder.
+ *
+ * NOTE: the expiry is evaluated using the local clock of each candidate,
+ * the clock skew between the replicas must be much smaller than the ttl
+ */
+type Lease struct {
+	client        *Client
+	coll          string
+	name          string
+	owner         string
+	ttl           time.Duration
+	renewInterval time.Duration
+
+	mu       sync.RWMutex // guards the following block
+	held     bool
+	token    int64
+	deadline time.Time // local deadline, the lease is treated as lost after it
+
+	runMu  sync.Mutex // guards the following block
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func defaultLeaseOwner() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return fmt.Sprintf("%s-%d-%s", host, os.Getpid(), NewObjectId().Hex())
+}
+
+// NewLease returns a lease named `name` stored in the collection `coll`
+// the default ttl is 10 seconds, and it is renewed every 1/3 ttl
+func (client *Client) NewLease(coll, name string) *Lease {
+	return &Lease{
+		client:        client,
+		coll:          coll,
+		name:          name,
+		owner:         defaultLeaseOwner(),
+		ttl:           10 * time.Second,
+		renewInterval: 0,
+	}
+}
+
+// SetOwner overrides the owner id, which should be unique among all the candidates
+func (l *Lease) SetOwner(owner string) *Lease {
+	l.owner = owner
+	return l
+}
+
+func (l *Lease) SetTTL(ttl time.Duration) *Lease {
+	l.ttl = ttl
+	return l
+}
+
+// SetRenewInterval sets the period of renewal/campaign in the background loop
+// It must be shorter than ttl, 0 means ttl/3
+func (l *Lease) SetRenewInterval(interval time.Duration) *Lease {
+	l.renewInterval = interval
+	return l
+}
+
+func (l *Lease) Name() string {
+	return l.name
+}
+
+func (l *Lease) Owner() string {
+	return l.owner
+}
+
+func (l *Lease) getRenewInterval() time.Duration {
+	if l.renewInterval > 0 {
+		return l.renewInterval
+	}
+	return l.ttl / 3
+}
+
+// IsLeader returns true if current candidate is holding the lease
+// and the local deadline is not reached
+func (l *Lease) IsLeader() bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.held && time.Now().Before(l.deadline)
+}
+
+// Token returns the fencing token of current holding, 0 if not held
+func (l *Lease) Token() int64 {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if !l.held {
+		return 0
+	}
+	return l.token
+}
+
+func (l *Lease) apply(query bson.M, change mgo.Change) (rec *LeaseRecord, err error) {
+	session := l.client.Session.Copy()
+	defer session.Close()
+	rec = &LeaseRecord{}
+	_, err = session.DB(l.client.Db).C(l.coll).Find(query).Apply(change, rec)
+	return
+}
+
+func (l *Lease) setHeld(token int64, deadline time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.held = true
+	l.token = token
+	l.deadline = deadline
+}
+
+func (l *Lease) setLost() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.held = false
+	l.token = 0
+}
+
+// TryAcquire is a non-blocking method
+// It takes the lease if it is free or expired, and returns true.
+// If we are holding it already, it will be renewed.
+func (l *Lease) TryAcquire() (bool, error) {
+	if l.Token() != 0 {
+		if err := l.Renew(); err == nil {
+			return true, nil
+		} else if err != ErrLeaseLost {
+			return false, err
+		}
+	}
+	now := time.Now()
+	query := bson.M{
+		"_id": l.name,
+		"$or": []bson.M{
+			{"expire_at": bson.M{"$lte": now}},
+			{"owner": l.owner},
+		},
+	}
+	change := mgo.Change{
+		Update: bson.M{
+			"$set": bson.M{
+				"owner":       l.owner,
+				"acquired_at": now,
+				"expire_at":   now.Add(l.ttl),
+			},
+			"$inc": bson.M{"token": 1},
+		},
+		Upsert:    true,
+		ReturnNew: true,
+	}
+	rec, err := l.apply(query, change)
+	if err != nil {
+		if mgo.IsDup(err) {
+			// the document exists, and it is held by another owner
+			return false, nil
+		}
+		return false, err
+	}
+	l.setHeld(rec.Token, now.Add(l.ttl))
+	return true, nil
+}
+
+// Renew extends the expiry of current holding
+// ErrLeaseLost is returned if the lease was taken over by someone else
+func (l *Lease) Renew() error {
+	token := l.Token()
+	if token == 0 {
+		return ErrLeaseNotHeld
+	}
+	now := time.Now()
+	query := bson.M{
+		"_id":   l.name,
+		"owner": l.owner,
+		"token": token,
+	}
+	change := mgo.Change{
+		Update:    bson.M{"$set": bson.M{"expire_at": now.Add(l.ttl)}},
+		ReturnNew: true,
+	}
+	if _, err := l.apply(query, change); err != nil {
+		if err == mgo.ErrNotFound {
+			l.setLost()
+			return ErrLeaseLost
+		}
+		return err
+	}
+	l.setHeld(token, now.Add(l.ttl))
+	return nil
+}
+
+// Release gives up current holding, so that another candidate could take
+// it over immediately. The document is kept to keep the token monotonic.
+func (l *Lease) Release() error {
+	token := l.Token()
+	if token == 0 {
+		return ErrLeaseNotHeld
+	}
+	l.setLost()
+	query := bson.M{
+		"_id":   l.name,
+		"owner": l.owner,
+		"token": token,
+	}
+	change := mgo.Change{
+		Update: bson.M{"$set": bson.M{"owner": "", "expire_at": time.Unix(0, 0)}},
+	}
+	if _, err := l.apply(query, change); err != nil && err != mgo.ErrNotFound {
+		return err
+	}
+	return nil
+}
+
+// Get returns the record stored in mongo
+func (l *Lease) Get() (rec *LeaseRecord, err error) {
+	rec = &LeaseRecord{}
+	session := l.client.Session.Copy()
+	defer session.Close()
+	err = session.DB(l.client.Db).C(l.coll).FindId(l.name).One(rec)
+	return
+}
+
+func (l *Lease) campaign() {
+	wasLeader := l.Token() != 0
+	if wasLeader {
+		if err := l.Renew(); err == nil {
+			return
+		} else if err != ErrLeaseLost {
+			log.Warnf("Lease-%s(%s) renew failed: %v", l.name, l.owner, err)
+			if !l.IsLeader() {
+				// local deadline reached, we are not sure if we are still holding it
+				l.setLost()
+			}
+			return
+		}
+		log.Infof("Lease-%s(%s) lost", l.name, l.owner)
+	}
+	if ok, err := l.TryAcquire(); err != nil {
+		log.Warnf("Lease-%s(%s) acquire failed: %v", l.name, l.owner, err)
+	} else if ok {
+		log.Infof("Lease-%s(%s) acquired, token: %v", l.name, l.owner, l.Token())
+	}
+}
+
+// Start launches a background loop, which keeps renewing the lease if
+// we are holding it, or tries to take it over when it is expired
+func (l *Lease) Start(ctx context.Context) error {
+	l.runMu.Lock()
+	defer l.runMu.Unlock()
+	if l.cancel != nil {
+		return errors.New("already_started")
+	}
+	if l.getRenewInterval() <= 0 || l.getRenewInterval() >= l.ttl {
+		return errors.New("invalid_renew_interval")
+	}
+	cctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	l.cancel = cancel
+	l.done = done
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(l.getRenewInterval())
+		defer ticker.Stop()
+		l.campaign()
+		for {
+			select {
+			case <-cctx.Done():
+				if l.Token() != 0 {
+					if err := l.Release(); err != nil {
+						log.Warnf("Lease-%s(%s) release failed: %v", l.name, l.owner, err)
+					}
+				}
+				return
+			case <-ticker.C:
+				l.campaign()
+			}
+		}
+	}()
+	return nil
+}
+
+// Stop terminates the background loop and releases the lease
+func (l *Lease) Stop() error {
+	l.runMu.Lock()
+	defer l.runMu.Unlock()
+	if l.cancel == nil {
+		return errors.New("not_started")
+	}
+	l.cancel()
+	<-l.done
+	l.cancel = nil
+	l.done = nil
+	return nil
+}
@@ -2,40 +2,87 @@
 package mtx
 
 import (
-	"github.com/argcv/stork/cntr"
-	"github.com/argcv/stork/log"
-	"runtime"
+	"container/list"
+	"context"
 	"sync"
 	"time"
+
+	"github.com/argcv/stork/cntr"
 )
 
+// csEntry is the state of one locked entry
+// it is either held by one writer, or shared by a few readers
+type csEntry struct {
+	writer  bool
+	readers int
+	holders []*csHolder
+}
+
+type csWaiter struct {
+	entries []string
+	shared  bool
+	owner   lockOwner
+	ready   chan struct{} // closed once granted
+	granted bool
+	elem    *list.Element
+}
+
 /**
  * CriticalSection Provides a thread safe set, so that
  * we can define a set of lockers in one object
  *
+ * Each entry could be locked exclusively (Lock/Release) or
+ * shared (RLock/RRelease) by many readers at the same time.
  *
+ * The blocking lockers are granted in FIFO order: a waiter will never
+ * be overtaken by a later waiter, or a TryLock* / TryRLock* call, who
+ * requires any of the same entries in a conflicting mode, so the writers
+ * are not starved by the readers.
+ * All the entries of one waiter are acquired at once, so it never holds
+ * a part of them while waiting for the rest, which avoids the deadlock
+ * between the multi-entry lockers.
  */
 type CriticalSection struct {
-	m  sync.Mutex // global mutex
-	mj sync.Mutex // mutex for joining
-	d  map[string]bool
+	name    string
+	m       sync.Mutex // global mutex
+	d       map[string]*csEntry
+	wq      *list.List // waiting queue of *csWaiter
+	tracked bool       // registered for diagnostics
 }
 
 func NewCriticalSection() *CriticalSection {
 	return &CriticalSection{
-		m:  sync.Mutex{},
-		mj: sync.Mutex{},
-		d:  map[string]bool{},
+		name: newSectionName(),
+		m:    sync.Mutex{},
+		d:    map[string]*csEntry{},
+		wq:   list.New(),
 	}
 }
 
+// NewNamedCriticalSection creates a critical section with a name,
+// which is used in the diagnostics
+func NewNamedCriticalSection(name string) *CriticalSection {
+	c := NewCriticalSection()
+	c.name = name
+	return c
+}
+
+func (c *CriticalSection) Name() string {
+	return c.name
+}
+
 /**
  * check and release one id
  */
 func (c *CriticalSection) ReleaseOne(entry string) {
-	c.m.Lock()
-	defer c.m.Unlock()
-	delete(c.d, entry)
+	c.Release(entry)
+}
+
+/**
+ * release one shared holding of the entry
+ */
+func (c *CriticalSection) RReleaseOne(entry string) {
+	c.RRelease(entry)
 }
 
 /**
@@ -45,20 +92,39 @@ func (c *CriticalSection) ReleaseOne(entry string) {
  * otherwise do nothing and return false
  */
 func (c *CriticalSection) TryLockAll(entries ...string) bool {
+	return c.tryLockAll(false, entries...)
+}
+
+/**
+ * This is a non-blocking method.
+ * Given a set of entries, if none of them is exclusively locked
+ * lock all in shared mode and return true
+ * otherwise do nothing and return false
+ */
+func (c *CriticalSection) TryRLockAll(entries ...string) bool {
+	return c.tryLockAll(true, entries...)
+}
+
+func (c *CriticalSection) tryLockAll(shared bool, entries ...string) bool {
+	entries = cntr.DistinctStrings(entries...)
+	owner := newLockOwner()
+
 	c.m.Lock()
 	defer c.m.Unlock()
 
-	// check entries, make sure all of them are unlocked
+	// check entries, make sure all of them are available
+	wantX, wantS := c.unsafeQueuedWants()
 	for _, entry := range entries {
-		if c.unsafeIsLocked(entry) {
+		if !c.unsafeCanTake(entry, shared, wantX, wantS) {
 			return false
 		}
 	}
 
 	// lock all
 	for _, entry := range entries {
-		c.d[entry] = true
+		c.unsafeAcquire(entry, shared, owner)
 	}
+	c.unsafeTrack()
 	return true
 }
 
@@ -68,73 +134,189 @@ func (c *CriticalSection) TryLockAll(entries ...string) bool {
  * try to lock some of the entries, and return the locked items
  */
 func (c *CriticalSection) TryLockPartial(entries ...string) (locked []string) {
+	return c.tryLockPartial(false, entries...)
+}
+
+/**
+ * This is a non-blocking method.
+ * Given a set of entries
+ * try to lock some of the entries in shared mode, and return the locked items
+ */
+func (c *CriticalSection) TryRLockPartial(entries ...string) (locked []string) {
+	return c.tryLockPartial(true, entries...)
+}
+
+func (c *CriticalSection) tryLockPartial(shared bool, entries ...string) (locked []string) {
+	entries = cntr.DistinctStrings(entries...)
+	owner := newLockOwner()
+
 	c.m.Lock()
 	defer c.m.Unlock()
 
-	// check entries, make sure all of them are unlocked
+	wantX, wantS := c.unsafeQueuedWants()
 	for _, entry := range entries {
-		if !c.unsafeIsLocked(entry) {
-			// is NOT locked
-
+		if c.unsafeCanTake(entry, shared, wantX, wantS) {
 			// lock it
-			c.d[entry] = true
+			c.unsafeAcquire(entry, shared, owner)
 
 			// add to locked list
 			locked = append(locked, entry)
 		}
 	}
+	c.unsafeTrack()
 
 	return
 }
 
 /**
  * Caution: This is a blocking method
- * Wait until all the functions are locked
- * Maybe it is not a good idea..?
+ * Wait until all the entries are locked
  */
 func (c *CriticalSection) Join(entries ...string) {
-	c.mj.Lock()
-	defer c.mj.Unlock()
+	c.Lock(entries...)
+}
+
+/**
+ * Caution: This is a blocking method
+ * Wait until all the entries are locked
+ */
+func (c *CriticalSection) Lock(entries ...string) {
+	_ = c.LockContext(context.Background(), entries...)
+}
+
+/**
+ * Wait until all the entries are locked, or the timeout reached.
+ * Nothing is locked if an error is returned
+ */
+func (c *CriticalSection) LockTimeout(timeout time.Duration, entries ...string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return c.LockContext(ctx, entries...)
+}
+
+/**
+ * Wait until all the entries are locked, or the context is done.
+ * Nothing is locked if an error is returned
+ */
+func (c *CriticalSection) LockContext(ctx context.Context, entries ...string) error {
+	return c.lockContext(ctx, false, entries...)
+}
 
-	var locked []string
-	timeIn := time.Now()
-	timeLast := timeIn
+/**
+ * Caution: This is a blocking method
+ * Wait until all the entries are locked in shared mode
+ */
+func (c *CriticalSection) RLock(entries ...string) {
+	_ = c.RLockContext(context.Background(), entries...)
+}
+
+/**
+ * Wait until all the entries are locked in shared mode, or the timeout reached.
+ * Nothing is locked if an error is returned
+ */
+func (c *CriticalSection) RLockTimeout(timeout time.Duration, entries ...string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return c.RLockContext(ctx, entries...)
+}
+
+/**
+ * Wait until all the entries are locked in shared mode, or the context is done.
+ * Nothing is locked if an error is returned
+ */
+func (c *CriticalSection) RLockContext(ctx context.Context, entries ...string) error {
+	return c.lockContext(ctx, true, entries...)
+}
 
+func (c *CriticalSection) lockContext(ctx context.Context, shared bool, entries ...string) error {
 	entries = cntr.DistinctStrings(entries...)
+	if len(entries) == 0 {
+		return ctx.Err()
+	}
 
-	for len(locked) < len(entries) {
-		//log.Infof("from: %v", len(locked))
-		clocked := c.TryLockPartial(entries...)
-		locked = append(locked, clocked...)
-		//log.Infof("to: %v", len(locked))
-		runtime.Gosched()
-
-		timeCurr := time.Now()
-		if timeCurr.Sub(timeLast) > 3*time.Second {
-			mCaptured := map[string]bool{}
-			var waiting []string
-			for _, entry := range locked {
-				mCaptured[entry] = true
-			}
-			for _, entry := range entries {
-				if _, ok := mCaptured[entry]; !ok {
-					waiting = append(waiting, entry)
+	w := &csWaiter{
+		entries: entries,
+		shared:  shared,
+		owner:   newLockOwner(),
+		ready:   make(chan struct{}),
+	}
+	c.m.Lock()
+	w.elem = c.wq.PushBack(w)
+	c.unsafeDispatch()
+	if w.granted {
+		c.unsafeTrack()
+		c.m.Unlock()
+		return nil
+	}
+	c.unsafeTrack()
+	c.m.Unlock()
+
+	ticker := time.NewTicker(3 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.ready:
+			return nil
+		case <-ctx.Done():
+			c.m.Lock()
+			defer c.m.Unlock()
+			if w.granted {
+				// granted right before the cancellation, give it back
+				for _, entry := range w.entries {
+					c.unsafeRelease(entry, shared, w.owner.goroutine)
 				}
+			} else {
+				c.wq.Remove(w.elem)
 			}
+			// the following waiters may be blocked by this one
+			c.unsafeDispatch()
+			c.unsafeTrack()
+			return ctx.Err()
+		case <-ticker.C:
+			c.warnLongWait(w)
+		}
+	}
+}
 
-			n := 3
-			if len(waiting) < 3 {
-				n = len(waiting)
+/**
+ * an internal function, grant the waiters in FIFO order
+ * a waiter is granted if all of its entries are available, and
+ * none of them is required by an earlier waiter in a conflicting mode
+ */
+func (c *CriticalSection) unsafeDispatch() {
+	var wantX, wantS map[string]bool // entries wanted by earlier waiters
+	for e := c.wq.Front(); e != nil; {
+		next := e.Next()
+		w := e.Value.(*csWaiter)
+		available := true
+		for _, entry := range w.entries {
+			if !c.unsafeCanTake(entry, w.shared, wantX, wantS) {
+				available = false
+				break
+			}
+		}
+		if available {
+			owner := w.owner
+			owner.since = time.Now()
+			for _, entry := range w.entries {
+				c.unsafeAcquire(entry, w.shared, owner)
+			}
+			w.granted = true
+			close(w.ready)
+			c.wq.Remove(e)
+		} else {
+			if wantX == nil {
+				wantX, wantS = map[string]bool{}, map[string]bool{}
+			}
+			for _, entry := range w.entries {
+				if w.shared {
+					wantS[entry] = true
+				} else {
+					wantX[entry] = true
+				}
 			}
-			log.Warnf("Possible Deadlock!!! Start Time: %v, obtaining size: %v, missing: %v... in total %v entries",
-				timeIn,
-				len(entries),
-				waiting[:n],
-				len(waiting),
-			)
-
-			timeLast = timeCurr
 		}
+		e = next
 	}
 }
 
@@ -142,9 +324,83 @@ func (c *CriticalSection) Join(entries ...string) {
  * release all the entries
  */
 func (c *CriticalSection) Release(entries ...string) {
+	c.m.Lock()
+	defer c.m.Unlock()
 	for _, entry := range entries {
-		c.ReleaseOne(entry)
+		c.unsafeRelease(entry, false, 0)
 	}
+	c.unsafeDispatch()
+	c.unsafeTrack()
+}
+
+/**
+ * release one shared holding of all the entries
+ */
+func (c *CriticalSection) RRelease(entries ...string) {
+	gid := currentGoroutineId()
+
+	c.m.Lock()
+	defer c.m.Unlock()
+	for _, entry := range cntr.DistinctStrings(entries...) {
+		c.unsafeRelease(entry, true, gid)
+	}
+	c.unsafeDispatch()
+	c.unsafeTrack()
+}
+
+/**
+ * This is a non-blocking method.
+ * Upgrade the shared holdings to exclusive ones, it only succeeds if
+ * each of the entries has exactly one reader. The owners are only known
+ * with the lock diagnostics (see EnableLockDiagnostics), which also
+ * requires the reader to be the caller's goroutine.
+ * Nothing is changed if false is returned.
+ *
+ * There is no blocking version, since two readers waiting for upgrading
+ * the same entry would wait for each other forever.
+ */
+func (c *CriticalSection) TryUpgrade(entries ...string) bool {
+	entries = cntr.DistinctStrings(entries...)
+	gid := currentGoroutineId()
+
+	c.m.Lock()
+	defer c.m.Unlock()
+	for _, entry := range entries {
+		st, ok := c.d[entry]
+		if !ok || st.writer || st.readers != 1 {
+			return false
+		}
+		if owner := st.holders[0].goroutine; gid != 0 && owner != 0 && owner != gid {
+			// someone else's
+			return false
+		}
+	}
+	for _, entry := range entries {
+		st := c.d[entry]
+		st.readers = 0
+		st.writer = true
+		st.holders[0].shared = false
+	}
+	c.unsafeTrack()
+	return true
+}
+
+/**
+ * Downgrade the exclusive holdings to shared ones, so that the other
+ * readers could join. The entries not exclusively locked are ignored.
+ */
+func (c *CriticalSection) Downgrade(entries ...string) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	for _, entry := range cntr.DistinctStrings(entries...) {
+		if st, ok := c.d[entry]; ok && st.writer {
+			st.writer = false
+			st.readers = 1
+			st.holders[0].shared = true
+		}
+	}
+	c.unsafeDispatch()
+	c.unsafeTrack()
 }
 
 /**
@@ -169,6 +425,32 @@ func (c *CriticalSection) Locked(entries ...string) bool {
 	return !c.Check(entries...)
 }
 
+/**
+ * return true if at least 1 of the entries are exclusively locked
+ */
+func (c *CriticalSection) WLocked(entries ...string) bool {
+	c.m.Lock()
+	defer c.m.Unlock()
+	for _, entry := range entries {
+		if st, ok := c.d[entry]; ok && st.writer {
+			return true
+		}
+	}
+	return false
+}
+
+/**
+ * return the number of the readers of an entry
+ */
+func (c *CriticalSection) Readers(entry string) int {
+	c.m.Lock()
+	defer c.m.Unlock()
+	if st, ok := c.d[entry]; ok {
+		return st.readers
+	}
+	return 0
+}
+
 /**
  * an internal function, a simple helper to check the status of
  * data set. return true if this entry already exists right now
@@ -177,3 +459,101 @@ func (c *CriticalSection) unsafeIsLocked(entry string) bool {
 	_, ok := c.d[entry]
 	return ok
 }
+
+/**
+ * an internal function, return true if the entry could be locked
+ * in the given mode right now
+ */
+func (c *CriticalSection) unsafeIsAvailable(entry string, shared bool) bool {
+	st, ok := c.d[entry]
+	if !ok {
+		return true
+	}
+	return shared && !st.writer
+}
+
+/**
+ * an internal function, return true if the entry could be locked in the
+ * given mode right now, without overtaking the waiters wanting it in a
+ * conflicting mode
+ */
+func (c *CriticalSection) unsafeCanTake(entry string, shared bool, wantX, wantS map[string]bool) bool {
+	return c.unsafeIsAvailable(entry, shared) && !wantX[entry] && (shared || !wantS[entry])
+}
+
+// unsafeQueuedWants returns the entries wanted by the waiters in the queue,
+// exclusively or shared
+func (c *CriticalSection) unsafeQueuedWants() (wantX, wantS map[string]bool) {
+	if c.wq.Len() == 0 {
+		return
+	}
+	wantX, wantS = map[string]bool{}, map[string]bool{}
+	for e := c.wq.Front(); e != nil; e = e.Next() {
+		w := e.Value.(*csWaiter)
+		for _, entry := range w.entries {
+			if w.shared {
+				wantS[entry] = true
+			} else {
+				wantX[entry] = true
+			}
+		}
+	}
+	return
+}
+
+func (c *CriticalSection) unsafeAcquire(entry string, shared bool, owner lockOwner) {
+	st, ok := c.d[entry]
+	if !ok {
+		st = &csEntry{}
+		c.d[entry] = st
+	}
+	if shared {
+		st.readers++
+	} else {
+		st.writer = true
+	}
+	st.holders = append(st.holders, &csHolder{
+		lockOwner: owner,
+		shared:    shared,
+	})
+}
+
+// unsafeRelease releases one holding of the entry
+// for a shared entry, the holding of goroutine `gid` is preferred
+// a mismatched release (e.g. Release of a shared entry) is ignored
+func (c *CriticalSection) unsafeRelease(entry string, shared bool, gid int64) {
+	st, ok := c.d[entry]
+	if !ok {
+		return
+	}
+	if shared {
+		if st.readers == 0 {
+			return
+		}
+		st.readers--
+	} else {
+		if !st.writer {
+			return
+		}
+		st.writer = false
+	}
+	idx := -1
+	for i, h := range st.holders {
+		if h.shared != shared {
+			continue
+		}
+		if idx < 0 {
+			idx = i
+		}
+		if shared && gid != 0 && h.goroutine == gid {
+			idx = i
+			break
+		}
+	}
+	if idx >= 0 {
+		st.holders = append(st.holders[:idx], st.holders[idx+1:]...)
+	}
+	if !st.writer && st.readers == 0 {
+		delete(c.d, entry)
+	}
+}
@@ -1,6 +1,8 @@
 package mtx
 
 import (
+	"context"
+	"sync"
 	"testing"
 	"time"
 )
@@ -158,3 +160,325 @@ func TestCriticalSection_TryLockPartial(t *testing.T) {
 	}
 
 }
+
+func TestCriticalSection_LockContext(t *testing.T) {
+	cs := NewCriticalSection()
+	cs.Lock("aa")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := cs.LockContext(ctx, "aa", "bb"); err != context.DeadlineExceeded {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if cs.Locked("bb") {
+		t.Errorf("bb should not be locked after timeout")
+	}
+
+	if err := cs.LockTimeout(10*time.Millisecond, "aa"); err == nil {
+		t.Errorf("lock should be timeout")
+	}
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		cs.Release("aa")
+	}()
+	if err := cs.LockTimeout(1*time.Second, "aa", "bb"); err != nil {
+		t.Errorf("lock failed: %v", err)
+	}
+	if cs.Check("aa") || cs.Check("bb") {
+		t.Errorf("lock failed")
+	}
+}
+
+func TestCriticalSection_LockFairness(t *testing.T) {
+	cs := NewCriticalSection()
+	cs.Lock("aa")
+
+	order := make(chan string, 2)
+	go func() {
+		// waiting for both aa and bb
+		cs.Lock("aa", "bb")
+		order <- "aa+bb"
+		cs.Release("aa", "bb")
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	go func() {
+		// bb is free, but an earlier waiter requires it
+		cs.Lock("bb")
+		order <- "bb"
+		cs.Release("bb")
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	if cs.Locked("bb") {
+		t.Errorf("bb should not be taken by the later waiter")
+	}
+	cs.Release("aa")
+
+	if first := <-order; first != "aa+bb" {
+		t.Errorf("unexpected order: %v", first)
+	}
+	<-order
+}
+
+func TestCriticalSection_LockUnrelated(t *testing.T) {
+	cs := NewCriticalSection()
+	cs.Lock("aa")
+
+	go func() {
+		// waiting for aa
+		cs.Lock("aa", "bb")
+		cs.Release("aa", "bb")
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	// a waiter never blocks the entries it does not require
+	if err := cs.LockTimeout(100*time.Millisecond, "cc"); err != nil {
+		t.Errorf("cc should be free: %v", err)
+	}
+	if !cs.TryLockAll("dd") {
+		t.Errorf("dd should be free")
+	}
+	cs.Release("cc", "dd")
+	cs.Release("aa")
+}
+
+func TestCriticalSection_TryLockQueued(t *testing.T) {
+	cs := NewCriticalSection()
+	cs.RLock("aa")
+
+	locked := make(chan struct{})
+	go func() {
+		// the queued writer
+		cs.Lock("aa")
+		close(locked)
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	// the readers never overtake the queued writer
+	for i := 0; i < 10; i++ {
+		if cs.TryRLockAll("aa") {
+			t.Fatalf("TryRLockAll should not overtake the queued writer")
+		}
+		if locked := cs.TryRLockPartial("aa", "bb"); len(locked) != 1 || locked[0] != "bb" {
+			t.Fatalf("unexpected locked: %v", locked)
+		}
+		cs.RRelease("bb")
+	}
+	if cs.TryLockAll("aa") {
+		t.Errorf("TryLockAll should not overtake the queued writer")
+	}
+
+	cs.RRelease("aa")
+	select {
+	case <-locked:
+	case <-time.After(time.Second):
+		t.Fatalf("the queued writer is starved")
+	}
+	cs.Release("aa")
+
+	// a queued reader is not overtaken by TryLockAll either
+	cs.Lock("bb")
+	go func() {
+		// aa is free, but bb is not
+		cs.RLock("aa", "bb")
+	}()
+	time.Sleep(10 * time.Millisecond)
+	if cs.TryLockAll("aa") {
+		t.Errorf("TryLockAll should not overtake the queued reader")
+	}
+	if !cs.TryRLockAll("aa") {
+		t.Errorf("TryRLockAll should succeed")
+	}
+	cs.Release("bb")
+	time.Sleep(10 * time.Millisecond)
+	if cs.Readers("aa") != 2 || cs.Readers("bb") != 1 {
+		t.Errorf("the queued reader should be granted")
+	}
+}
+
+func TestCriticalSection_LockConcurrent(t *testing.T) {
+	cs := NewCriticalSection()
+	wg := sync.WaitGroup{}
+	cnt := map[string]int{}
+	keys := []string{"aa", "bb", "cc"}
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		// acquire in different orders
+		ks := []string{keys[i%3], keys[(i+1)%3]}
+		if i%2 == 0 {
+			ks[0], ks[1] = ks[1], ks[0]
+		}
+		go func() {
+			defer wg.Done()
+			cs.Lock(ks...)
+			for _, k := range ks {
+				cnt[k]++
+			}
+			cs.Release(ks...)
+		}()
+	}
+	wg.Wait()
+	if cnt["aa"]+cnt["bb"]+cnt["cc"] != 100 {
+		t.Errorf("unexpected count: %v", cnt)
+	}
+}
+
+func TestCriticalSection_RLock(t *testing.T) {
+	cs := NewCriticalSection()
+	cs.RLock("aa")
+	cs.RLock("aa", "bb")
+
+	if cs.Readers("aa") != 2 || cs.Readers("bb") != 1 {
+		t.Errorf("incorrect readers: %v %v", cs.Readers("aa"), cs.Readers("bb"))
+	}
+	if cs.WLocked("aa") {
+		t.Errorf("incorrectly write locked")
+	}
+	if cs.TryLockAll("aa") {
+		t.Errorf("write lock should fail while reading")
+	}
+	if locked := cs.TryRLockPartial("aa", "cc"); len(locked) != 2 {
+		t.Errorf("incorrect partial locked: %v", locked)
+	}
+	cs.RRelease("aa", "cc")
+
+	if err := cs.LockTimeout(10*time.Millisecond, "aa"); err == nil {
+		t.Errorf("write lock should be timeout")
+	}
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		cs.RRelease("aa", "bb")
+		cs.RReleaseOne("aa")
+	}()
+	cs.Lock("aa")
+	if !cs.WLocked("aa") {
+		t.Errorf("write lock failed")
+	}
+	if cs.TryRLockAll("aa") {
+		t.Errorf("read lock should fail while writing")
+	}
+	if err := cs.RLockTimeout(10*time.Millisecond, "aa"); err == nil {
+		t.Errorf("read lock should be timeout")
+	}
+	cs.Release("aa")
+	if cs.Locked("aa", "bb") {
+		t.Errorf("incorrectly locked")
+	}
+}
+
+func TestCriticalSection_MismatchedRelease(t *testing.T) {
+	cs := NewCriticalSection()
+	cs.RLock("a")
+	// an exclusive release of a shared entry is ignored
+	cs.Release("a")
+	if cs.Readers("a") != 1 {
+		t.Errorf("incorrect readers: %v", cs.Readers("a"))
+	}
+	if holders, _ := cs.Dump(); len(holders) != 1 {
+		t.Errorf("incorrect holders: %v", holders)
+	}
+	cs.RRelease("a")
+	if cs.Locked("a") {
+		t.Errorf("incorrectly locked")
+	}
+
+	cs.Lock("b")
+	// and vice versa
+	cs.RRelease("b")
+	if !cs.WLocked("b") {
+		t.Errorf("write lock lost")
+	}
+	cs.Release("b")
+	cs.Release("b")
+	if holders, _ := cs.Dump(); cs.Locked("b") || len(holders) != 0 {
+		t.Errorf("incorrectly locked: %v", holders)
+	}
+}
+
+func TestCriticalSection_RLockWriterPreferred(t *testing.T) {
+	cs := NewCriticalSection()
+	cs.RLock("aa")
+
+	order := make(chan string, 2)
+	go func() {
+		cs.Lock("aa")
+		order <- "writer"
+		cs.Release("aa")
+	}()
+	time.Sleep(10 * time.Millisecond)
+	go func() {
+		// a writer is waiting, the new reader must queue up
+		cs.RLock("aa")
+		order <- "reader"
+		cs.RRelease("aa")
+	}()
+	time.Sleep(10 * time.Millisecond)
+	if cs.Readers("aa") != 1 {
+		t.Errorf("the later reader overtook the writer")
+	}
+	cs.RRelease("aa")
+	if first := <-order; first != "writer" {
+		t.Errorf("unexpected order: %v", first)
+	}
+	<-order
+}
+
+func TestCriticalSection_UpgradeDowngrade(t *testing.T) {
+	cs := NewCriticalSection()
+	cs.RLock("aa", "bb")
+	cs.RLock("bb")
+
+	if cs.TryUpgrade("aa", "bb") {
+		t.Errorf("upgrade should fail with 2 readers")
+	}
+	if cs.WLocked("aa") {
+		t.Errorf("partially upgraded")
+	}
+	cs.RRelease("bb")
+	if !cs.TryUpgrade("aa", "bb") {
+		t.Errorf("upgrade failed")
+	}
+	if !cs.WLocked("aa", "bb") {
+		t.Errorf("upgrade failed")
+	}
+
+	done := make(chan bool)
+	go func() {
+		cs.RLock("aa")
+		done <- true
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cs.Downgrade("aa")
+	<-done
+	if cs.Readers("aa") != 2 {
+		t.Errorf("incorrect readers: %v", cs.Readers("aa"))
+	}
+}
+
+func TestCriticalSection_UpgradeOwner(t *testing.T) {
+	EnableLockDiagnostics(true)
+	defer EnableLockDiagnostics(false)
+
+	cs := NewCriticalSection()
+	cs.RLock("aa")
+	upgraded := make(chan bool)
+	go func() {
+		upgraded <- cs.TryUpgrade("aa")
+	}()
+	if <-upgraded {
+		t.Errorf("the read lock of another goroutine is upgraded")
+	}
+	if cs.WLocked("aa") || cs.Readers("aa") != 1 {
+		t.Errorf("the read lock is changed")
+	}
+	if !cs.TryUpgrade("aa") {
+		t.Errorf("upgrade failed")
+	}
+	cs.Release("aa")
+	if cs.Locked("aa") {
+		t.Errorf("release failed")
+	}
+}
@@ -0,0 +1,414 @@
+package mtx
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/argcv/stork/log"
+)
+
+var (
+	diagnosticsEnabled int32
+	sectionCounter     int64
+
+	// the critical sections with holders or waiters
+	// only tracked while the diagnostics are enabled
+	sectionRegistry   = map[*CriticalSection]struct{}{}
+	sectionRegistryMx = sync.Mutex{}
+
+	packageDir = func() string {
+		_, file, _, _ := runtime.Caller(0)
+		return filepath.Dir(file)
+	}()
+)
+
+// EnableLockDiagnostics turns on/off recording the goroutine and caller
+// site of each holder and waiter, and building the wait-for graph across
+// all the critical sections. It is off by default, since capturing the
+// goroutine id and caller site is NOT free.
+func EnableLockDiagnostics(on bool) {
+	if on {
+		atomic.StoreInt32(&diagnosticsEnabled, 1)
+	} else {
+		atomic.StoreInt32(&diagnosticsEnabled, 0)
+	}
+}
+
+func IsLockDiagnosticsEnabled() bool {
+	return atomic.LoadInt32(&diagnosticsEnabled) == 1
+}
+
+func newSectionName() string {
+	return fmt.Sprintf("cs#%d", atomic.AddInt64(&sectionCounter, 1))
+}
+
+// lockOwner describes who is acquiring a lock
+// goroutine and caller are only recorded while the diagnostics are enabled
+type lockOwner struct {
+	goroutine int64
+	caller    string
+	since     time.Time
+}
+
+func (o lockOwner) String() string {
+	if o.goroutine == 0 {
+		return fmt.Sprintf("<unknown> since %v", o.since.Format(time.RFC3339Nano))
+	}
+	return fmt.Sprintf("goroutine %d at %s since %v", o.goroutine, o.caller, o.since.Format(time.RFC3339Nano))
+}
+
+type csHolder struct {
+	lockOwner
+	shared bool
+}
+
+func newLockOwner() lockOwner {
+	o := lockOwner{since: time.Now()}
+	if IsLockDiagnosticsEnabled() {
+		o.goroutine = currentGoroutineId()
+		o.caller = callerOutsidePackage()
+	}
+	return o
+}
+
+// currentGoroutineId returns the id of current goroutine
+// 0 is returned if the diagnostics are disabled
+func currentGoroutineId() int64 {
+	if !IsLockDiagnosticsEnabled() {
+		return 0
+	}
+	buf := make([]byte, 64)
+	buf = buf[:runtime.Stack(buf, false)]
+	// goroutine 123 [running]: ...
+	buf = bytes.TrimPrefix(buf, []byte("goroutine "))
+	if i := bytes.IndexByte(buf, ' '); i > 0 {
+		buf = buf[:i]
+	}
+	id, _ := strconv.ParseInt(string(buf), 10, 64)
+	return id
+}
+
+// callerOutsidePackage returns the first caller site out of mtx
+func callerOutsidePackage() string {
+	pcs := make([]uintptr, 16)
+	n := runtime.Callers(2, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+	for {
+		frame, more := frames.Next()
+		if filepath.Dir(frame.File) != packageDir || strings.HasSuffix(frame.File, "_test.go") {
+			return fmt.Sprintf("%s:%d", frame.File, frame.Line)
+		}
+		if !more {
+			return "<unknown>"
+		}
+	}
+}
+
+// unsafeTrack registers the section if it is in use, and unregisters
+// it once it is idle
+func (c *CriticalSection) unsafeTrack() {
+	inUse := len(c.d) > 0 || c.wq.Len() > 0
+	if inUse == c.tracked || (inUse && !IsLockDiagnosticsEnabled()) {
+		return
+	}
+	sectionRegistryMx.Lock()
+	defer sectionRegistryMx.Unlock()
+	if inUse {
+		sectionRegistry[c] = struct{}{}
+	} else {
+		delete(sectionRegistry, c)
+	}
+	c.tracked = inUse
+}
+
+// LockHolder describes one holding of an entry
+type LockHolder struct {
+	Section   string    `json:"section"`
+	Entry     string    `json:"entry"`
+	Shared    bool      `json:"shared"`
+	Goroutine int64     `json:"goroutine,omitempty"`
+	Caller    string    `json:"caller,omitempty"`
+	Since     time.Time `json:"since"`
+}
+
+// LockWaiter describes one blocking locker
+type LockWaiter struct {
+	Section   string    `json:"section"`
+	Entries   []string  `json:"entries"`
+	Shared    bool      `json:"shared"`
+	Goroutine int64     `json:"goroutine,omitempty"`
+	Caller    string    `json:"caller,omitempty"`
+	Since     time.Time `json:"since"`
+}
+
+// WaitEdge means goroutine `From` is waiting for goroutine `To`, because
+// of the entry in the section
+type WaitEdge struct {
+	From    int64  `json:"from"`
+	To      int64  `json:"to"`
+	Section string `json:"section"`
+	Entry   string `json:"entry"`
+}
+
+func (e WaitEdge) String() string {
+	return fmt.Sprintf("goroutine %d -(%s/%s)-> goroutine %d", e.From, e.Section, e.Entry, e.To)
+}
+
+type LockDump struct {
+	Time      time.Time    `json:"time"`
+	Holders   []LockHolder `json:"holders"`
+	Waiters   []LockWaiter `json:"waiters"`
+	Deadlocks [][]WaitEdge `json:"deadlocks"`
+}
+
+// Dump returns the holders and the waiters (in FIFO order) of this section
+func (c *CriticalSection) Dump() (holders []LockHolder, waiters []LockWaiter) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	for entry, st := range c.d {
+		for _, h := range st.holders {
+			holders = append(holders, LockHolder{
+				Section:   c.name,
+				Entry:     entry,
+				Shared:    h.shared,
+				Goroutine: h.goroutine,
+				Caller:    h.caller,
+				Since:     h.since,
+			})
+		}
+	}
+	sort.Slice(holders, func(i, j int) bool {
+		return holders[i].Entry < holders[j].Entry
+	})
+	for e := c.wq.Front(); e != nil; e = e.Next() {
+		w := e.Value.(*csWaiter)
+		waiters = append(waiters, LockWaiter{
+			Section:   c.name,
+			Entries:   append([]string{}, w.entries...),
+			Shared:    w.shared,
+			Goroutine: w.owner.goroutine,
+			Caller:    w.owner.caller,
+			Since:     w.owner.since,
+		})
+	}
+	return
+}
+
+// DumpLocks returns the holders, the waiters and the deadlocks of all
+// the critical sections in use. Only the sections used while the
+// diagnostics are enabled are included.
+// The sections are dumped one by one, so it is NOT an atomic snapshot
+func DumpLocks() (dump LockDump) {
+	sectionRegistryMx.Lock()
+	sections := make([]*CriticalSection, 0, len(sectionRegistry))
+	for c := range sectionRegistry {
+		sections = append(sections, c)
+	}
+	sectionRegistryMx.Unlock()
+	sort.Slice(sections, func(i, j int) bool {
+		return sections[i].name < sections[j].name
+	})
+
+	dump.Time = time.Now()
+	for _, c := range sections {
+		holders, waiters := c.Dump()
+		dump.Holders = append(dump.Holders, holders...)
+		dump.Waiters = append(dump.Waiters, waiters...)
+	}
+	dump.Deadlocks = findCycles(buildWaitForGraph(dump.Holders, dump.Waiters))
+	return
+}
+
+// FindDeadlocks returns the cycles in the wait-for graph across all the
+// critical sections
+func FindDeadlocks() [][]WaitEdge {
+	return DumpLocks().Deadlocks
+}
+
+func conflicts(shared1, shared2 bool) bool {
+	return !shared1 || !shared2
+}
+
+func buildWaitForGraph(holders []LockHolder, waiters []LockWaiter) map[int64][]WaitEdge {
+	type key struct{ section, entry string }
+	held := map[key][]LockHolder{}
+	for _, h := range holders {
+		k := key{h.Section, h.Entry}
+		held[k] = append(held[k], h)
+	}
+	graph := map[int64][]WaitEdge{}
+	addEdge := func(from, to int64, section, entry string) {
+		if from == 0 || to == 0 {
+			return
+		}
+		graph[from] = append(graph[from], WaitEdge{From: from, To: to, Section: section, Entry: entry})
+	}
+	for i, w := range waiters {
+		for _, entry := range w.Entries {
+			// waiting for the holders
+			for _, h := range held[key{w.Section, entry}] {
+				if conflicts(w.Shared, h.Shared) {
+					addEdge(w.Goroutine, h.Goroutine, w.Section, entry)
+				}
+			}
+			// waiting for the earlier waiters
+			for _, pw := range waiters[:i] {
+				if pw.Section != w.Section || !conflicts(w.Shared, pw.Shared) {
+					continue
+				}
+				for _, pe := range pw.Entries {
+					if pe == entry {
+						addEdge(w.Goroutine, pw.Goroutine, w.Section, entry)
+					}
+				}
+			}
+		}
+	}
+	return graph
+}
+
+func findCycles(graph map[int64][]WaitEdge) (cycles [][]WaitEdge) {
+	const (
+		white = iota
+		grey
+		black
+	)
+	nodes := make([]int64, 0, len(graph))
+	for n := range graph {
+		nodes = append(nodes, n)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i] < nodes[j] })
+
+	color := map[int64]int{}
+	var path []WaitEdge
+	var visit func(n int64)
+	visit = func(n int64) {
+		color[n] = grey
+		for _, e := range graph[n] {
+			switch color[e.To] {
+			case white:
+				path = append(path, e)
+				visit(e.To)
+				path = path[:len(path)-1]
+			case grey:
+				// back edge, the cycle starts from the first edge leaving e.To
+				start := len(path)
+				for i := len(path) - 1; i >= 0; i-- {
+					if path[i].From == e.To {
+						start = i
+						break
+					}
+				}
+				cycle := append([]WaitEdge{}, path[start:]...)
+				cycles = append(cycles, append(cycle, e))
+			}
+		}
+		color[n] = black
+	}
+	for _, n := range nodes {
+		if color[n] == white {
+			visit(n)
+		}
+	}
+	return
+}
+
+func (d LockDump) String() string {
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "Lock dump at %v\n", d.Time.Format(time.RFC3339Nano))
+	fmt.Fprintf(buf, "Holders (%d):\n", len(d.Holders))
+	for _, h := range d.Holders {
+		mode := "exclusive"
+		if h.Shared {
+			mode = "shared"
+		}
+		fmt.Fprintf(buf, "  %s/%s [%s] %v\n", h.Section, h.Entry, mode,
+			lockOwner{goroutine: h.Goroutine, caller: h.Caller, since: h.Since})
+	}
+	fmt.Fprintf(buf, "Waiters (%d):\n", len(d.Waiters))
+	for _, w := range d.Waiters {
+		mode := "exclusive"
+		if w.Shared {
+			mode = "shared"
+		}
+		fmt.Fprintf(buf, "  %s/%v [%s] %v\n", w.Section, w.Entries, mode,
+			lockOwner{goroutine: w.Goroutine, caller: w.Caller, since: w.Since})
+	}
+	fmt.Fprintf(buf, "Deadlocks (%d):\n", len(d.Deadlocks))
+	for _, cycle := range d.Deadlocks {
+		fmt.Fprintf(buf, "  %v\n", cycle)
+	}
+	return buf.String()
+}
+
+// WriteLockDump writes a human readable dump of all the locks
+func WriteLockDump(w io.Writer) error {
+	_, err := io.WriteString(w, DumpLocks().String())
+	return err
+}
+
+// ServeLockDump is a http handler for the debug endpoint
+// it responses the dump in json, or in plain text if `?format=text`
+func ServeLockDump(w http.ResponseWriter, r *http.Request) {
+	dump := DumpLocks()
+	if r.URL.Query().Get("format") == "text" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, _ = io.WriteString(w, dump.String())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(dump)
+}
+
+// warnLongWait is called periodically while a waiter is blocked
+func (c *CriticalSection) warnLongWait(w *csWaiter) {
+	c.m.Lock()
+	var blockers []string
+	for _, entry := range w.entries {
+		st, ok := c.d[entry]
+		if !ok {
+			continue
+		}
+		for _, h := range st.holders {
+			if conflicts(w.shared, h.shared) {
+				blockers = append(blockers, fmt.Sprintf("%s(held by %v)", entry, h.lockOwner))
+			}
+		}
+	}
+	c.m.Unlock()
+
+	n := 3
+	if len(blockers) < 3 {
+		n = len(blockers)
+	}
+	log.Warnf("Possible Deadlock!!! %s: %v is waiting for %v entries, blocked by: %v... in total %v holders",
+		c.name,
+		w.owner,
+		len(w.entries),
+		blockers[:n],
+		len(blockers),
+	)
+
+	if w.owner.goroutine == 0 {
+		return
+	}
+	for _, cycle := range FindDeadlocks() {
+		for _, e := range cycle {
+			if e.From == w.owner.goroutine {
+				log.Errorf("Deadlock detected: %v", cycle)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package mtx
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/argcv/stork/assert"
+)
+
+func TestCriticalSection_Dump(t *testing.T) {
+	EnableLockDiagnostics(true)
+	defer EnableLockDiagnostics(false)
+
+	cs := NewNamedCriticalSection("dump")
+	cs.Lock("aa")
+	cs.RLock("bb")
+
+	holders, waiters := cs.Dump()
+	assert.ExpectEQ(t, 2, len(holders))
+	assert.ExpectEQ(t, 0, len(waiters))
+	assert.ExpectEQ(t, "aa", holders[0].Entry)
+	assert.ExpectFalse(t, holders[0].Shared)
+	assert.ExpectTrue(t, holders[1].Shared)
+	assert.ExpectNE(t, int64(0), holders[0].Goroutine)
+	assert.ExpectTrue(t, strings.Contains(holders[0].Caller, "cs_diagnostics_test.go"), holders[0].Caller)
+
+	go cs.LockTimeout(100*time.Millisecond, "aa")
+	time.Sleep(10 * time.Millisecond)
+	_, waiters = cs.Dump()
+	assert.ExpectEQ(t, 1, len(waiters))
+
+	cs.Release("aa")
+	cs.RRelease("bb")
+	time.Sleep(10 * time.Millisecond)
+	cs.Release("aa")
+
+	holders, waiters = cs.Dump()
+	assert.ExpectEQ(t, 0, len(holders))
+	assert.ExpectEQ(t, 0, len(waiters))
+}
+
+func TestFindDeadlocks(t *testing.T) {
+	EnableLockDiagnostics(true)
+	defer EnableLockDiagnostics(false)
+
+	cs1 := NewNamedCriticalSection("deadlock-1")
+	cs2 := NewNamedCriticalSection("deadlock-2")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	locked := make(chan bool)
+	proceed := make(chan bool)
+	done := make(chan bool, 2)
+	go func() {
+		cs1.Lock("aa")
+		locked <- true
+		<-proceed
+		cs2.LockContext(ctx, "bb")
+		cs1.Release("aa")
+		done <- true
+	}()
+	<-locked
+	go func() {
+		cs2.Lock("bb")
+		locked <- true
+		cs1.LockContext(ctx, "aa")
+		cs2.Release("bb")
+		done <- true
+	}()
+	<-locked
+	close(proceed)
+	time.Sleep(20 * time.Millisecond)
+
+	cycles := FindDeadlocks()
+	assert.ExpectEQ(t, 1, len(cycles))
+	if len(cycles) == 1 {
+		assert.ExpectEQ(t, 2, len(cycles[0]))
+		assert.ExpectEQ(t, cycles[0][0].From, cycles[0][1].To)
+		assert.ExpectEQ(t, cycles[0][1].From, cycles[0][0].To)
+	}
+
+	buf := &bytes.Buffer{}
+	assert.ExpectNoErr(t, WriteLockDump(buf))
+	assert.ExpectTrue(t, strings.Contains(buf.String(), "Deadlocks (1)"), buf.String())
+
+	rec := httptest.NewRecorder()
+	ServeLockDump(rec, httptest.NewRequest("GET", "/debug/locks", nil))
+	dump := LockDump{}
+	assert.ExpectNoErr(t, json.Unmarshal(rec.Body.Bytes(), &dump))
+	assert.ExpectEQ(t, 1, len(dump.Deadlocks))
+
+	cancel()
+	<-done
+	<-done
+	assert.ExpectEQ(t, 0, len(FindDeadlocks()))
+}
@@ -0,0 +1,306 @@
+package mtx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrFileLockUnsupported = errors.New("file_lock_unsupported")
+	ErrFileLockNotHeld     = errors.New("file_lock_not_held")
+	ErrFileLockHeld        = errors.New("file_lock_already_held")
+	ErrPIDFileLocked       = errors.New("pid_file_locked")
+)
+
+type fileLockMode int
+
+const (
+	fileLockNone fileLockMode = iota
+	fileLockShared
+	fileLockExclusive
+)
+
+/**
+ * FileLock is an advisory lock across the processes, based on flock(2)
+ *
+ * The lock is bound to the opened file, it is released automatically
+ * once the process exits, so it never becomes stale. The locked file is
+ * never written, so it could be the guarded file itself, e.g. a config.
+ * The pid of the exclusive holder is written into a sidecar file
+ * `<path>.pid` for the diagnostics, see Owner.
+ *
+ * NOTE: a FileLock object is NOT reentrant, and it is only supported on
+ * unix-like systems, ErrFileLockUnsupported is returned otherwise.
+ */
+type FileLock struct {
+	path         string
+	pollInterval time.Duration
+
+	mx   sync.Mutex // guards the following block
+	f    *os.File
+	mode fileLockMode
+}
+
+func NewFileLock(path string) *FileLock {
+	return &FileLock{
+		path:         path,
+		pollInterval: 50 * time.Millisecond,
+	}
+}
+
+// SetPollInterval sets the interval of retrying in the blocking lockers
+func (l *FileLock) SetPollInterval(interval time.Duration) *FileLock {
+	if interval > 0 {
+		l.pollInterval = interval
+	}
+	return l
+}
+
+func (l *FileLock) Path() string {
+	return l.path
+}
+
+// TryLock tries to lock the file exclusively without blocking
+func (l *FileLock) TryLock() (bool, error) {
+	return l.tryLock(fileLockExclusive)
+}
+
+// TryRLock tries to lock the file in shared mode without blocking
+func (l *FileLock) TryRLock() (bool, error) {
+	return l.tryLock(fileLockShared)
+}
+
+// Lock waits until the file is locked exclusively
+func (l *FileLock) Lock() error {
+	return l.LockContext(context.Background())
+}
+
+// RLock waits until the file is locked in shared mode
+func (l *FileLock) RLock() error {
+	return l.RLockContext(context.Background())
+}
+
+func (l *FileLock) LockTimeout(timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return l.LockContext(ctx)
+}
+
+func (l *FileLock) RLockTimeout(timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return l.RLockContext(ctx)
+}
+
+// LockContext waits until the file is locked exclusively, or the context is done
+func (l *FileLock) LockContext(ctx context.Context) error {
+	return l.lockContext(ctx, fileLockExclusive)
+}
+
+// RLockContext waits until the file is locked in shared mode, or the context is done
+func (l *FileLock) RLockContext(ctx context.Context) error {
+	return l.lockContext(ctx, fileLockShared)
+}
+
+func (l *FileLock) lockContext(ctx context.Context, mode fileLockMode) error {
+	// flock(2) could not be interrupted, so we keep trying without blocking
+	ticker := time.NewTicker(l.pollInterval)
+	defer ticker.Stop()
+	for {
+		if ok, err := l.tryLock(mode); err != nil {
+			return err
+		} else if ok {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (l *FileLock) tryLock(mode fileLockMode) (bool, error) {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	if l.mode != fileLockNone {
+		return false, ErrFileLockHeld
+	}
+	if l.f == nil {
+		f, err := os.OpenFile(l.path, os.O_CREATE|os.O_RDWR, 0644)
+		if err != nil {
+			return false, err
+		}
+		l.f = f
+	}
+	ok, err := flockTry(l.f, mode == fileLockExclusive)
+	if err != nil || !ok {
+		l.f.Close()
+		l.f = nil
+		return false, err
+	}
+	l.mode = mode
+	if mode == fileLockExclusive {
+		// for the diagnostics only, the failure is ignored
+		_ = os.WriteFile(l.ownerPath(), []byte(strconv.Itoa(os.Getpid())+"\n"), 0644)
+	}
+	return true, nil
+}
+
+// Unlock releases the lock
+func (l *FileLock) Unlock() error {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	if l.mode == fileLockNone {
+		return ErrFileLockNotHeld
+	}
+	if l.mode == fileLockExclusive {
+		// removed before the release, the next holder may write it
+		_ = os.Remove(l.ownerPath())
+	}
+	err := flockRelease(l.f)
+	if e := l.f.Close(); err == nil {
+		err = e
+	}
+	l.f = nil
+	l.mode = fileLockNone
+	return err
+}
+
+// IsLocked returns true if this object is holding the lock
+func (l *FileLock) IsLocked() bool {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	return l.mode != fileLockNone
+}
+
+func (l *FileLock) ownerPath() string {
+	return l.path + ".pid"
+}
+
+// Owner returns the pid of the exclusive holder, 0 if unknown
+// The pid may be stale if the holder is killed, see IsProcessAlive.
+func (l *FileLock) Owner() (int, error) {
+	pid, err := ReadPIDFile(l.ownerPath())
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	return pid, err
+}
+
+// ReadPIDFile reads the pid stored in a file, 0 if the file is empty
+func ReadPIDFile(path string) (int, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	s := strings.TrimSpace(string(b))
+	if s == "" {
+		return 0, nil
+	}
+	return strconv.Atoi(s)
+}
+
+// IsProcessAlive returns true if the process exists
+// it always returns true on the unsupported systems
+func IsProcessAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	return processAlive(pid)
+}
+
+/**
+ * PIDFile is a classic pid file for the daemons, e.g. /var/run/app.pid
+ *
+ * It is created exclusively with the pid of current process. If the file
+ * exists, but the process in it is gone, it is treated as stale and
+ * replaced.
+ */
+type PIDFile struct {
+	path string
+	pid  int
+}
+
+// AcquirePIDFile creates the pid file, ErrPIDFileLocked is returned
+// if another living process is holding it
+func AcquirePIDFile(path string) (*PIDFile, error) {
+	// serialize the stale detection between the processes
+	guard := NewFileLock(path + ".lock")
+	if err := guard.Lock(); err == nil {
+		defer guard.Unlock()
+	} else if err != ErrFileLockUnsupported {
+		return nil, err
+	}
+
+	pid := os.Getpid()
+	for i := 0; i < 2; i++ {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			_, err = f.WriteString(strconv.Itoa(pid) + "\n")
+			if e := f.Close(); err == nil {
+				err = e
+			}
+			if err != nil {
+				os.Remove(path)
+				return nil, err
+			}
+			return &PIDFile{path: path, pid: pid}, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		if stale, err := IsStalePIDFile(path); err != nil {
+			return nil, err
+		} else if !stale {
+			owner, _ := ReadPIDFile(path)
+			return nil, fmt.Errorf("%w: held by pid %d", ErrPIDFileLocked, owner)
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	return nil, ErrPIDFileLocked
+}
+
+// IsStalePIDFile returns true if the process in the pid file is gone
+// a missing file is not stale
+func IsStalePIDFile(path string) (bool, error) {
+	pid, err := ReadPIDFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		if _, ok := err.(*strconv.NumError); ok {
+			// broken content
+			return true, nil
+		}
+		return false, err
+	}
+	return !IsProcessAlive(pid), nil
+}
+
+func (p *PIDFile) Path() string {
+	return p.path
+}
+
+// Release removes the pid file, if it still belongs to current process
+func (p *PIDFile) Release() error {
+	pid, err := ReadPIDFile(p.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if pid != p.pid {
+		return ErrFileLockNotHeld
+	}
+	return os.Remove(p.path)
+}
@@ -0,0 +1,19 @@
+//go:build !unix
+
+package mtx
+
+import (
+	"os"
+)
+
+func flockTry(f *os.File, exclusive bool) (bool, error) {
+	return false, ErrFileLockUnsupported
+}
+
+func flockRelease(f *os.File) error {
+	return ErrFileLockUnsupported
+}
+
+func processAlive(pid int) bool {
+	return true
+}
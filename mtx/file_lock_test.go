@@ -0,0 +1,110 @@
+//go:build unix
+
+package mtx
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/argcv/stork/assert"
+)
+
+func TestFileLock_Lock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.lock")
+	l1 := NewFileLock(path)
+	l2 := NewFileLock(path).SetPollInterval(5 * time.Millisecond)
+
+	ok, err := l1.TryLock()
+	assert.ExpectNoErr(t, err)
+	assert.ExpectTrue(t, ok)
+	assert.ExpectTrue(t, l1.IsLocked())
+
+	pid, err := l1.Owner()
+	assert.ExpectNoErr(t, err)
+	assert.ExpectEQ(t, os.Getpid(), pid)
+
+	_, err = l1.TryLock()
+	assert.ExpectEQ(t, ErrFileLockHeld, err)
+
+	ok, err = l2.TryRLock()
+	assert.ExpectNoErr(t, err)
+	assert.ExpectFalse(t, ok)
+	assert.ExpectEQ(t, context.DeadlineExceeded, l2.LockTimeout(20*time.Millisecond))
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		l1.Unlock()
+	}()
+	assert.ExpectNoErr(t, l2.LockTimeout(time.Second))
+	assert.ExpectNoErr(t, l2.Unlock())
+	assert.ExpectEQ(t, ErrFileLockNotHeld, l2.Unlock())
+}
+
+func TestFileLock_KeepContent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "conf.yml")
+	content := []byte("redis:\n  default:\n    port: 6379\n")
+	assert.ExpectNoErr(t, os.WriteFile(path, content, 0644))
+
+	l := NewFileLock(path)
+	assert.ExpectNoErr(t, l.Lock())
+	pid, err := l.Owner()
+	assert.ExpectNoErr(t, err)
+	assert.ExpectEQ(t, os.Getpid(), pid)
+	assert.ExpectNoErr(t, l.Unlock())
+
+	pid, err = l.Owner()
+	assert.ExpectNoErr(t, err)
+	assert.ExpectEQ(t, 0, pid)
+	b, err := os.ReadFile(path)
+	assert.ExpectNoErr(t, err)
+	assert.ExpectEQ(t, content, b)
+}
+
+func TestFileLock_RLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.lock")
+	l1 := NewFileLock(path)
+	l2 := NewFileLock(path)
+	l3 := NewFileLock(path)
+
+	assert.ExpectNoErr(t, l1.RLock())
+	assert.ExpectNoErr(t, l2.RLockTimeout(time.Second))
+	ok, err := l3.TryLock()
+	assert.ExpectNoErr(t, err)
+	assert.ExpectFalse(t, ok)
+
+	assert.ExpectNoErr(t, l1.Unlock())
+	assert.ExpectNoErr(t, l2.Unlock())
+	assert.ExpectNoErr(t, l3.Lock())
+	assert.ExpectNoErr(t, l3.Unlock())
+}
+
+func TestAcquirePIDFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.pid")
+	p, err := AcquirePIDFile(path)
+	assert.ExpectNoErr(t, err)
+
+	_, err = AcquirePIDFile(path)
+	assert.ExpectTrue(t, errors.Is(err, ErrPIDFileLocked))
+
+	assert.ExpectNoErr(t, p.Release())
+	_, err = os.Stat(path)
+	assert.ExpectTrue(t, os.IsNotExist(err))
+
+	// a pid which never exists
+	assert.ExpectNoErr(t, os.WriteFile(path, []byte(strconv.Itoa(1<<30)), 0644))
+	stale, err := IsStalePIDFile(path)
+	assert.ExpectNoErr(t, err)
+	assert.ExpectTrue(t, stale)
+
+	p, err = AcquirePIDFile(path)
+	assert.ExpectNoErr(t, err)
+	pid, err := ReadPIDFile(p.Path())
+	assert.ExpectNoErr(t, err)
+	assert.ExpectEQ(t, os.Getpid(), pid)
+	assert.ExpectNoErr(t, p.Release())
+}
@@ -0,0 +1,145 @@
+package mtx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrFlightGoexit is shared with the duplicate callers if the function
+// called runtime.Goexit, e.g. t.FailNow in a test
+var ErrFlightGoexit = errors.New("flight_goexit")
+
+type flightCall struct {
+	done chan struct{} // closed once finished
+	val  interface{}
+	err  error
+	dups int
+}
+
+/**
+ * FlightGroup deduplicates the concurrent calls with the same key
+ *
+ * Compare with SingletonDesc, which simply skips the function if another
+ * one is in executing, the duplicate callers of FlightGroup wait for the
+ * first caller, and share its result and error.
+ *
+ * The successful results could be cached for a short ttl, so the callers
+ * shortly after the first call also get the shared result.
+ */
+type FlightGroup struct {
+	m     sync.Mutex // guards the following block
+	calls map[string]*flightCall
+	cache map[string]*flightCall
+	ttl   time.Duration
+}
+
+func NewFlightGroup() *FlightGroup {
+	return &FlightGroup{
+		calls: map[string]*flightCall{},
+		cache: map[string]*flightCall{},
+	}
+}
+
+// SetCacheTTL caches the successful results for ttl, 0 to disable
+func (g *FlightGroup) SetCacheTTL(ttl time.Duration) *FlightGroup {
+	g.m.Lock()
+	defer g.m.Unlock()
+	g.ttl = ttl
+	return g
+}
+
+// Do executes f if there is no running call of the key, otherwise waits
+// for the running one. shared is true if the result is given to more
+// than one caller, or taken from the cache.
+func (g *FlightGroup) Do(key string, f func() (interface{}, error)) (v interface{}, err error, shared bool) {
+	return g.DoContext(context.Background(), key, f)
+}
+
+// DoContext is similar to Do, but a duplicate caller stops waiting once the
+// context is done. The first caller always executes f to the end.
+func (g *FlightGroup) DoContext(ctx context.Context, key string, f func() (interface{}, error)) (v interface{}, err error, shared bool) {
+	g.m.Lock()
+	if c, ok := g.cache[key]; ok {
+		g.m.Unlock()
+		return c.val, c.err, true
+	}
+	if c, ok := g.calls[key]; ok {
+		c.dups++
+		g.m.Unlock()
+		select {
+		case <-c.done:
+			return c.val, c.err, true
+		case <-ctx.Done():
+			return nil, ctx.Err(), false
+		}
+	}
+	c := &flightCall{done: make(chan struct{})}
+	g.calls[key] = c
+	g.m.Unlock()
+
+	g.call(key, c, f)
+	return c.val, c.err, c.dups > 0
+}
+
+func (g *FlightGroup) call(key string, c *flightCall, f func() (interface{}, error)) {
+	normalReturn, recovered := false, false
+	var r interface{}
+	defer func() {
+		if !normalReturn && !recovered {
+			// nothing to recover from runtime.Goexit
+			c.err = ErrFlightGoexit
+		}
+		g.m.Lock()
+		if g.calls[key] == c {
+			delete(g.calls, key)
+		}
+		if normalReturn && c.err == nil && g.ttl > 0 {
+			g.cache[key] = c
+			time.AfterFunc(g.ttl, func() {
+				g.m.Lock()
+				defer g.m.Unlock()
+				if g.cache[key] == c {
+					delete(g.cache, key)
+				}
+			})
+		}
+		g.m.Unlock()
+		close(c.done)
+		if recovered {
+			panic(r)
+		}
+	}()
+	func() {
+		defer func() {
+			if !normalReturn {
+				// recover returns nil for runtime.Goexit, which goes on
+				r = recover()
+				c.err = fmt.Errorf("flight_panicked: %v", r)
+			}
+		}()
+		c.val, c.err = f()
+		normalReturn = true
+	}()
+	if !normalReturn {
+		recovered = true
+	}
+}
+
+// Forget drops the cached result of the key, and makes the future calls
+// not wait for the running one
+func (g *FlightGroup) Forget(key string) {
+	g.m.Lock()
+	defer g.m.Unlock()
+	delete(g.calls, key)
+	delete(g.cache, key)
+}
+
+// InFlight returns the number of running calls
+func (g *FlightGroup) InFlight() int {
+	g.m.Lock()
+	defer g.m.Unlock()
+	return len(g.calls)
+}
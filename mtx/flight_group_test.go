@@ -0,0 +1,154 @@
+package mtx
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/argcv/stork/assert"
+)
+
+func TestFlightGroup_Do(t *testing.T) {
+	g := NewFlightGroup()
+	var calls int32
+	wg := sync.WaitGroup{}
+	start := make(chan struct{})
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			v, err, _ := g.Do("key", func() (interface{}, error) {
+				atomic.AddInt32(&calls, 1)
+				time.Sleep(20 * time.Millisecond)
+				return "value", nil
+			})
+			assert.ExpectNoErr(t, err)
+			assert.ExpectEQ(t, "value", v)
+		}()
+	}
+	close(start)
+	wg.Wait()
+	assert.ExpectEQ(t, int32(1), atomic.LoadInt32(&calls))
+	assert.ExpectEQ(t, 0, g.InFlight())
+
+	// not cached
+	g.Do("key", func() (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		return nil, nil
+	})
+	assert.ExpectEQ(t, int32(2), atomic.LoadInt32(&calls))
+}
+
+func TestFlightGroup_SharedError(t *testing.T) {
+	g := NewFlightGroup().SetCacheTTL(time.Second)
+	expected := errors.New("failed")
+	go g.Do("key", func() (interface{}, error) {
+		time.Sleep(20 * time.Millisecond)
+		return nil, expected
+	})
+	time.Sleep(5 * time.Millisecond)
+	_, err, shared := g.Do("key", func() (interface{}, error) {
+		t.Errorf("should not be called")
+		return nil, nil
+	})
+	assert.ExpectEQ(t, expected, err)
+	assert.ExpectTrue(t, shared)
+
+	// errors are not cached
+	v, err, shared := g.Do("key", func() (interface{}, error) {
+		return 1, nil
+	})
+	assert.ExpectNoErr(t, err)
+	assert.ExpectEQ(t, 1, v)
+	assert.ExpectFalse(t, shared)
+}
+
+func TestFlightGroup_CacheTTL(t *testing.T) {
+	g := NewFlightGroup().SetCacheTTL(20 * time.Millisecond)
+	var calls int32
+	f := func() (interface{}, error) {
+		return atomic.AddInt32(&calls, 1), nil
+	}
+	v, _, _ := g.Do("key", f)
+	assert.ExpectEQ(t, int32(1), v)
+	v, _, shared := g.Do("key", f)
+	assert.ExpectEQ(t, int32(1), v)
+	assert.ExpectTrue(t, shared)
+
+	time.Sleep(30 * time.Millisecond)
+	v, _, _ = g.Do("key", f)
+	assert.ExpectEQ(t, int32(2), v)
+
+	g.Forget("key")
+	v, _, _ = g.Do("key", f)
+	assert.ExpectEQ(t, int32(3), v)
+}
+
+func TestFlightGroup_DoContext(t *testing.T) {
+	g := NewFlightGroup()
+	go g.Do("key", func() (interface{}, error) {
+		time.Sleep(50 * time.Millisecond)
+		return nil, nil
+	})
+	time.Sleep(5 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err, _ := g.DoContext(ctx, "key", func() (interface{}, error) {
+		return nil, nil
+	})
+	assert.ExpectEQ(t, context.DeadlineExceeded, err)
+}
+
+func TestFlightGroup_Panic(t *testing.T) {
+	g := NewFlightGroup()
+	func() {
+		defer func() {
+			assert.ExpectEQ(t, "oops", recover())
+		}()
+		g.Do("key", func() (interface{}, error) {
+			panic("oops")
+		})
+	}()
+	assert.ExpectEQ(t, 0, g.InFlight())
+}
+
+func TestFlightGroup_Goexit(t *testing.T) {
+	g := NewFlightGroup()
+	release := make(chan struct{})
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		g.Do("key", func() (interface{}, error) {
+			<-release
+			runtime.Goexit()
+			return nil, nil
+		})
+		t.Errorf("Do should not return on runtime.Goexit")
+	}()
+	time.Sleep(5 * time.Millisecond)
+
+	done := make(chan error)
+	go func() {
+		_, err, shared := g.Do("key", func() (interface{}, error) {
+			return 1, nil
+		})
+		assert.ExpectTrue(t, shared)
+		done <- err
+	}()
+	time.Sleep(5 * time.Millisecond)
+	close(release)
+	<-exited
+	assert.ExpectEQ(t, ErrFlightGoexit, <-done)
+	assert.ExpectEQ(t, 0, g.InFlight())
+
+	// the key is free again
+	_, err, _ := g.Do("key", func() (interface{}, error) {
+		return 2, nil
+	})
+	assert.ExpectNoErr(t, err)
+}
@@ -0,0 +1,247 @@
+package mtx
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/argcv/stork/cntr"
+	"github.com/argcv/stork/log"
+)
+
+var (
+	ErrLeaseNotHeld    = errors.New("lease_not_held")
+	ErrLeaseInvalidTTL = errors.New("lease_invalid_ttl")
+)
+
+// LeaseToken identifies one acquisition of a LeaseSection
+// 0 is never a valid token
+type LeaseToken uint64
+
+type leaseHolding struct {
+	token    LeaseToken
+	entries  []string
+	expireAt time.Time
+	timer    *time.Timer
+}
+
+/**
+ * LeaseSection is a variant of CriticalSection, which never keeps the
+ * entries locked forever.
+ *
+ * Each acquisition returns a token, and holds the entries for a ttl.
+ * The holder must renew it before the ttl elapsed, otherwise the entries
+ * are reclaimed automatically. The renewal and the release are verified
+ * against the token, so a holder whose lease expired could not release
+ * the entries taken by someone else.
+ */
+type LeaseSection struct {
+	m       sync.Mutex
+	d       map[string]*leaseHolding     // entry => holding
+	leases  map[LeaseToken]*leaseHolding // token => holding
+	next    LeaseToken
+	changed chan struct{} // closed and replaced once any entry is released
+}
+
+func NewLeaseSection() *LeaseSection {
+	return &LeaseSection{
+		d:       map[string]*leaseHolding{},
+		leases:  map[LeaseToken]*leaseHolding{},
+		changed: make(chan struct{}),
+	}
+}
+
+/**
+ * This is a non-blocking method.
+ * Given a set of entries, if all of them are released
+ * lock all for ttl and return the token
+ * otherwise do nothing and return false
+ *
+ * Like CriticalSection.TryLockAll, it reports no error, an invalid ttl
+ * (<= 0) locks nothing and returns false as well. LockContext and
+ * LockTimeout return ErrLeaseInvalidTTL instead.
+ */
+func (c *LeaseSection) TryLockAll(ttl time.Duration, entries ...string) (LeaseToken, bool) {
+	if ttl <= 0 {
+		return 0, false
+	}
+	entries = cntr.DistinctStrings(entries...)
+
+	c.m.Lock()
+	defer c.m.Unlock()
+	token, _ := c.unsafeTryLockAll(ttl, entries)
+	return token, token != 0
+}
+
+/**
+ * Wait until all the entries are locked for ttl, or the timeout reached.
+ */
+func (c *LeaseSection) LockTimeout(timeout, ttl time.Duration, entries ...string) (LeaseToken, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return c.LockContext(ctx, ttl, entries...)
+}
+
+/**
+ * Wait until all the entries are locked for ttl, or the context is done.
+ * Nothing is locked if an error is returned
+ */
+func (c *LeaseSection) LockContext(ctx context.Context, ttl time.Duration, entries ...string) (LeaseToken, error) {
+	if ttl <= 0 {
+		return 0, ErrLeaseInvalidTTL
+	}
+	entries = cntr.DistinctStrings(entries...)
+
+	for {
+		c.m.Lock()
+		token, changed := c.unsafeTryLockAll(ttl, entries)
+		c.m.Unlock()
+		if token != 0 {
+			return token, nil
+		}
+		select {
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+// unsafeTryLockAll returns the token if locked, or a channel which is
+// closed once any entry is released
+func (c *LeaseSection) unsafeTryLockAll(ttl time.Duration, entries []string) (LeaseToken, <-chan struct{}) {
+	for _, entry := range entries {
+		if _, ok := c.d[entry]; ok {
+			return 0, c.changed
+		}
+	}
+	c.next++
+	token := c.next
+	l := &leaseHolding{
+		token:    token,
+		entries:  entries,
+		expireAt: time.Now().Add(ttl),
+	}
+	l.timer = time.AfterFunc(ttl, func() {
+		c.expire(token)
+	})
+	c.leases[token] = l
+	for _, entry := range entries {
+		c.d[entry] = l
+	}
+	return token, nil
+}
+
+// Renew extends the lease to ttl from now
+// ErrLeaseNotHeld is returned if the lease is released or expired
+func (c *LeaseSection) Renew(token LeaseToken, ttl time.Duration) error {
+	if ttl <= 0 {
+		return ErrLeaseInvalidTTL
+	}
+	c.m.Lock()
+	defer c.m.Unlock()
+	l, ok := c.leases[token]
+	if !ok {
+		return ErrLeaseNotHeld
+	}
+	l.expireAt = time.Now().Add(ttl)
+	l.timer.Stop()
+	l.timer = time.AfterFunc(ttl, func() {
+		c.expire(token)
+	})
+	return nil
+}
+
+// Release releases all the entries of the lease
+// ErrLeaseNotHeld is returned if the lease is released or expired
+func (c *LeaseSection) Release(token LeaseToken) error {
+	c.m.Lock()
+	defer c.m.Unlock()
+	l, ok := c.leases[token]
+	if !ok {
+		return ErrLeaseNotHeld
+	}
+	l.timer.Stop()
+	c.unsafeRemove(l)
+	return nil
+}
+
+// ExpireAt returns the deadline of the lease
+func (c *LeaseSection) ExpireAt(token LeaseToken) (time.Time, error) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	l, ok := c.leases[token]
+	if !ok {
+		return time.Time{}, ErrLeaseNotHeld
+	}
+	return l.expireAt, nil
+}
+
+// Holder returns the token holding the entry, 0 if it is not locked
+func (c *LeaseSection) Holder(entry string) LeaseToken {
+	c.m.Lock()
+	defer c.m.Unlock()
+	if l, ok := c.d[entry]; ok {
+		return l.token
+	}
+	return 0
+}
+
+/**
+ * return true if all the entries are unlocked
+ */
+func (c *LeaseSection) Check(entries ...string) bool {
+	c.m.Lock()
+	defer c.m.Unlock()
+	for _, entry := range entries {
+		if _, ok := c.d[entry]; ok {
+			return false
+		}
+	}
+	return true
+}
+
+/**
+ * return true if at least 1 of the entries is locked
+ */
+func (c *LeaseSection) Locked(entries ...string) bool {
+	return !c.Check(entries...)
+}
+
+// Size returns the number of the living leases
+func (c *LeaseSection) Size() int {
+	c.m.Lock()
+	defer c.m.Unlock()
+	return len(c.leases)
+}
+
+func (c *LeaseSection) expire(token LeaseToken) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	l, ok := c.leases[token]
+	if !ok || time.Now().Before(l.expireAt) {
+		// released, or renewed
+		return
+	}
+	n := 3
+	if len(l.entries) < 3 {
+		n = len(l.entries)
+	}
+	log.Warnf("Lease expired!!! token: %v, entries: %v... in total %v entries",
+		token,
+		l.entries[:n],
+		len(l.entries),
+	)
+	c.unsafeRemove(l)
+}
+
+func (c *LeaseSection) unsafeRemove(l *leaseHolding) {
+	delete(c.leases, l.token)
+	for _, entry := range l.entries {
+		delete(c.d, entry)
+	}
+	// wake up all the waiters
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
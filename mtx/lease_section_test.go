@@ -0,0 +1,84 @@
+package mtx
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/argcv/stork/assert"
+)
+
+func TestLeaseSection_TryLockAll(t *testing.T) {
+	ls := NewLeaseSection()
+	token, ok := ls.TryLockAll(time.Second, "aa", "bb")
+	assert.ExpectTrue(t, ok)
+	assert.ExpectNE(t, LeaseToken(0), token)
+	assert.ExpectEQ(t, token, ls.Holder("aa"))
+
+	_, ok = ls.TryLockAll(time.Second, "bb", "cc")
+	assert.ExpectFalse(t, ok)
+	assert.ExpectTrue(t, ls.Check("cc"))
+
+	// an invalid ttl is a failure without the error
+	_, ok = ls.TryLockAll(0, "cc")
+	assert.ExpectFalse(t, ok)
+	assert.ExpectTrue(t, ls.Check("cc"))
+	_, err := ls.LockTimeout(time.Second, -1, "cc")
+	assert.ExpectEQ(t, ErrLeaseInvalidTTL, err)
+
+	assert.ExpectEQ(t, ErrLeaseNotHeld, ls.Release(token+1))
+	assert.ExpectNoErr(t, ls.Release(token))
+	assert.ExpectEQ(t, ErrLeaseNotHeld, ls.Release(token))
+	assert.ExpectFalse(t, ls.Locked("aa", "bb"))
+	assert.ExpectEQ(t, 0, ls.Size())
+}
+
+func TestLeaseSection_Expire(t *testing.T) {
+	ls := NewLeaseSection()
+	token, ok := ls.TryLockAll(20*time.Millisecond, "aa")
+	assert.ExpectTrue(t, ok)
+
+	// renew keeps it alive
+	time.Sleep(10 * time.Millisecond)
+	assert.ExpectNoErr(t, ls.Renew(token, 30*time.Millisecond))
+	time.Sleep(15 * time.Millisecond)
+	assert.ExpectTrue(t, ls.Locked("aa"))
+
+	// reclaimed after expired
+	time.Sleep(30 * time.Millisecond)
+	assert.ExpectTrue(t, ls.Check("aa"))
+	assert.ExpectEQ(t, ErrLeaseNotHeld, ls.Renew(token, time.Second))
+
+	// a stale holder could not release the new holding
+	token2, ok := ls.TryLockAll(time.Second, "aa")
+	assert.ExpectTrue(t, ok)
+	assert.ExpectEQ(t, ErrLeaseNotHeld, ls.Release(token))
+	assert.ExpectEQ(t, token2, ls.Holder("aa"))
+}
+
+func TestLeaseSection_LockContext(t *testing.T) {
+	ls := NewLeaseSection()
+	_, ok := ls.TryLockAll(30*time.Millisecond, "aa")
+	assert.ExpectTrue(t, ok)
+
+	_, err := ls.LockTimeout(10*time.Millisecond, time.Second, "aa")
+	assert.ExpectEQ(t, context.DeadlineExceeded, err)
+
+	// waiting for the expiry
+	token, err := ls.LockTimeout(time.Second, time.Second, "aa", "bb")
+	assert.ExpectNoErr(t, err)
+
+	expireAt, err := ls.ExpireAt(token)
+	assert.ExpectNoErr(t, err)
+	assert.ExpectTrue(t, expireAt.After(time.Now()))
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		ls.Release(token)
+	}()
+	_, err = ls.LockTimeout(time.Second, time.Second, "bb")
+	assert.ExpectNoErr(t, err)
+
+	_, err = ls.LockContext(context.Background(), 0, "cc")
+	assert.ExpectEQ(t, ErrLeaseInvalidTTL, err)
+}
@@ -0,0 +1,156 @@
+package mtx
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/argcv/stork/log"
+)
+
+var (
+	ErrSemaphoreWeightTooLarge = errors.New("semaphore_weight_too_large")
+	ErrSemaphoreNegativeWeight = errors.New("semaphore_negative_weight")
+)
+
+type semaphoreWaiter struct {
+	n     int64
+	ready chan struct{} // closed once granted
+}
+
+/**
+ * Semaphore is a weighted semaphore, e.g. "at most N concurrent queries"
+ *
+ * The waiters are granted in FIFO order, a large request at the head of
+ * the queue blocks the later small ones, so it would never be starved.
+ * A semaphore with the size 1 behaves like a mutex with context support.
+ */
+type Semaphore struct {
+	size    int64
+	mx      sync.Mutex // guards the following block
+	cur     int64
+	waiters list.List // *semaphoreWaiter
+}
+
+func NewSemaphore(size int64) *Semaphore {
+	return &Semaphore{
+		size: size,
+	}
+}
+
+// Acquire acquires the semaphore with a weight of n, blocking until
+// resources are available or ctx is done.
+// Nothing is acquired if an error is returned
+func (s *Semaphore) Acquire(ctx context.Context, n int64) error {
+	if n < 0 {
+		return ErrSemaphoreNegativeWeight
+	}
+	if n > s.size {
+		return ErrSemaphoreWeightTooLarge
+	}
+	s.mx.Lock()
+	if s.size-s.cur >= n && s.waiters.Len() == 0 {
+		s.cur += n
+		s.mx.Unlock()
+		return nil
+	}
+	w := &semaphoreWaiter{n: n, ready: make(chan struct{})}
+	elem := s.waiters.PushBack(w)
+	s.mx.Unlock()
+
+	select {
+	case <-w.ready:
+		return nil
+	case <-ctx.Done():
+		s.mx.Lock()
+		defer s.mx.Unlock()
+		select {
+		case <-w.ready:
+			// granted right before the cancellation, give it back
+			s.cur -= n
+		default:
+			s.waiters.Remove(elem)
+		}
+		// the following waiters may be blocked by this one
+		s.unsafeNotify()
+		return ctx.Err()
+	}
+}
+
+// TryAcquire acquires the semaphore with a weight of n without blocking
+// it returns false if the resources are not available, or someone is waiting,
+// or n is negative
+func (s *Semaphore) TryAcquire(n int64) bool {
+	if n < 0 {
+		return false
+	}
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	if s.size-s.cur >= n && s.waiters.Len() == 0 {
+		s.cur += n
+		return true
+	}
+	return false
+}
+
+// Release releases the semaphore with a weight of n, it panics if n is
+// negative
+func (s *Semaphore) Release(n int64) {
+	if n < 0 {
+		panic("Semaphore: negative weight")
+	}
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	s.cur -= n
+	if s.cur < 0 {
+		log.Fatalf("ERROR: semaphore released more than held!!! (%v)", s.cur)
+		s.cur = 0
+	}
+	s.unsafeNotify()
+}
+
+func (s *Semaphore) unsafeNotify() {
+	for {
+		front := s.waiters.Front()
+		if front == nil {
+			return
+		}
+		w := front.Value.(*semaphoreWaiter)
+		if s.size-s.cur < w.n {
+			// keep FIFO, do not let the smaller ones overtake
+			return
+		}
+		s.cur += w.n
+		s.waiters.Remove(front)
+		close(w.ready)
+	}
+}
+
+// Size returns the total weight of the semaphore
+func (s *Semaphore) Size() int64 {
+	return s.size
+}
+
+// InUse returns the weight currently acquired
+func (s *Semaphore) InUse() int64 {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	return s.cur
+}
+
+// Waiters returns the number of the blocking callers
+func (s *Semaphore) Waiters() int {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	return s.waiters.Len()
+}
+
+// Exec runs f with a weight of n acquired
+func (s *Semaphore) Exec(ctx context.Context, n int64, f func() error) error {
+	if err := s.Acquire(ctx, n); err != nil {
+		return err
+	}
+	defer s.Release(n)
+	return f()
+}
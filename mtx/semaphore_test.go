@@ -0,0 +1,109 @@
+package mtx
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/argcv/stork/assert"
+)
+
+func TestSemaphore_Acquire(t *testing.T) {
+	s := NewSemaphore(3)
+	var curr, peak int64
+	wg := sync.WaitGroup{}
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.ExpectNoErr(t, s.Exec(context.Background(), 1, func() error {
+				n := atomic.AddInt64(&curr, 1)
+				for {
+					p := atomic.LoadInt64(&peak)
+					if n <= p || atomic.CompareAndSwapInt64(&peak, p, n) {
+						break
+					}
+				}
+				time.Sleep(1 * time.Millisecond)
+				atomic.AddInt64(&curr, -1)
+				return nil
+			}))
+		}()
+	}
+	wg.Wait()
+	assert.ExpectGE(t, int64(3), peak)
+	assert.ExpectEQ(t, int64(0), s.InUse())
+	assert.ExpectEQ(t, ErrSemaphoreWeightTooLarge, s.Acquire(context.Background(), 4))
+}
+
+func TestSemaphore_TryAcquire(t *testing.T) {
+	s := NewSemaphore(3)
+	assert.ExpectTrue(t, s.TryAcquire(2))
+	assert.ExpectFalse(t, s.TryAcquire(2))
+	assert.ExpectTrue(t, s.TryAcquire(1))
+	assert.ExpectEQ(t, int64(3), s.InUse())
+	assert.ExpectEQ(t, int64(3), s.Size())
+	s.Release(3)
+	assert.ExpectEQ(t, int64(0), s.InUse())
+}
+
+func TestSemaphore_NegativeWeight(t *testing.T) {
+	s := NewSemaphore(3)
+	assert.ExpectTrue(t, s.TryAcquire(1))
+	assert.ExpectEQ(t, ErrSemaphoreNegativeWeight, s.Acquire(context.Background(), -1))
+	assert.ExpectFalse(t, s.TryAcquire(-1))
+	func() {
+		defer func() {
+			assert.ExpectEQ(t, "Semaphore: negative weight", recover())
+		}()
+		s.Release(-1)
+	}()
+	// nothing is changed
+	assert.ExpectEQ(t, int64(1), s.InUse())
+	assert.ExpectTrue(t, s.TryAcquire(2))
+	assert.ExpectFalse(t, s.TryAcquire(1))
+}
+
+func TestSemaphore_Fairness(t *testing.T) {
+	s := NewSemaphore(3)
+	assert.ExpectTrue(t, s.TryAcquire(2))
+
+	granted := make(chan int64, 2)
+	go func() {
+		s.Acquire(context.Background(), 3)
+		granted <- 3
+	}()
+	time.Sleep(10 * time.Millisecond)
+	assert.ExpectEQ(t, 1, s.Waiters())
+
+	// 1 is available, but the large one is waiting
+	assert.ExpectFalse(t, s.TryAcquire(1))
+	go func() {
+		s.Acquire(context.Background(), 1)
+		granted <- 1
+	}()
+	time.Sleep(10 * time.Millisecond)
+	assert.ExpectEQ(t, 2, s.Waiters())
+
+	s.Release(2)
+	assert.ExpectEQ(t, int64(3), <-granted)
+	s.Release(3)
+	assert.ExpectEQ(t, int64(1), <-granted)
+	s.Release(1)
+}
+
+func TestSemaphore_AcquireContext(t *testing.T) {
+	s := NewSemaphore(2)
+	assert.ExpectTrue(t, s.TryAcquire(1))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ExpectEQ(t, context.DeadlineExceeded, s.Acquire(ctx, 2))
+	assert.ExpectEQ(t, 0, s.Waiters())
+	assert.ExpectEQ(t, int64(1), s.InUse())
+
+	// the cancelled large waiter no longer blocks the small ones
+	assert.ExpectTrue(t, s.TryAcquire(1))
+}
@@ -1,6 +1,7 @@
 package mtx
 
 import (
+	"context"
 	"sync"
 	"sync/atomic"
 
@@ -17,31 +18,81 @@ type WaitGroupWithState interface {
 	Wait()
 }
 
+// WaitGroupWithStateContext is a WaitGroupWithState, which could also
+// wait with a context, or until a given state
+type WaitGroupWithStateContext interface {
+	WaitGroupWithState
+
+	// WaitContext waits until the state is 0, or the context is done
+	WaitContext(ctx context.Context) error
+	// WaitUntil waits until the state is lower than or equal to n
+	WaitUntil(n int64)
+	// WaitUntilContext waits until the state is lower than or equal to n,
+	// or the context is done
+	WaitUntilContext(ctx context.Context, n int64) error
+	// Subscribe returns a channel, which receives current state at first,
+	// and the latest state after each change. A slow receiver only misses
+	// the intermediate states, never the latest one.
+	// The returned function must be called to unsubscribe.
+	Subscribe() (<-chan int64, func())
+}
+
 type waitGroupWithStateImpl struct {
-	st int64
-	cv *sync.Cond
+	st      int64
+	mx      sync.Mutex    // guards the following block
+	changed chan struct{} // closed and replaced on each change
+	subs    map[int]chan int64
+	nextSub int
 }
 
 func NewWaitGroupWithState() WaitGroupWithState {
+	return NewWaitGroupWithStateContext()
+}
+
+func NewWaitGroupWithStateContext() WaitGroupWithStateContext {
 	return &waitGroupWithStateImpl{
-		st: 0,
-		cv: sync.NewCond(&sync.Mutex{}),
+		st:      0,
+		changed: make(chan struct{}),
+		subs:    map[int]chan int64{},
 	}
 }
 
 func (wg *waitGroupWithStateImpl) Add(delta int64) int64 {
+	wg.mx.Lock()
+	defer wg.mx.Unlock()
 	newSt := atomic.AddInt64(&(wg.st), delta)
 	if newSt < 0 {
 		log.Fatalf("ERROR: status is lower than 0!!! (%v)", newSt)
 	}
+	if delta != 0 {
+		close(wg.changed)
+		wg.changed = make(chan struct{})
+		for _, ch := range wg.subs {
+			notifyLatest(ch, newSt)
+		}
+	}
 	return newSt
 }
 
+// notifyLatest sends v to a channel with 1 buffer, the pending value
+// is replaced if the receiver is not ready
+func notifyLatest(ch chan int64, v int64) {
+	for {
+		select {
+		case ch <- v:
+			return
+		default:
+		}
+		select {
+		case <-ch:
+		default:
+		}
+	}
+}
+
 // minus one, return current value
 func (wg *waitGroupWithStateImpl) Done() int64 {
-	newSt := wg.Add(-1)
-	wg.cv.Broadcast()
-	return newSt
+	return wg.Add(-1)
 }
 
 func (wg *waitGroupWithStateImpl) State() int64 {
@@ -49,9 +100,48 @@ func (wg *waitGroupWithStateImpl) State() int64 {
 }
 
 func (wg *waitGroupWithStateImpl) Wait() {
-	for wg.State() > 0 {
-		wg.cv.L.Lock()
-		wg.cv.Wait()
-		wg.cv.L.Unlock()
+	wg.WaitUntil(0)
+}
+
+func (wg *waitGroupWithStateImpl) WaitContext(ctx context.Context) error {
+	return wg.WaitUntilContext(ctx, 0)
+}
+
+func (wg *waitGroupWithStateImpl) WaitUntil(n int64) {
+	_ = wg.WaitUntilContext(context.Background(), n)
+}
+
+func (wg *waitGroupWithStateImpl) WaitUntilContext(ctx context.Context, n int64) error {
+	for {
+		wg.mx.Lock()
+		st := wg.State()
+		changed := wg.changed
+		wg.mx.Unlock()
+		if st <= n {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+func (wg *waitGroupWithStateImpl) Subscribe() (<-chan int64, func()) {
+	wg.mx.Lock()
+	defer wg.mx.Unlock()
+	id := wg.nextSub
+	wg.nextSub++
+	ch := make(chan int64, 1)
+	ch <- wg.State()
+	wg.subs[id] = ch
+	once := sync.Once{}
+	return ch, func() {
+		once.Do(func() {
+			wg.mx.Lock()
+			defer wg.mx.Unlock()
+			delete(wg.subs, id)
+		})
 	}
 }
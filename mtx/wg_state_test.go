@@ -1,6 +1,7 @@
 package mtx
 
 import (
+	"context"
 	"github.com/argcv/stork/assert"
 	"testing"
 	"time"
@@ -21,3 +22,47 @@ func TestNewWaitGroupWithState(t *testing.T) {
 	wg.Wait()
 	assert.ExpectEQ(t, int64(0), wg.State())
 }
+
+func TestWaitGroupWithState_WaitContext(t *testing.T) {
+	wg := NewWaitGroupWithStateContext()
+	wg.Add(2)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ExpectEQ(t, context.DeadlineExceeded, wg.WaitContext(ctx))
+
+	go func() {
+		time.Sleep(5 * time.Millisecond)
+		wg.Done()
+		time.Sleep(5 * time.Millisecond)
+		wg.Done()
+	}()
+	wg.WaitUntil(1)
+	assert.ExpectGE(t, int64(1), wg.State())
+	assert.ExpectNoErr(t, wg.WaitContext(context.Background()))
+	assert.ExpectEQ(t, int64(0), wg.State())
+}
+
+func TestWaitGroupWithState_Subscribe(t *testing.T) {
+	wg := NewWaitGroupWithStateContext()
+	ch, cancel := wg.Subscribe()
+	assert.ExpectEQ(t, int64(0), <-ch)
+
+	wg.Add(1)
+	assert.ExpectEQ(t, int64(1), <-ch)
+
+	// the slow receiver gets the latest state
+	wg.Add(1)
+	wg.Add(1)
+	wg.Done()
+	assert.ExpectEQ(t, int64(2), <-ch)
+
+	cancel()
+	cancel()
+	wg.Done()
+	select {
+	case v := <-ch:
+		t.Errorf("unexpected state after unsubscribed: %v", v)
+	default:
+	}
+}
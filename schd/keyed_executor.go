@@ -0,0 +1,159 @@
+package schd
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/argcv/stork/mtx"
+)
+
+type keyedLane struct {
+	key   string
+	tasks []func()
+}
+
+func (l *keyedLane) pop() (f func()) {
+	f = l.tasks[0]
+	l.tasks[0] = nil
+	l.tasks = l.tasks[1:]
+	return
+}
+
+/**
+ * KeyedExecutor: the tasks with the same key are executed
+ * strictly in order, while the tasks with different keys are
+ * executed in parallel, at most `numWorkers` at the same time
+ *
+ * Each key holds a FIFO lane, which is created on the first task
+ * and dropped as soon as it is drained, so the idle keys takes
+ * no memory at all.
+ *
+ * The lanes are served round-robin, one task per turn, so a busy
+ * key would not starve the others.
+ */
+type KeyedExecutor struct {
+	numWorkers int
+
+	m       sync.Mutex // guards the following block
+	lanes   map[string]*keyedLane
+	ready   []*keyedLane // lanes waiting for a worker
+	running int
+	closed  bool
+
+	wg mtx.WaitGroupWithState
+	cs *mtx.CriticalSection
+}
+
+func NewKeyedExecutor() *KeyedExecutor {
+	return &KeyedExecutor{
+		numWorkers: 1,
+		lanes:      map[string]*keyedLane{},
+		wg:         mtx.NewWaitGroupWithState(),
+	}
+}
+
+func (e *KeyedExecutor) SetNumWorkers(numWorkers int) int {
+	e.m.Lock()
+	defer e.m.Unlock()
+	if numWorkers > 0 {
+		e.numWorkers = numWorkers
+	}
+	e.unsafeDispatch()
+	return e.numWorkers
+}
+
+func (e *KeyedExecutor) GetNumWorkers() int {
+	e.m.Lock()
+	defer e.m.Unlock()
+	return e.numWorkers
+}
+
+// SetCriticalSection makes every task hold its key in the given critical
+// section during the execution, so that the code out of this executor
+// could be serialized with the tasks by locking the same key.
+func (e *KeyedExecutor) SetCriticalSection(cs *mtx.CriticalSection) *KeyedExecutor {
+	e.m.Lock()
+	defer e.m.Unlock()
+	e.cs = cs
+	return e
+}
+
+// Enqueue adds a new task to the lane of the key
+func (e *KeyedExecutor) Enqueue(key string, f func()) error {
+	e.m.Lock()
+	defer e.m.Unlock()
+	if e.closed {
+		return errors.New("closed")
+	}
+	e.wg.Add(1)
+	lane, ok := e.lanes[key]
+	if !ok {
+		lane = &keyedLane{key: key}
+		e.lanes[key] = lane
+	}
+	lane.tasks = append(lane.tasks, f)
+	if !ok {
+		// a new lane, it is neither running nor waiting
+		e.ready = append(e.ready, lane)
+		e.unsafeDispatch()
+	}
+	return nil
+}
+
+// unsafeDispatch launches the ready lanes until all the workers are busy
+// it must be called with e.m locked
+func (e *KeyedExecutor) unsafeDispatch() {
+	for e.running < e.numWorkers && len(e.ready) > 0 {
+		lane := e.ready[0]
+		e.ready[0] = nil
+		e.ready = e.ready[1:]
+		e.running++
+		go e.run(lane, lane.pop(), e.cs)
+	}
+}
+
+func (e *KeyedExecutor) run(lane *keyedLane, f func(), cs *mtx.CriticalSection) {
+	defer func() {
+		e.m.Lock()
+		e.running--
+		if len(lane.tasks) > 0 {
+			// back to the tail, give other keys a chance
+			e.ready = append(e.ready, lane)
+		} else {
+			delete(e.lanes, lane.key)
+		}
+		e.unsafeDispatch()
+		e.m.Unlock()
+		e.wg.Done()
+	}()
+	if cs != nil {
+		cs.Join(lane.key)
+		defer cs.ReleaseOne(lane.key)
+	}
+	f()
+}
+
+// return current work loader
+func (e *KeyedExecutor) State() int64 {
+	return e.wg.State()
+}
+
+// NumKeys returns the number of keys with pending or running tasks
+func (e *KeyedExecutor) NumKeys() int {
+	e.m.Lock()
+	defer e.m.Unlock()
+	return len(e.lanes)
+}
+
+// Flush waits until all the tasks are finished
+func (e *KeyedExecutor) Flush() {
+	e.wg.Wait()
+}
+
+// Close rejects all the new tasks, and waits for the pending ones
+func (e *KeyedExecutor) Close() {
+	e.m.Lock()
+	e.closed = true
+	e.m.Unlock()
+	e.Flush()
+}
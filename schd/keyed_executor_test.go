@@ -0,0 +1,92 @@
+package schd
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/argcv/stork/assert"
+	"github.com/argcv/stork/mtx"
+)
+
+func TestKeyedExecutor_Order(t *testing.T) {
+	e := NewKeyedExecutor()
+	assert.ExpectEQ(t, 4, e.SetNumWorkers(4))
+
+	mx := sync.Mutex{}
+	seen := map[string][]int{}
+	for i := 0; i < 100; i++ {
+		ci := i
+		key := fmt.Sprintf("k%d", i%5)
+		if err := e.Enqueue(key, func() {
+			time.Sleep(100 * time.Microsecond)
+			mx.Lock()
+			seen[key] = append(seen[key], ci)
+			mx.Unlock()
+		}); err != nil {
+			t.Fatalf("enqueue failed: %v", err)
+		}
+	}
+	e.Flush()
+
+	assert.ExpectEQ(t, 5, len(seen))
+	for key, seq := range seen {
+		assert.ExpectEQ(t, 20, len(seq), key)
+		for i := 1; i < len(seq); i++ {
+			assert.ExpectEQ(t, 5, seq[i]-seq[i-1], fmt.Sprintf("%v: %v", key, seq))
+		}
+	}
+	assert.ExpectEQ(t, int64(0), e.State())
+	assert.ExpectEQ(t, 0, e.NumKeys())
+}
+
+func TestKeyedExecutor_Parallel(t *testing.T) {
+	e := NewKeyedExecutor()
+	e.SetNumWorkers(3)
+
+	var curr, peak int32
+	for i := 0; i < 30; i++ {
+		e.Enqueue(fmt.Sprintf("k%d", i%3), func() {
+			n := atomic.AddInt32(&curr, 1)
+			for {
+				p := atomic.LoadInt32(&peak)
+				if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+					break
+				}
+			}
+			time.Sleep(1 * time.Millisecond)
+			atomic.AddInt32(&curr, -1)
+		})
+	}
+	e.Close()
+	if peak > 3 {
+		t.Errorf("too many workers: %v", peak)
+	}
+	if peak < 2 {
+		t.Errorf("keys are not executed in parallel: %v", peak)
+	}
+	if err := e.Enqueue("k0", func() {}); err == nil {
+		t.Errorf("enqueue after close should fail")
+	}
+}
+
+func TestKeyedExecutor_SetCriticalSection(t *testing.T) {
+	cs := mtx.NewCriticalSection()
+	e := NewKeyedExecutor().SetCriticalSection(cs)
+	e.SetNumWorkers(2)
+
+	cs.Join("k1")
+	var executed int32
+	e.Enqueue("k1", func() {
+		atomic.AddInt32(&executed, 1)
+	})
+	time.Sleep(20 * time.Millisecond)
+	assert.ExpectEQ(t, int32(0), atomic.LoadInt32(&executed))
+
+	cs.ReleaseOne("k1")
+	e.Flush()
+	assert.ExpectEQ(t, int32(1), atomic.LoadInt32(&executed))
+	assert.ExpectTrue(t, cs.Check("k1", "k2"))
+}
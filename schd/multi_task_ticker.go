@@ -55,6 +55,12 @@ func (rs *runningState) stat() int {
 
 type MultiTaskTickerFunc func(ctx context.Context, param interface{})
 
+// Leader tells whether current process is allowed to run the jobs
+// e.g. mongo.Lease, which is held by only one replica at a time
+type Leader interface {
+	IsLeader() bool
+}
+
 type MultiTaskTicker struct {
 	period    time.Duration
 	nWorkers  int
@@ -64,6 +70,7 @@ type MultiTaskTicker struct {
 	wg        *sync.WaitGroup
 	m         *sync.Mutex
 	isStarted bool
+	leader    Leader
 }
 
 func NewMultiTaskTicker() *MultiTaskTicker {
@@ -88,6 +95,14 @@ func (mtt *MultiTaskTicker) SetNumWorkers(nWorkers int) *MultiTaskTicker {
 	return mtt
 }
 
+// SetLeader makes the ticker only dispatch tasks while the leader reports
+// current process as the leader, the ticks are skipped otherwise.
+// The tasks already in running are NOT interrupted after the leadership lost
+func (mtt *MultiTaskTicker) SetLeader(leader Leader) *MultiTaskTicker {
+	mtt.leader = leader
+	return mtt
+}
+
 func (mtt *MultiTaskTicker) GetNumWorkers() int {
 	return mtt.nWorkers
 }
@@ -123,6 +138,10 @@ func (mtt *MultiTaskTicker) Start(ctx context.Context, f MultiTaskTickerFunc) (e
 				log.Infof("canceled...")
 				return
 			case <-ticker.C:
+				if mtt.leader != nil && !mtt.leader.IsLeader() {
+					// not the leader, skip current tick
+					continue
+				}
 				params := mtt.params
 				for id := range params {
 					cid := id
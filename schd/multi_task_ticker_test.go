@@ -2,6 +2,7 @@ package schd
 
 import (
 	"context"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -38,3 +39,40 @@ func TestMultiTaskTicker_Start(t *testing.T) {
 	}
 	t.Logf("c1: %v c2: %v", c1, c2)
 }
+
+type fakeLeader struct {
+	leader int32
+}
+
+func (l *fakeLeader) IsLeader() bool {
+	return atomic.LoadInt32(&l.leader) == 1
+}
+
+func TestMultiTaskTicker_SetLeader(t *testing.T) {
+	var cnt int32
+	leader := &fakeLeader{}
+	mtt := NewMultiTaskTicker()
+	mtt.SetPeriod(5 * time.Millisecond)
+	mtt.SetTasks("1")
+	mtt.SetLeader(leader)
+	st := mtt.Start(context.Background(), func(ctx context.Context, label interface{}) {
+		atomic.AddInt32(&cnt, 1)
+	})
+	if st != nil {
+		t.Errorf("failed: %v", st)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if c := atomic.LoadInt32(&cnt); c != 0 {
+		t.Errorf("executed %v times without leadership", c)
+	}
+	atomic.StoreInt32(&leader.leader, 1)
+	time.Sleep(50 * time.Millisecond)
+	if c := atomic.LoadInt32(&cnt); c == 0 {
+		t.Errorf("not executed after elected")
+	}
+	ctx, cancel := context.WithTimeout(context.TODO(), 1*time.Second)
+	defer cancel()
+	if st = mtt.Stop(ctx); st != nil {
+		t.Errorf("failed: %v", st)
+	}
+}
@@ -0,0 +1,333 @@
+package schd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// StageStats is the final statistics of one stage
+type StageStats struct {
+	Name     string
+	Workers  int
+	In       int64 // # of items received from the upstream
+	Out      int64 // # of items emitted to the downstream
+	Errors   int64 // # of failed calls
+	Duration time.Duration
+}
+
+type PipelineStats struct {
+	Name     string
+	Stages   []StageStats
+	Duration time.Duration
+}
+
+type pipelineStage struct {
+	name     string
+	workers  int
+	consumed bool // the output is read by another stage
+	isSink   bool
+
+	in   int64
+	out  int64
+	errs int64
+	dur  time.Duration
+
+	prepare func()
+	run     func(ctx context.Context, fail func(error))
+}
+
+func (s *pipelineStage) stats() StageStats {
+	return StageStats{
+		Name:     s.name,
+		Workers:  s.workers,
+		In:       atomic.LoadInt64(&s.in),
+		Out:      atomic.LoadInt64(&s.out),
+		Errors:   atomic.LoadInt64(&s.errs),
+		Duration: s.dur,
+	}
+}
+
+/**
+ * Pipeline chains a few stages, e.g. read => transform => write
+ *
+ * The stages are connected with bounded buffers, each stage runs
+ * with its own number of workers. The first error of any stage
+ * cancels the whole pipeline, and returned by Run.
+ *
+ * Example:
+ *
+ *	p := schd.NewPipeline("export")
+ *	docs := schd.Source(p, "read", func(ctx context.Context, emit func([]bson.M) error) error {
+ *		return client.IterBatch("coll", nil, nil, 100, func(b []bson.M) error { return emit(b) })
+ *	})
+ *	rows := schd.Map(docs, "transform", 4, convert).Buffer(16)
+ *	schd.Sink(rows, "write", 1, write)
+ *	stats, err := p.Run(ctx)
+ */
+type Pipeline struct {
+	name   string
+	stages []*pipelineStage
+
+	m       sync.Mutex // guards the following block
+	err     error      // the first building error
+	started bool
+}
+
+func NewPipeline(name string) *Pipeline {
+	return &Pipeline{
+		name: name,
+	}
+}
+
+// Stage is the typed output of a stage, which could be consumed by
+// exactly one downstream stage
+type Stage[T any] struct {
+	p      *Pipeline
+	s      *pipelineStage
+	buffer int
+	ch     chan T
+}
+
+func (p *Pipeline) addStage(s *pipelineStage) {
+	p.m.Lock()
+	defer p.m.Unlock()
+	p.stages = append(p.stages, s)
+}
+
+func (p *Pipeline) setErr(err error) {
+	p.m.Lock()
+	defer p.m.Unlock()
+	if p.err == nil {
+		p.err = err
+	}
+}
+
+func newStage[T any](p *Pipeline, name string, workers int) *Stage[T] {
+	if workers < 1 {
+		workers = 1
+	}
+	st := &Stage[T]{
+		p: p,
+		s: &pipelineStage{
+			name:    name,
+			workers: workers,
+		},
+	}
+	st.s.prepare = func() {
+		st.ch = make(chan T, st.buffer)
+	}
+	p.addStage(st.s)
+	return st
+}
+
+// Buffer sets the size of the buffer between current stage and its
+// downstream. The default size is 0, aka. unbuffered
+func (st *Stage[T]) Buffer(size int) *Stage[T] {
+	if size >= 0 {
+		st.buffer = size
+	}
+	return st
+}
+
+func (st *Stage[T]) Name() string {
+	return st.s.name
+}
+
+// consume marks the stage is read by the downstream
+func (st *Stage[T]) consume(by string) {
+	st.p.m.Lock()
+	defer st.p.m.Unlock()
+	if st.s.consumed {
+		if st.p.err == nil {
+			st.p.err = fmt.Errorf("stage %s is consumed twice (by %s)", st.s.name, by)
+		}
+		return
+	}
+	st.s.consumed = true
+}
+
+// emitter returns a function to send items to the downstream
+func (st *Stage[T]) emitter(ctx context.Context) func(T) error {
+	return func(item T) error {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case st.ch <- item:
+			atomic.AddInt64(&st.s.out, 1)
+			return nil
+		}
+	}
+}
+
+// Source adds the first stage of a pipeline, f should call emit for
+// each item, and stop as soon as emit returns an error
+func Source[T any](p *Pipeline, name string, f func(ctx context.Context, emit func(T) error) error) *Stage[T] {
+	st := newStage[T](p, name, 1)
+	st.s.run = func(ctx context.Context, fail func(error)) {
+		defer close(st.ch)
+		if err := f(ctx, st.emitter(ctx)); err != nil {
+			atomic.AddInt64(&st.s.errs, 1)
+			fail(fmt.Errorf("stage %s: %w", name, err))
+		}
+	}
+	return st
+}
+
+// consumeLoop reads the items from `in` using `workers` goroutines
+// until the upstream closed or the pipeline is canceled
+func consumeLoop[I any](ctx context.Context, in *Stage[I], s *pipelineStage, fail func(error), f func(I) error) {
+	wg := sync.WaitGroup{}
+	for i := 0; i < s.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case item, ok := <-in.ch:
+					if !ok {
+						return
+					}
+					atomic.AddInt64(&s.in, 1)
+					if err := f(item); err != nil {
+						atomic.AddInt64(&s.errs, 1)
+						fail(fmt.Errorf("stage %s: %w", s.name, err))
+						return
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// Transform adds a stage, which may emit zero or more items for each input
+// It could be used to filter, split or batch the items
+func Transform[I, O any](in *Stage[I], name string, workers int, f func(ctx context.Context, item I, emit func(O) error) error) *Stage[O] {
+	in.consume(name)
+	st := newStage[O](in.p, name, workers)
+	st.s.run = func(ctx context.Context, fail func(error)) {
+		defer close(st.ch)
+		emit := st.emitter(ctx)
+		consumeLoop(ctx, in, st.s, fail, func(item I) error {
+			return f(ctx, item, emit)
+		})
+	}
+	return st
+}
+
+// Map adds a stage, which converts each input to exactly one output
+func Map[I, O any](in *Stage[I], name string, workers int, f func(ctx context.Context, item I) (O, error)) *Stage[O] {
+	return Transform(in, name, workers, func(ctx context.Context, item I, emit func(O) error) error {
+		out, err := f(ctx, item)
+		if err != nil {
+			return err
+		}
+		return emit(out)
+	})
+}
+
+// Sink adds the final stage of a pipeline
+func Sink[I any](in *Stage[I], name string, workers int, f func(ctx context.Context, item I) error) {
+	in.consume(name)
+	if workers < 1 {
+		workers = 1
+	}
+	s := &pipelineStage{
+		name:    name,
+		workers: workers,
+		isSink:  true,
+		prepare: func() {},
+	}
+	s.run = func(ctx context.Context, fail func(error)) {
+		consumeLoop(ctx, in, s, fail, func(item I) error {
+			return f(ctx, item)
+		})
+	}
+	in.p.addStage(s)
+}
+
+func (p *Pipeline) check() error {
+	if p.err != nil {
+		return p.err
+	}
+	if p.started {
+		return errors.New("already_started")
+	}
+	if len(p.stages) == 0 {
+		return errors.New("empty_pipeline")
+	}
+	for _, s := range p.stages {
+		if !s.isSink && !s.consumed {
+			return fmt.Errorf("stage %s is not consumed", s.name)
+		}
+	}
+	return nil
+}
+
+// Stats returns the statistics of all the stages
+func (p *Pipeline) Stats() (stats PipelineStats) {
+	p.m.Lock()
+	defer p.m.Unlock()
+	stats.Name = p.name
+	for _, s := range p.stages {
+		stats.Stages = append(stats.Stages, s.stats())
+	}
+	return
+}
+
+// Run executes the pipeline, and waits until all the stages finished
+// It returns the first error, if any stage failed or ctx is canceled.
+// A pipeline can only be executed once.
+func (p *Pipeline) Run(ctx context.Context) (stats PipelineStats, err error) {
+	p.m.Lock()
+	if err = p.check(); err != nil {
+		p.m.Unlock()
+		return p.Stats(), err
+	}
+	p.started = true
+	stages := p.stages
+	p.m.Unlock()
+
+	cctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	once := sync.Once{}
+	fail := func(e error) {
+		once.Do(func() {
+			err = e
+			cancel()
+		})
+	}
+
+	for _, s := range stages {
+		s.prepare()
+	}
+
+	timeIn := time.Now()
+	wg := sync.WaitGroup{}
+	for _, s := range stages {
+		wg.Add(1)
+		go func(s *pipelineStage) {
+			defer wg.Done()
+			st := time.Now()
+			s.run(cctx, fail)
+			s.dur = time.Since(st)
+		}(s)
+	}
+	wg.Wait()
+
+	// a sink may quit before its upstream closed on cancellation
+	if err == nil && ctx.Err() != nil {
+		err = ctx.Err()
+	}
+
+	stats = p.Stats()
+	stats.Duration = time.Since(timeIn)
+	return
+}
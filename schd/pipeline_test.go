@@ -0,0 +1,116 @@
+package schd
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/argcv/stork/assert"
+)
+
+func TestPipeline_Run(t *testing.T) {
+	p := NewPipeline("test")
+	nums := Source(p, "read", func(ctx context.Context, emit func(int) error) error {
+		for i := 0; i < 100; i++ {
+			if err := emit(i); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	evens := Transform(nums, "filter", 2, func(ctx context.Context, item int, emit func(int) error) error {
+		if item%2 == 0 {
+			return emit(item)
+		}
+		return nil
+	}).Buffer(4)
+	strs := Map(evens, "format", 3, func(ctx context.Context, item int) (string, error) {
+		return strconv.Itoa(item), nil
+	}).Buffer(8)
+
+	mx := sync.Mutex{}
+	sum := 0
+	Sink(strs, "write", 1, func(ctx context.Context, item string) error {
+		v, err := strconv.Atoi(item)
+		mx.Lock()
+		sum += v
+		mx.Unlock()
+		return err
+	})
+
+	stats, err := p.Run(context.Background())
+	assert.ExpectNoErr(t, err)
+	assert.ExpectEQ(t, 2450, sum)
+	assert.ExpectEQ(t, 4, len(stats.Stages))
+	assert.ExpectEQ(t, int64(100), stats.Stages[0].Out)
+	assert.ExpectEQ(t, int64(100), stats.Stages[1].In)
+	assert.ExpectEQ(t, int64(50), stats.Stages[1].Out)
+	assert.ExpectEQ(t, int64(50), stats.Stages[3].In)
+	assert.ExpectEQ(t, 3, stats.Stages[2].Workers)
+
+	_, err = p.Run(context.Background())
+	assert.ExpectErr(t, err)
+}
+
+func TestPipeline_Error(t *testing.T) {
+	p := NewPipeline("test")
+	nums := Source(p, "read", func(ctx context.Context, emit func(int) error) error {
+		for i := 0; ; i++ {
+			if err := emit(i); err != nil {
+				return err
+			}
+		}
+	})
+	expected := errors.New("bad item")
+	Sink(nums, "write", 2, func(ctx context.Context, item int) error {
+		if item == 10 {
+			return expected
+		}
+		return nil
+	})
+
+	stats, err := p.Run(context.Background())
+	assert.ExpectTrue(t, errors.Is(err, expected), err.Error())
+	assert.ExpectEQ(t, int64(1), stats.Stages[1].Errors)
+}
+
+func TestPipeline_Cancel(t *testing.T) {
+	p := NewPipeline("test")
+	nums := Source(p, "read", func(ctx context.Context, emit func(int) error) error {
+		for i := 0; ; i++ {
+			if err := emit(i); err != nil {
+				return err
+			}
+		}
+	})
+	Sink(nums, "write", 1, func(ctx context.Context, item int) error {
+		time.Sleep(1 * time.Millisecond)
+		return nil
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err := p.Run(ctx)
+	assert.ExpectTrue(t, errors.Is(err, context.DeadlineExceeded))
+}
+
+func TestPipeline_Check(t *testing.T) {
+	p := NewPipeline("test")
+	nums := Source(p, "read", func(ctx context.Context, emit func(int) error) error {
+		return nil
+	})
+	_, err := p.Run(context.Background())
+	assert.ExpectErr(t, err, "not consumed")
+
+	p = NewPipeline("test")
+	nums = Source(p, "read", func(ctx context.Context, emit func(int) error) error {
+		return nil
+	})
+	Sink(nums, "w1", 1, func(ctx context.Context, item int) error { return nil })
+	Sink(nums, "w2", 1, func(ctx context.Context, item int) error { return nil })
+	_, err = p.Run(context.Background())
+	assert.ExpectErr(t, err, "consumed twice")
+}
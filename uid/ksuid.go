@@ -0,0 +1,130 @@
+package uid
+
+import (
+	crand "crypto/rand"
+	"fmt"
+	"io"
+	"time"
+)
+
+const (
+	// KSUIDEpoch is the epoch of KSUID, 2014-05-13T16:53:20Z
+	KSUIDEpoch = 1400000000
+
+	ksuidEncodedSize = 27
+	base62           = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+)
+
+/**
+ * KSUID is a K-sortable unique identifier
+ * https://github.com/segmentio/ksuid
+ *
+ * 32 bits of the seconds since KSUIDEpoch, followed by 128 random bits,
+ * encoded into 27 characters in base62. Compare with ULID, it is sorted
+ * by seconds only, and not monotonic within a second.
+ */
+type KSUID [20]byte
+
+// NewKSUID returns a KSUID of now
+// It panics if crypto/rand fails, which should never happen.
+func NewKSUID() KSUID {
+	k, err := NewKSUIDAt(now(), crand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	return k
+}
+
+// NewKSUIDAt returns a KSUID of the time with the payload from entropy
+func NewKSUIDAt(t time.Time, entropy io.Reader) (k KSUID, err error) {
+	ts := t.Unix() - KSUIDEpoch
+	if ts < 0 || ts > 1<<32-1 {
+		return k, fmt.Errorf("%w: time %v", ErrOverflow, t)
+	}
+	k[0], k[1], k[2], k[3] = byte(ts>>24), byte(ts>>16), byte(ts>>8), byte(ts)
+	if _, err = io.ReadFull(entropy, k[4:]); err != nil {
+		return KSUID{}, err
+	}
+	return k, nil
+}
+
+// Time returns the embedded timestamp, in seconds
+func (k KSUID) Time() time.Time {
+	ts := uint32(k[0])<<24 | uint32(k[1])<<16 | uint32(k[2])<<8 | uint32(k[3])
+	return time.Unix(int64(ts)+KSUIDEpoch, 0)
+}
+
+// Payload returns the random part
+func (k KSUID) Payload() []byte {
+	p := make([]byte, 16)
+	copy(p, k[4:])
+	return p
+}
+
+func (k KSUID) IsZero() bool {
+	return k == KSUID{}
+}
+
+func (k KSUID) String() string {
+	// base conversion on 32 bits words, from 2^32 to 62
+	words := make([]uint32, 5)
+	for i := range words {
+		words[i] = uint32(k[i*4])<<24 | uint32(k[i*4+1])<<16 | uint32(k[i*4+2])<<8 | uint32(k[i*4+3])
+	}
+	b := make([]byte, ksuidEncodedSize)
+	for i := len(b) - 1; i >= 0; i-- {
+		var rem uint64
+		for w := range words {
+			v := rem<<32 | uint64(words[w])
+			words[w] = uint32(v / 62)
+			rem = v % 62
+		}
+		b[i] = base62[rem]
+	}
+	return string(b)
+}
+
+func (k KSUID) MarshalText() ([]byte, error) {
+	return []byte(k.String()), nil
+}
+
+func (k *KSUID) UnmarshalText(text []byte) error {
+	v, err := ParseKSUID(string(text))
+	if err != nil {
+		return err
+	}
+	*k = v
+	return nil
+}
+
+// ParseKSUID parses a KSUID string, case sensitive
+func ParseKSUID(s string) (k KSUID, err error) {
+	if len(s) != ksuidEncodedSize {
+		return k, fmt.Errorf("%w: %d characters for a KSUID", ErrInvalidLength, len(s))
+	}
+	// k = k * 62 + digit, on bytes
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		var d int
+		switch {
+		case c >= '0' && c <= '9':
+			d = int(c - '0')
+		case c >= 'A' && c <= 'Z':
+			d = int(c-'A') + 10
+		case c >= 'a' && c <= 'z':
+			d = int(c-'a') + 36
+		default:
+			return KSUID{}, fmt.Errorf("%w: %q in %q", ErrInvalidChar, c, s)
+		}
+		carry := d
+		for j := len(k) - 1; j >= 0; j-- {
+			v := int(k[j])*62 + carry
+			k[j] = byte(v)
+			carry = v >> 8
+		}
+		if carry != 0 {
+			return KSUID{}, fmt.Errorf("%w: %q exceeds the max KSUID", ErrOverflow, s)
+		}
+	}
+	return k, nil
+}
@@ -0,0 +1,47 @@
+package uid
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/argcv/stork/assert"
+)
+
+func TestKSUID(t *testing.T) {
+	zero := KSUID{}
+	assert.ExpectEQ(t, "000000000000000000000000000", zero.String())
+	max := KSUID{}
+	for i := range max {
+		max[i] = 0xff
+	}
+	assert.ExpectEQ(t, "aWgEPTl1tmebfsQzFP4bxwgy80V", max.String())
+	_, err := ParseKSUID("aWgEPTl1tmebfsQzFP4bxwgy80W")
+	assert.ExpectTrue(t, errors.Is(err, ErrOverflow))
+	_, err = ParseKSUID("aWgEPTl1tmebfsQzFP4bxwgy80-")
+	assert.ExpectTrue(t, errors.Is(err, ErrInvalidChar))
+	_, err = ParseKSUID("abc")
+	assert.ExpectTrue(t, errors.Is(err, ErrInvalidLength))
+
+	ts := time.Unix(1600000000, 0)
+	k, err := NewKSUIDAt(ts, bytes.NewReader(make([]byte, 16)))
+	assert.ExpectNoErr(t, err)
+	assert.ExpectTrue(t, ts.Equal(k.Time()))
+	assert.ExpectEQ(t, make([]byte, 16), k.Payload())
+
+	var ids []string
+	for i := 0; i < 100; i++ {
+		k := NewKSUID()
+		p, err := ParseKSUID(k.String())
+		assert.ExpectNoErr(t, err)
+		assert.ExpectEQ(t, k, p)
+		k2, _ := NewKSUIDAt(k.Time().Add(time.Duration(i)*time.Second), bytes.NewReader(make([]byte, 16)))
+		ids = append(ids, k2.String())
+	}
+	assert.ExpectTrue(t, sort.StringsAreSorted(ids))
+
+	_, err = NewKSUIDAt(time.Unix(0, 0), bytes.NewReader(make([]byte, 16)))
+	assert.ExpectTrue(t, errors.Is(err, ErrOverflow))
+}
@@ -0,0 +1,145 @@
+package uid
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	snowflakeNodeBits = 10
+	snowflakeSeqBits  = 12
+	snowflakeTimeBits = 63 - snowflakeNodeBits - snowflakeSeqBits
+
+	MaxSnowflakeNode = 1<<snowflakeNodeBits - 1
+	maxSnowflakeSeq  = 1<<snowflakeSeqBits - 1
+)
+
+// DefaultSnowflakeEpoch is the default epoch of the snowflake ids
+var DefaultSnowflakeEpoch = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// SnowflakeParts are the fields embedded in a snowflake id
+type SnowflakeParts struct {
+	Time     time.Time
+	Node     int64
+	Sequence int64
+}
+
+func (p SnowflakeParts) String() string {
+	return fmt.Sprintf("time=%s node=%d seq=%d", p.Time.UTC().Format(time.RFC3339Nano), p.Node, p.Sequence)
+}
+
+/**
+ * Snowflake generates the twitter snowflake style 63 bits ids:
+ *
+ * 41 bits of the milliseconds since the epoch (about 69 years),
+ * 10 bits of the node id, and 12 bits of the sequence in a millisecond.
+ *
+ * The ids of a generator are strictly increasing. If the clock goes
+ * backwards, or the 4096 ids of a millisecond are used up, the generator
+ * keeps going on the later milliseconds, so the embedded time may be a
+ * bit ahead of the clock under a heavy load.
+ */
+type Snowflake struct {
+	node  int64
+	epoch int64 // in milliseconds
+
+	mx   sync.Mutex // guards the following block
+	last int64      // the last milliseconds since the epoch
+	seq  int64
+}
+
+// NewSnowflake creates a generator for the node in [0, MaxSnowflakeNode]
+func NewSnowflake(node int64) (*Snowflake, error) {
+	if node < 0 || node > MaxSnowflakeNode {
+		return nil, fmt.Errorf("%w: %d not in [0, %d]", ErrInvalidNode, node, MaxSnowflakeNode)
+	}
+	return &Snowflake{
+		node:  node,
+		epoch: DefaultSnowflakeEpoch.UnixMilli(),
+		last:  -1,
+	}, nil
+}
+
+// SetEpoch sets the epoch, it should be called before generating the ids,
+// and kept the same for all the nodes
+func (s *Snowflake) SetEpoch(epoch time.Time) *Snowflake {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	s.epoch = epoch.UnixMilli()
+	s.last = -1
+	return s
+}
+
+func (s *Snowflake) Node() int64 {
+	return s.node
+}
+
+func (s *Snowflake) Epoch() time.Time {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	return time.UnixMilli(s.epoch)
+}
+
+// Next returns a new id, ErrOverflow is returned if the clock is before
+// the epoch, or after the 41 bits
+func (s *Snowflake) Next() (int64, error) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	ms := now().UnixMilli() - s.epoch
+	if ms < s.last {
+		// the clock goes backwards
+		ms = s.last
+	}
+	var seq int64
+	if ms == s.last {
+		seq = s.seq + 1
+		if seq > maxSnowflakeSeq {
+			// borrow the next millisecond
+			ms++
+			seq = 0
+		}
+	}
+	if ms < 0 || ms >= 1<<snowflakeTimeBits {
+		// the state is kept on error
+		return 0, fmt.Errorf("%w: %d ms since the epoch", ErrOverflow, ms)
+	}
+	s.last, s.seq = ms, seq
+	return ms<<(snowflakeNodeBits+snowflakeSeqBits) | s.node<<snowflakeSeqBits | seq, nil
+}
+
+// MustNext is Next, but panics on error
+func (s *Snowflake) MustNext() int64 {
+	id, err := s.Next()
+	if err != nil {
+		panic(err)
+	}
+	return id
+}
+
+// Inspect decodes an id with the epoch of the generator
+func (s *Snowflake) Inspect(id int64) SnowflakeParts {
+	return InspectSnowflake(id, s.Epoch())
+}
+
+// InspectSnowflake decodes an id generated with the epoch
+func InspectSnowflake(id int64, epoch time.Time) SnowflakeParts {
+	return SnowflakeParts{
+		Time:     time.UnixMilli(epoch.UnixMilli() + id>>(snowflakeNodeBits+snowflakeSeqBits)),
+		Node:     id >> snowflakeSeqBits & MaxSnowflakeNode,
+		Sequence: id & maxSnowflakeSeq,
+	}
+}
+
+// ParseSnowflake parses an id in decimal, and decodes it with the epoch
+func ParseSnowflake(s string, epoch time.Time) (int64, SnowflakeParts, error) {
+	id, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, SnowflakeParts{}, err
+	}
+	if id < 0 {
+		return 0, SnowflakeParts{}, fmt.Errorf("%w: negative id %d", ErrOverflow, id)
+	}
+	return id, InspectSnowflake(id, epoch), nil
+}
@@ -0,0 +1,80 @@
+package uid
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/argcv/stork/assert"
+)
+
+func TestSnowflake(t *testing.T) {
+	_, err := NewSnowflake(1024)
+	assert.ExpectTrue(t, errors.Is(err, ErrInvalidNode))
+
+	epoch := time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)
+	s, err := NewSnowflake(7)
+	assert.ExpectNoErr(t, err)
+	s.SetEpoch(epoch)
+
+	before := time.Now().Truncate(time.Millisecond)
+	prev := int64(-1)
+	for i := 0; i < 10000; i++ {
+		id := s.MustNext()
+		assert.ExpectLT(t, prev, id)
+		prev = id
+	}
+	p := s.Inspect(prev)
+	assert.ExpectEQ(t, int64(7), p.Node)
+	assert.ExpectFalse(t, p.Time.Before(before))
+
+	id, p2, err := ParseSnowflake("1234567890123", epoch)
+	assert.ExpectNoErr(t, err)
+	assert.ExpectEQ(t, InspectSnowflake(id, epoch), p2)
+	_, _, err = ParseSnowflake("x", epoch)
+	assert.ExpectErr(t, err)
+}
+
+func TestSnowflake_Clock(t *testing.T) {
+	defer func() { now = time.Now }()
+	clock := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	now = func() time.Time { return clock }
+
+	s, _ := NewSnowflake(3)
+	first := s.MustNext()
+	p := s.Inspect(first)
+	assert.ExpectTrue(t, clock.Equal(p.Time))
+	assert.ExpectEQ(t, int64(0), p.Sequence)
+
+	// use up a millisecond, then borrow the next one
+	var id int64
+	for i := 0; i < maxSnowflakeSeq+1; i++ {
+		id = s.MustNext()
+	}
+	p = s.Inspect(id)
+	assert.ExpectTrue(t, clock.Add(time.Millisecond).Equal(p.Time))
+	assert.ExpectEQ(t, int64(0), p.Sequence)
+
+	// backwards
+	clock = clock.Add(-time.Second)
+	next := s.MustNext()
+	assert.ExpectLT(t, id, next)
+	assert.ExpectEQ(t, int64(1), s.Inspect(next).Sequence)
+
+	// before the epoch
+	s.SetEpoch(clock.Add(time.Hour))
+	_, err := s.Next()
+	assert.ExpectTrue(t, errors.Is(err, ErrOverflow))
+
+	// the last millisecond is used up, the sequence is kept on error,
+	// so no id is issued twice
+	s.SetEpoch(clock.Add(-(1<<snowflakeTimeBits - 1) * time.Millisecond))
+	for i := 0; i <= maxSnowflakeSeq; i++ {
+		s.MustNext()
+	}
+	for i := 0; i < 2; i++ {
+		_, err = s.Next()
+		assert.ExpectTrue(t, errors.Is(err, ErrOverflow))
+	}
+	assert.ExpectEQ(t, int64(maxSnowflakeSeq), s.seq)
+}
@@ -0,0 +1,17 @@
+// Package uid generates the sortable unique ids: ULID, snowflake and KSUID
+package uid
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrInvalidLength = errors.New("invalid_length")
+	ErrInvalidChar   = errors.New("invalid_character")
+	ErrOverflow      = errors.New("id_overflow")
+	ErrInvalidNode   = errors.New("invalid_node")
+)
+
+// now is replaced in the tests
+var now = time.Now
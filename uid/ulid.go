@@ -0,0 +1,206 @@
+package uid
+
+import (
+	crand "crypto/rand"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// crockford is the alphabet of Crockford's base32
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+var crockfordDecoding [256]byte
+
+func init() {
+	for i := range crockfordDecoding {
+		crockfordDecoding[i] = 0xff
+	}
+	for i := 0; i < len(crockford); i++ {
+		crockfordDecoding[crockford[i]] = byte(i)
+		crockfordDecoding[crockford[i]|0x20] = byte(i) // lower case
+	}
+	// the confusing characters
+	for _, p := range [][2]byte{{'O', 0}, {'o', 0}, {'I', 1}, {'i', 1}, {'L', 1}, {'l', 1}} {
+		crockfordDecoding[p[0]] = p[1]
+	}
+}
+
+const ulidEncodedSize = 26
+
+/**
+ * ULID is a universally unique lexicographically sortable identifier
+ * https://github.com/ulid/spec
+ *
+ * 48 bits of the milliseconds since the unix epoch, followed by 80 random
+ * bits, encoded into 26 characters in Crockford's base32.
+ */
+type ULID [16]byte
+
+// Time returns the embedded timestamp
+func (u ULID) Time() time.Time {
+	ms := u.Timestamp()
+	return time.UnixMilli(int64(ms))
+}
+
+// Timestamp returns the embedded milliseconds since the unix epoch
+func (u ULID) Timestamp() uint64 {
+	return uint64(u[0])<<40 | uint64(u[1])<<32 | uint64(u[2])<<24 |
+		uint64(u[3])<<16 | uint64(u[4])<<8 | uint64(u[5])
+}
+
+// Entropy returns the random part
+func (u ULID) Entropy() []byte {
+	e := make([]byte, 10)
+	copy(e, u[6:])
+	return e
+}
+
+func (u ULID) IsZero() bool {
+	return u == ULID{}
+}
+
+// Compare returns -1, 0 or 1, the order is the same as the strings
+func (u ULID) Compare(o ULID) int {
+	for i := range u {
+		if u[i] != o[i] {
+			if u[i] < o[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+func (u ULID) String() string {
+	b := make([]byte, ulidEncodedSize)
+	// 130 bits for 128 bits, the first 2 bits are 0
+	for i := range b {
+		var v byte
+		for k := 0; k < 5; k++ {
+			bit := i*5 + k - 2
+			v <<= 1
+			if bit >= 0 {
+				v |= (u[bit/8] >> (7 - bit%8)) & 1
+			}
+		}
+		b[i] = crockford[v]
+	}
+	return string(b)
+}
+
+func (u ULID) MarshalText() ([]byte, error) {
+	return []byte(u.String()), nil
+}
+
+func (u *ULID) UnmarshalText(text []byte) error {
+	v, err := ParseULID(string(text))
+	if err != nil {
+		return err
+	}
+	*u = v
+	return nil
+}
+
+// ParseULID parses a ULID string, case insensitive
+func ParseULID(s string) (u ULID, err error) {
+	if len(s) != ulidEncodedSize {
+		return u, fmt.Errorf("%w: %d characters for a ULID", ErrInvalidLength, len(s))
+	}
+	for i := 0; i < len(s); i++ {
+		v := crockfordDecoding[s[i]]
+		if v == 0xff {
+			return ULID{}, fmt.Errorf("%w: %q in %q", ErrInvalidChar, s[i], s)
+		}
+		if i == 0 && v > 7 {
+			return ULID{}, fmt.Errorf("%w: %q exceeds the max ULID", ErrOverflow, s)
+		}
+		for k := 0; k < 5; k++ {
+			bit := i*5 + k - 2
+			if bit >= 0 && (v>>(4-k))&1 == 1 {
+				u[bit/8] |= 1 << (7 - bit%8)
+			}
+		}
+	}
+	return u, nil
+}
+
+// MustParseULID is ParseULID, but panics on error
+func MustParseULID(s string) ULID {
+	u, err := ParseULID(s)
+	if err != nil {
+		panic(err)
+	}
+	return u
+}
+
+/**
+ * ULIDGenerator generates monotonic ULIDs
+ *
+ * In the same millisecond, the random part of the last ULID is increased
+ * by 1, so the ULIDs from a generator are strictly increasing. If the
+ * clock goes backwards, the last timestamp is kept.
+ */
+type ULIDGenerator struct {
+	mx      sync.Mutex
+	entropy io.Reader
+	last    ULID
+}
+
+// NewULIDGenerator creates a generator, the entropy is crypto/rand if nil
+func NewULIDGenerator(entropy io.Reader) *ULIDGenerator {
+	if entropy == nil {
+		entropy = crand.Reader
+	}
+	return &ULIDGenerator{entropy: entropy}
+}
+
+// New returns a ULID of now
+func (g *ULIDGenerator) New() (ULID, error) {
+	return g.NewAt(now())
+}
+
+// NewAt returns a ULID of the time, it is still greater than the last one
+// ErrOverflow is returned if more than 2^80 ULIDs are in a millisecond
+func (g *ULIDGenerator) NewAt(t time.Time) (u ULID, err error) {
+	ms := uint64(t.UnixMilli())
+	if t.UnixMilli() < 0 || ms >= 1<<48 {
+		return u, fmt.Errorf("%w: time %v", ErrOverflow, t)
+	}
+	g.mx.Lock()
+	defer g.mx.Unlock()
+	if last := g.last.Timestamp(); !g.last.IsZero() && ms <= last {
+		u = g.last
+		// increase the 80 bits entropy
+		for i := len(u) - 1; i >= 6; i-- {
+			u[i]++
+			if u[i] != 0 {
+				g.last = u
+				return u, nil
+			}
+		}
+		return ULID{}, fmt.Errorf("%w: entropy exhausted in a millisecond", ErrOverflow)
+	}
+	for i := 0; i < 6; i++ {
+		u[i] = byte(ms >> (40 - 8*i))
+	}
+	if _, err = io.ReadFull(g.entropy, u[6:]); err != nil {
+		return ULID{}, err
+	}
+	g.last = u
+	return u, nil
+}
+
+var defaultULIDGenerator = NewULIDGenerator(nil)
+
+// NewULID returns a monotonic ULID of now
+// It panics if crypto/rand fails, which should never happen.
+func NewULID() ULID {
+	u, err := defaultULIDGenerator.New()
+	if err != nil {
+		panic(err)
+	}
+	return u
+}
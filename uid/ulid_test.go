@@ -0,0 +1,86 @@
+package uid
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/argcv/stork/assert"
+)
+
+func TestULID_Encoding(t *testing.T) {
+	// from the spec
+	ts := time.UnixMilli(1469918176385)
+	g := NewULIDGenerator(bytes.NewReader(make([]byte, 10)))
+	u, err := g.NewAt(ts)
+	assert.ExpectNoErr(t, err)
+	assert.ExpectEQ(t, "01ARYZ6S410000000000", u.String()[:20])
+	assert.ExpectEQ(t, uint64(1469918176385), u.Timestamp())
+	assert.ExpectTrue(t, ts.Equal(u.Time()))
+
+	max := ULID{}
+	for i := range max {
+		max[i] = 0xff
+	}
+	assert.ExpectEQ(t, "7ZZZZZZZZZZZZZZZZZZZZZZZZZ", max.String())
+
+	for i := 0; i < 100; i++ {
+		u := NewULID()
+		p, err := ParseULID(u.String())
+		assert.ExpectNoErr(t, err)
+		assert.ExpectEQ(t, u, p)
+		p, err = ParseULID(strings.ToLower(u.String()))
+		assert.ExpectNoErr(t, err)
+		assert.ExpectEQ(t, u, p)
+	}
+
+	_, err = ParseULID("01ARYZ6S41")
+	assert.ExpectTrue(t, errors.Is(err, ErrInvalidLength))
+	_, err = ParseULID("01ARYZ6S41000000000000000U")
+	assert.ExpectTrue(t, errors.Is(err, ErrInvalidChar))
+	_, err = ParseULID("8ZZZZZZZZZZZZZZZZZZZZZZZZZ")
+	assert.ExpectTrue(t, errors.Is(err, ErrOverflow))
+	// the confusing characters
+	assert.ExpectEQ(t, MustParseULID("01ARYZ6S4100000000000000I1"), MustParseULID("0LARYZ6S41OOOOOOOOOOOOOO11"))
+
+	type doc struct {
+		ID ULID `json:"id"`
+	}
+	b, err := json.Marshal(doc{ID: u})
+	assert.ExpectNoErr(t, err)
+	d := doc{}
+	assert.ExpectNoErr(t, json.Unmarshal(b, &d))
+	assert.ExpectEQ(t, u, d.ID)
+}
+
+func TestULID_Monotonic(t *testing.T) {
+	ts := time.UnixMilli(1600000000000)
+	g := NewULIDGenerator(nil)
+	var ids []string
+	prev := ULID{}
+	for i := 0; i < 1000; i++ {
+		// the clock goes backwards in the end
+		at := ts
+		if i > 900 {
+			at = ts.Add(-time.Second)
+		}
+		u, err := g.NewAt(at)
+		assert.ExpectNoErr(t, err)
+		assert.ExpectEQ(t, 1, u.Compare(prev))
+		assert.ExpectEQ(t, uint64(1600000000000), u.Timestamp())
+		prev = u
+		ids = append(ids, u.String())
+	}
+	assert.ExpectTrue(t, sort.StringsAreSorted(ids))
+
+	// exhausted
+	g = NewULIDGenerator(bytes.NewReader(bytes.Repeat([]byte{0xff}, 10)))
+	_, err := g.NewAt(ts)
+	assert.ExpectNoErr(t, err)
+	_, err = g.NewAt(ts)
+	assert.ExpectTrue(t, errors.Is(err, ErrOverflow))
+}